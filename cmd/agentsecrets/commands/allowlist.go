@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 
 	"github.com/The-17/agentsecrets/pkg/config"
 	"github.com/The-17/agentsecrets/pkg/keyring"
+	"github.com/The-17/agentsecrets/pkg/proxy"
 	"github.com/The-17/agentsecrets/pkg/ui"
 	"github.com/The-17/agentsecrets/pkg/workspaces"
 )
@@ -48,30 +50,61 @@ var allowlistLogCmd = &cobra.Command{
 	RunE:  runAllowlistLog,
 }
 
+var allowlistPendingCmd = &cobra.Command{
+	Use:   "pending",
+	Short: "List domains agents have requested access to",
+	Long:  `List allowlist requests opened when the proxy blocked a call to a domain outside the allowlist, with the agent's justification.`,
+	RunE:  runAllowlistPending,
+}
+
+var allowlistApproveCmd = &cobra.Command{
+	Use:   "approve <request-id>",
+	Short: "Approve a requested domain and add it to the allowlist",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAllowlistApprove,
+}
+
+var allowlistRejectCmd = &cobra.Command{
+	Use:   "reject <request-id>",
+	Short: "Reject a requested domain",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runAllowlistReject,
+}
+
 func init() {
 	workspaceAllowlistCmd.AddCommand(
 		allowlistAddCmd,
 		allowlistRemoveCmd,
 		allowlistListCmd,
 		allowlistLogCmd,
+		allowlistPendingCmd,
+		allowlistApproveCmd,
+		allowlistRejectCmd,
 	)
 	workspaceCmd.AddCommand(workspaceAllowlistCmd)
 }
 
 func verifyPasswordLocally() error {
+	_, _, err := readPasswordLocally()
+	return err
+}
+
+// readPasswordLocally prompts for the password and checks it against the
+// local hash, returning it with the global config.
+func readPasswordLocally() (string, *config.GlobalConfig, error) {
 	cfg, err := config.LoadGlobalConfig()
 	if err != nil || cfg.Email == "" {
-		return fmt.Errorf("Not logged in.")
+		return "", nil, fmt.Errorf("Not logged in.")
 	}
 
 	if cfg.PasswordHash == "" {
-		return fmt.Errorf("Please run 'agentsecrets login' once to enable secure local password verification for allowlist modifications.")
+		return "", nil, fmt.Errorf("Please run 'agentsecrets login' once to enable secure local password verification for allowlist modifications.")
 	}
 
 	fmt.Print("Enter your AgentSecrets password: ")
 	passwordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
 	if err != nil {
-		return fmt.Errorf("failed to read password: %w", err)
+		return "", nil, fmt.Errorf("failed to read password: %w", err)
 	}
 	fmt.Println() // newline after hidden input
 	password := string(passwordBytes)
@@ -83,10 +116,10 @@ func verifyPasswordLocally() error {
 	if inputHash != cfg.PasswordHash {
 		// Output manually to bypass cobra's default error formatting slightly if needed,
 		// or just return the error.
-		return fmt.Errorf("Incorrect password")
+		return "", nil, fmt.Errorf("Incorrect password")
 	}
 
-	return nil
+	return password, cfg, nil
 }
 
 func syncAllowlistToKeyring(workspaceID string) error {
@@ -264,3 +297,140 @@ func runAllowlistLog(_ *cobra.Command, _ []string) error {
 	fmt.Printf("\n%s\n%s\n\n", ui.BannerStr("Allowlist Logs"), renderedTable)
 	return nil
 }
+
+func runAllowlistPending(_ *cobra.Command, _ []string) error {
+	workspaceID, err := requireWorkspaceID()
+	if err != nil {
+		return err
+	}
+	store, err := proxy.NewDomainRequestStore("")
+	if err != nil {
+		return err
+	}
+	pending, err := store.Pending(workspaceID)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) == 0 {
+		ui.Info("No pending domain requests.")
+		return nil
+	}
+
+	headers := []string{"ID", "Domain", "Agent", "Call", "Justification", "Requested"}
+	rows := make([][]string, len(pending))
+	for i, r := range pending {
+		justification := r.Justification
+		if justification == "" {
+			justification = ui.DimStyle.Render("(none)")
+		}
+		rows[i] = []string{
+			r.ID,
+			r.Domain,
+			ui.DimStyle.Render(r.AgentID),
+			fmt.Sprintf("%s %s", r.Method, r.TargetURL),
+			justification,
+			ui.DimStyle.Render(r.CreatedAt.Local().Format("2006-01-02 15:04")),
+		}
+	}
+
+	renderedTable := ui.RenderTable(headers, rows)
+	fmt.Printf("\n%s\n%s\n\n", ui.BannerStr("Pending Domain Requests"), renderedTable)
+	ui.Info("Run: agentsecrets workspace allowlist approve <id>  (or reject <id>)")
+	return nil
+}
+
+func runAllowlistApprove(_ *cobra.Command, args []string) error {
+	workspaceID, err := requireWorkspaceID()
+	if err != nil {
+		return err
+	}
+	store, r, err := loadDomainRequest(workspaceID, args[0])
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	printDomainRequest(r)
+	fmt.Println()
+
+	if err := verifyPasswordLocally(); err != nil {
+		return err
+	}
+
+	if err := ui.Spinner(fmt.Sprintf("Adding %s to allowlist...", r.Domain), func() error {
+		if err := workspaceService.AddAllowlist(workspaceID, r.Domain); err != nil {
+			if strings.Contains(err.Error(), "403") {
+				return fmt.Errorf("Only workspace admins can modify the allowlist.")
+			}
+			return err
+		}
+		return syncAllowlistToKeyring(workspaceID)
+	}); err != nil {
+		return err
+	}
+
+	if _, err := store.Decide(r.ID, true); err != nil {
+		return err
+	}
+
+	cfg, _ := config.LoadGlobalConfig()
+	wsName := cfg.Workspaces[workspaceID].Name
+	ui.Success(fmt.Sprintf("✓ %s added to %s allowlist", r.Domain, wsName))
+	return nil
+}
+
+func runAllowlistReject(_ *cobra.Command, args []string) error {
+	workspaceID, err := requireWorkspaceID()
+	if err != nil {
+		return err
+	}
+	store, r, err := loadDomainRequest(workspaceID, args[0])
+	if err != nil {
+		return err
+	}
+
+	if err := verifyPasswordLocally(); err != nil {
+		return err
+	}
+
+	if _, err := store.Decide(r.ID, false); err != nil {
+		return err
+	}
+	ui.Success(fmt.Sprintf("Rejected request %s for %s", r.ID, r.Domain))
+	return nil
+}
+
+// loadDomainRequest returns a pending request, refusing ones opened in
+// another workspace so an approval always lands in the allowlist it was asked for.
+func loadDomainRequest(workspaceID, id string) (*proxy.DomainRequestStore, *proxy.DomainRequest, error) {
+	store, err := proxy.NewDomainRequestStore("")
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := store.Get(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.WorkspaceID != workspaceID {
+		return nil, nil, fmt.Errorf("request %s belongs to another workspace. Switch to it with: agentsecrets workspace switch", id)
+	}
+	if r.Status != proxy.ApprovalPending {
+		return nil, nil, fmt.Errorf("request %s was already %s", id, r.Status)
+	}
+	return store, r, nil
+}
+
+func printDomainRequest(r *proxy.DomainRequest) {
+	ui.StatusRow("Request:", r.ID)
+	ui.StatusRow("Domain:", r.Domain)
+	ui.StatusRow("Call:", fmt.Sprintf("%s %s", r.Method, r.TargetURL))
+	ui.StatusRow("Secrets:", strings.Join(r.SecretKeys, ", "))
+	if r.AgentID != "" {
+		ui.StatusRow("Agent:", r.AgentID)
+	}
+	if r.Justification != "" {
+		ui.StatusRow("Reason:", r.Justification)
+	}
+	ui.StatusRow("Requested:", time.Since(r.CreatedAt).Round(time.Second).String()+" ago")
+}
@@ -0,0 +1,174 @@
+package commands
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/huh"
+	"github.com/spf13/cobra"
+
+	"github.com/The-17/agentsecrets/pkg/crypto"
+	"github.com/The-17/agentsecrets/pkg/keyring"
+	"github.com/The-17/agentsecrets/pkg/proxy"
+	"github.com/The-17/agentsecrets/pkg/ui"
+)
+
+var proxyApproveCmd = &cobra.Command{
+	Use:   "approve <id>",
+	Short: "Approve a call held for human approval",
+	Long:  `Approve a call a policy rule held for human approval. Requires your AgentSecrets password, which signs the approval, so an agent cannot approve its own calls.`,
+	Args:  cobra.ExactArgs(1),
+	RunE:  runProxyApprove,
+}
+
+var proxyDenyCmd = &cobra.Command{
+	Use:   "deny <id>",
+	Short: "Deny a call held for human approval",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runProxyDeny,
+}
+
+var proxyApprovalsCmd = &cobra.Command{
+	Use:   "approvals",
+	Short: "Review calls waiting for approval",
+	Long:  `List calls waiting for human approval and approve or deny each one interactively.`,
+	RunE:  runProxyApprovals,
+}
+
+func init() {
+	proxyCmd.AddCommand(proxyApproveCmd, proxyDenyCmd, proxyApprovalsCmd)
+}
+
+func runProxyApprove(cmd *cobra.Command, args []string) error {
+	queue, err := proxy.NewApprovalQueue("")
+	if err != nil {
+		return err
+	}
+	p, err := queue.Get(args[0])
+	if err != nil {
+		ui.Error(err.Error())
+		return nil
+	}
+
+	fmt.Println()
+	printPendingApproval(p)
+	fmt.Println()
+
+	key, err := approvalKeyLocally()
+	if err != nil {
+		ui.Error(err.Error())
+		return nil
+	}
+	if _, err := queue.Decide(p.ID, true, key); err != nil {
+		ui.Error(err.Error())
+		return nil
+	}
+	ui.Success(fmt.Sprintf("Approved %s", p.ID))
+	return nil
+}
+
+func runProxyDeny(cmd *cobra.Command, args []string) error {
+	queue, err := proxy.NewApprovalQueue("")
+	if err != nil {
+		return err
+	}
+	p, err := queue.Decide(args[0], false, nil)
+	if err != nil {
+		ui.Error(err.Error())
+		return nil
+	}
+	ui.Success(fmt.Sprintf("Denied %s %s", p.Method, p.TargetURL))
+	return nil
+}
+
+func runProxyApprovals(cmd *cobra.Command, args []string) error {
+	queue, err := proxy.NewApprovalQueue("")
+	if err != nil {
+		return err
+	}
+	pending, err := queue.Pending()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	ui.Banner("Pending Approvals")
+	ui.Divider()
+	if len(pending) == 0 {
+		ui.Info("No calls are waiting for approval.")
+		fmt.Println()
+		return nil
+	}
+
+	var key ed25519.PrivateKey
+	for _, p := range pending {
+		printPendingApproval(p)
+
+		var choice string
+		form := huh.NewForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Title(fmt.Sprintf("Allow %s %s?", p.Method, p.TargetURL)).
+					Options(
+						huh.NewOption("Approve", "approve"),
+						huh.NewOption("Deny", "deny"),
+						huh.NewOption("Skip", "skip"),
+					).
+					Value(&choice),
+			),
+		)
+		if err := form.Run(); err != nil {
+			return err
+		}
+
+		switch choice {
+		case "approve":
+			if key == nil {
+				if key, err = approvalKeyLocally(); err != nil {
+					ui.Error(err.Error())
+					return nil
+				}
+			}
+			if _, err := queue.Decide(p.ID, true, key); err != nil {
+				ui.Error(err.Error())
+				continue
+			}
+			ui.Success(fmt.Sprintf("Approved %s", p.ID))
+		case "deny":
+			if _, err := queue.Decide(p.ID, false, nil); err != nil {
+				ui.Error(err.Error())
+				continue
+			}
+			ui.Success(fmt.Sprintf("Denied %s", p.ID))
+		}
+		fmt.Println()
+	}
+	return nil
+}
+
+// approvalKeyLocally asks for the password and derives the key approvals are
+// signed with. The proxy only accepts approvals signed with it.
+func approvalKeyLocally() (ed25519.PrivateKey, error) {
+	password, _, err := readPasswordLocally()
+	if err != nil {
+		return nil, err
+	}
+	_, salt, err := keyring.GetApprovalKey()
+	if err != nil || salt == "" {
+		return nil, fmt.Errorf("Please run 'agentsecrets login' once to set up your approval key.")
+	}
+	return crypto.ApprovalKey(password, salt)
+}
+
+func printPendingApproval(p *proxy.PendingApproval) {
+	ui.StatusRow("Request:", p.ID)
+	ui.StatusRow("Call:", fmt.Sprintf("%s %s", p.Method, p.TargetURL))
+	ui.StatusRow("Secrets:", strings.Join(p.SecretKeys, ", "))
+	if p.AgentID != "" {
+		ui.StatusRow("Agent:", p.AgentID)
+	}
+	ui.StatusRow("Rule:", p.Reason)
+	ui.StatusRow("Waiting:", time.Since(p.CreatedAt).Round(time.Second).String())
+}
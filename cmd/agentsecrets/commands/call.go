@@ -2,6 +2,7 @@ package commands
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -21,6 +22,11 @@ var (
 	callQueries  []string // "api_key=SECRET_NAME"
 	callBodyFields []string // "json.path=SECRET_NAME"
 	callFormFields []string // "field=SECRET_NAME"
+	callCaptures   []string // "$.json.path=SECRET_NAME"
+	callDryRun     bool
+	callSelect     string
+	callMaxBytes   int
+	callCursor     string
 )
 
 var callCmd = &cobra.Command{
@@ -49,13 +55,27 @@ var callCmd = &cobra.Command{
 
 	# Multiple injections
 	agentsecrets call --url https://api.example.com/data \
-		--bearer AUTH_TOKEN --header X-Org-ID=ORG_SECRET`,
+		--bearer AUTH_TOKEN --header X-Org-ID=ORG_SECRET
+
+	# Store a session token from the response without printing it
+	agentsecrets call --url https://api.vendor.com/oauth/token \
+		--method POST --form-field client_secret=VENDOR_CLIENT_SECRET \
+		--capture '$.access_token=VENDOR_SESSION'
+
+	# Check the allowlist, policy and injections without sending anything
+	agentsecrets call --url https://api.stripe.com/v1/charges \
+		--method POST --bearer STRIPE_KEY --dry-run
+
+	# Keep only some fields of a large response, 20KB at a time
+	agentsecrets call --url https://api.github.com/repos/o/r/issues --bearer GITHUB_TOKEN \
+		--select '$[*].{number,title}' --max-bytes 20000
+	agentsecrets call --cursor <next cursor printed above>`,
 	SilenceUsage: true,
 	RunE: runCall,
 }
 
 func init() {
-	callCmd.Flags().StringVar(&callURL, "url", "", "Target API URL (required)")
+	callCmd.Flags().StringVar(&callURL, "url", "", "Target API URL (required unless --cursor is given)")
 	callCmd.Flags().StringVar(&callMethod, "method", "GET", "HTTP method")
 	callCmd.Flags().StringVar(&callBody, "body", "", "Request body (JSON string)")
 	callCmd.Flags().StringVar(&callBearer, "bearer", "", "Bearer token secret key name")
@@ -64,10 +84,21 @@ func init() {
 	callCmd.Flags().StringArrayVar(&callQueries, "query", nil, "Query injection: param=SECRET_KEY (repeatable)")
 	callCmd.Flags().StringArrayVar(&callBodyFields, "body-field", nil, "Body injection: json.path=SECRET_KEY (repeatable)")
 	callCmd.Flags().StringArrayVar(&callFormFields, "form-field", nil, "Form injection: field=SECRET_KEY (repeatable)")
-	_ = callCmd.MarkFlagRequired("url")
+	callCmd.Flags().StringArrayVar(&callCaptures, "capture", nil, "Store a response value in the keychain: $.json.path=SECRET_KEY (repeatable)")
+	callCmd.Flags().BoolVar(&callDryRun, "dry-run", false, "Explain what the call would do without sending it")
+	callCmd.Flags().StringVar(&callSelect, "select", "", "Print only part of a JSON response, e.g. '$.data[*].{id,email}'")
+	callCmd.Flags().IntVar(&callMaxBytes, "max-bytes", 0, "Cut the response body to this many bytes and print a cursor for the rest")
+	callCmd.Flags().StringVar(&callCursor, "cursor", "", "Print the next slice of a truncated response instead of making a call")
 }
 
 func runCall(cmd *cobra.Command, args []string) error {
+	if callCursor != "" {
+		return continueCall()
+	}
+	if callURL == "" {
+		return fmt.Errorf("--url is required")
+	}
+
 	// Build injections from flags
 	var injections []proxy.Injection
 
@@ -121,6 +152,15 @@ func runCall(cmd *cobra.Command, args []string) error {
 		)
 	}
 
+	var captures []proxy.Capture
+	for _, c := range callCaptures {
+		path, key, err := splitFlag(c, "capture")
+		if err != nil {
+			return err
+		}
+		captures = append(captures, proxy.Capture{Path: path, SecretKey: key})
+	}
+
 	// Load project config
 	project, err := config.LoadProjectConfig()
 	if err != nil || project.ProjectID == "" {
@@ -144,15 +184,60 @@ func runCall(cmd *cobra.Command, args []string) error {
 		Method:     callMethod,
 		Body:       body,
 		Injections: injections,
+		Captures:   captures,
 		AgentID:    "cli",
+		AgentToken: os.Getenv(proxy.AgentTokenEnv),
+		DryRun:     callDryRun,
+		Select:     callSelect,
+		MaxBytes:   callMaxBytes,
 	})
 	if err != nil {
 		return fmt.Errorf("API call failed: %w", err)
 	}
+	if result.Explain != nil {
+		fmt.Print(result.Explain.String())
+		return nil
+	}
+
+	printCallResult(result)
+	return nil
+}
+
+// continueCall prints the next slice of a response truncated by --max-bytes.
+func continueCall() error {
+	project, err := config.LoadProjectConfig()
+	if err != nil || project.ProjectID == "" {
+		ui.Error("No project configured — run 'agentsecrets init' first")
+		return nil
+	}
+	engine, err := proxy.NewEngine(project.ProjectID)
+	if err != nil {
+		return fmt.Errorf("failed to initialize engine: %w", err)
+	}
+	result, err := engine.Execute(proxy.CallRequest{
+		Cursor:     callCursor,
+		MaxBytes:   callMaxBytes,
+		AgentID:    "cli",
+		AgentToken: os.Getenv(proxy.AgentTokenEnv),
+	})
+	if err != nil {
+		return err
+	}
+	printCallResult(result)
+	return nil
+}
 
+func printCallResult(result *proxy.CallResult) {
 	// Print response (clean stdout for piping)
 	fmt.Printf("HTTP %d\n\n%s\n", result.StatusCode, string(result.Body))
-	return nil
+	if result.Shaping != nil {
+		if notice := result.Shaping.Notice(); notice != "" {
+			fmt.Fprintln(os.Stderr, notice)
+		}
+	}
+	for _, k := range result.Captured {
+		ui.Success(fmt.Sprintf("Captured %s into keychain", k))
+	}
 }
 
 // splitFlag parses "name=value" flag format.
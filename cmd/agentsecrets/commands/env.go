@@ -1,36 +1,93 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"github.com/The-17/agentsecrets/pkg/ui"
 	"github.com/The-17/agentsecrets/pkg/config"
 	"github.com/The-17/agentsecrets/pkg/keyring"
 	"github.com/The-17/agentsecrets/pkg/proxy"
+	"github.com/The-17/agentsecrets/pkg/secrets"
 )
 
+var (
+	envPhantom bool
+	envMask    bool
+	envProfile string
+	envOnly    []string
+	envPrefix  []string
+	envExclude []string
+	envMap     []string // "ENV_NAME=SECRET_KEY"
+	envFiles   []string // "ENV_NAME=SECRET_KEY", value written to a file
+)
+
+// caBundlePaths are the usual locations of the system CA bundle. The phantom
+// proxy's CA is appended to the first one found so the child still trusts
+// every public host it reaches through the untouched tunnel.
+var caBundlePaths = []string{
+	"/etc/ssl/certs/ca-certificates.crt", // Debian/Ubuntu/Alpine
+	"/etc/pki/tls/certs/ca-bundle.crt",   // Fedora/RHEL
+	"/etc/ssl/cert.pem",                  // macOS/OpenBSD
+	"/etc/ssl/ca-bundle.pem",             // openSUSE
+}
+
 func NewEnvCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "env -- <command> [args...]",
+	cmd := &cobra.Command{
+		Use:   "env [flags] -- <command> [args...]",
 		Short: "Inject secrets as environment variables into a child process",
 		Long: `Resolves all secrets from the active project in the OS keychain
 		and injects them as environment variables into the specified command.
 		The command runs normally with secrets available as env vars.
-		Nothing is written to disk. Secrets exist only in the child process memory.`,
+		Nothing is written to disk. Secrets exist only in the child process memory.
+
+		With --phantom, the child gets random placeholder tokens of the same format
+		instead of real values, and HTTPS_PROXY points at an ephemeral local proxy
+		that swaps placeholders for real values only in requests to allowlisted domains.
+
+		With --mask, the child's stdout and stderr are streamed through a filter that
+		replaces every injected value (and its base64, URL, JSON and hex encodings)
+		with [REDACTED_BY_AGENTSECRETS].
+
+		With --file ENV_NAME=SECRET_KEY, the value is written to a private 0600 file
+		(on tmpfs where available) and ENV_NAME is set to its path, for tools that
+		only read credentials from files. The file is shredded when the child exits.
+
+		Use --only, --prefix, --exclude and --map to inject a subset of secrets
+		(or rename them). A default selection can be set per project in
+		.agentsecrets/env.json and is used when no selection flags are given.`,
 		Example: `  agentsecrets env -- stripe mcp
 		agentsecrets env -- node server.js
-		agentsecrets env -- stripe listen --forward-to localhost:3000`,
-		RunE:               runEnv,
-		DisableFlagParsing: true,
+		agentsecrets env -- stripe listen --forward-to localhost:3000
+		agentsecrets env --phantom -- gh api /user
+		agentsecrets env --mask -- printenv
+		agentsecrets env --prefix STRIPE_ -- stripe listen
+		agentsecrets env --only TEST_DB_URL --map DATABASE_URL=TEST_DB_URL -- pytest
+		agentsecrets env --profile test -- npm test
+		agentsecrets env --file GOOGLE_APPLICATION_CREDENTIALS=GCP_SA_JSON -- terraform plan`,
+		RunE: runEnv,
 	}
+	// Stop at the first non-flag argument so the child's own flags pass through untouched.
+	cmd.Flags().SetInterspersed(false)
+	cmd.Flags().BoolVar(&envPhantom, "phantom", false, "Inject placeholder tokens and swap in real values via a local proxy for allowlisted domains")
+	cmd.Flags().BoolVar(&envMask, "mask", false, "Mask injected secret values in the child's stdout and stderr")
+	cmd.Flags().StringVar(&envProfile, "profile", "", "Selection profile from .agentsecrets/env.json")
+	cmd.Flags().StringSliceVar(&envOnly, "only", nil, "Inject only these secret keys (comma-separated)")
+	cmd.Flags().StringSliceVar(&envPrefix, "prefix", nil, "Inject only secret keys with this prefix (repeatable)")
+	cmd.Flags().StringSliceVar(&envExclude, "exclude", nil, "Never inject these secret keys (comma-separated)")
+	cmd.Flags().StringArrayVar(&envMap, "map", nil, "Inject a secret under another name: ENV_NAME=SECRET_KEY (repeatable)")
+	cmd.Flags().StringArrayVar(&envFiles, "file", nil, "Write a secret to a private file and set ENV_NAME to its path: ENV_NAME=SECRET_KEY (repeatable)")
+	return cmd
 }
 
 func runEnv(cmd *cobra.Command, args []string) error {
@@ -48,19 +105,55 @@ func runEnv(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("no active project. Run: agentsecrets project use <name>")
 	}
 
+	// Shred secret files left behind by runs that were killed before cleanup
+	cleanStaleSecretFiles()
+
 	// Resolve all secrets from keychain
-	secrets, err := keyring.GetAllProjectSecrets(project.ProjectID)
+	allSecrets, err := keyring.GetAllProjectSecrets(project.ProjectID)
 	if err != nil {
 		return fmt.Errorf("failed to load secrets from keychain: %w", err)
 	}
 
-	if len(secrets) == 0 {
+	// Narrow down to what this command should see (ENV_NAME → value)
+	profile, err := resolveEnvProfile()
+	if err != nil {
+		return err
+	}
+	secrets, sources, err := profile.Apply(allSecrets)
+	if err != nil {
+		return err
+	}
+	// Proxy-only secrets never reach the child as values. Phantom mode only hands
+	// out placeholders, so they stay usable there, except values too weak for a
+	// placeholder, which phantom mode would pass through as they are.
+	proxyOnlyFrom := sources
+	if envPhantom {
+		proxyOnlyFrom = weakPhantomSources(secrets, sources)
+	}
+	if err := dropProxyOnly(project.ProjectID, profile, secrets, proxyOnlyFrom, args); err != nil {
+		return err
+	}
+	for envName := range sources {
+		if _, ok := secrets[envName]; !ok {
+			delete(sources, envName)
+		}
+	}
+
+	// File-mounted secrets (ENV_NAME → value, and the key each came from)
+	fileValues, fileSources, err := resolveSecretFiles(project.ProjectID, allSecrets, args)
+	if err != nil {
+		return err
+	}
+	dropFileSecrets(profile, secrets, sources, fileSources)
+
+	if len(secrets) == 0 && len(fileValues) == 0 {
 		ui.Warning("No secrets found in active project — running without injection")
-	} else {
+	} else if len(secrets) > 0 {
 		secretKeys := make([]string, 0, len(secrets))
 		for k := range secrets {
 			secretKeys = append(secretKeys, k)
 		}
+		sort.Strings(secretKeys)
 		if len(secretKeys) == 1 {
 			ui.Info(fmt.Sprintf("Injecting 1 secret: %s", secretKeys[0]))
 		} else {
@@ -70,10 +163,44 @@ func runEnv(cmd *cobra.Command, args []string) error {
 
 	// Build environment: parent env + injected secrets
 	env := os.Environ()
-	for key, value := range secrets {
-		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	authStyle := "env_inject"
+	cleanup := func() {}
+	if envPhantom {
+		phantomEnv, stop, err := startPhantomProxy(project, secrets)
+		if err != nil {
+			return err
+		}
+		env = append(env, phantomEnv...)
+		authStyle = "env_phantom"
+		cleanup = stop
+	} else {
+		for key, value := range secrets {
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
 	}
 
+	if len(fileValues) > 0 {
+		files, fileEnv, err := writeSecretFiles(fileValues)
+		if err != nil {
+			cleanup()
+			return err
+		}
+		env = append(env, fileEnv...)
+		stopProxy := cleanup
+		cleanup = func() {
+			files.remove()
+			stopProxy()
+		}
+
+		envNames := make([]string, 0, len(fileValues))
+		for envName := range fileValues {
+			envNames = append(envNames, envName)
+		}
+		sort.Strings(envNames)
+		ui.Info(fmt.Sprintf("Mounting %d secret file(s): %s", len(envNames), strings.Join(envNames, ", ")))
+	}
+	defer cleanup()
+
 	// Resolve command path
 	commandPath, err := exec.LookPath(args[0])
 	if err != nil {
@@ -87,6 +214,33 @@ func runEnv(cmd *cobra.Command, args []string) error {
 	childCmd.Stdout = os.Stdout
 	childCmd.Stderr = os.Stderr
 
+	// Masking replaces the child's terminal with pipes. Stdin stays attached, and
+	// common colour overrides keep styled output when we are on a terminal.
+	var stdoutMask, stderrMask *proxy.MaskingWriter
+	if envMask {
+		masked := make(map[string]string, len(secrets)+len(fileValues))
+		for k, v := range secrets {
+			masked[k] = v
+		}
+		for k, v := range fileValues {
+			masked[k] = v
+		}
+		stdoutMask = proxy.NewMaskingWriter(os.Stdout, masked)
+		stderrMask = proxy.NewMaskingWriter(os.Stderr, masked)
+		if short := stdoutMask.Unmasked(); len(short) > 0 {
+			ui.Warning(fmt.Sprintf("Not masking %s: too short to tell apart from ordinary output, so these values will show as they are", strings.Join(short, ", ")))
+		}
+		childCmd.Stdout = stdoutMask
+		childCmd.Stderr = stderrMask
+		if term.IsTerminal(int(os.Stdout.Fd())) {
+			for _, name := range []string{"FORCE_COLOR", "CLICOLOR_FORCE"} {
+				if _, ok := os.LookupEnv(name); !ok {
+					childCmd.Env = append(childCmd.Env, name+"=1")
+				}
+			}
+		}
+	}
+
 	// Forward signals to child
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -97,18 +251,45 @@ func runEnv(cmd *cobra.Command, args []string) error {
 		}
 	}()
 
-	// Audit log: key names only
+	// Audit log: key names only — which secrets, and under which env var names
 	if len(secrets) > 0 {
-		secretKeys := make([]string, 0, len(secrets))
-		for k := range secrets {
-			secretKeys = append(secretKeys, k)
+		seen := make(map[string]bool)
+		secretKeys := make([]string, 0, len(sources))
+		envVars := make([]string, 0, len(sources))
+		for envName, key := range sources {
+			envVars = append(envVars, envName)
+			if !seen[key] {
+				seen[key] = true
+				secretKeys = append(secretKeys, key)
+			}
+		}
+		sort.Strings(secretKeys)
+		sort.Strings(envVars)
+		auditLog(project, args, secretKeys, envVars, authStyle)
+	}
+	if len(fileSources) > 0 {
+		seen := make(map[string]bool)
+		var secretKeys, envVars []string
+		for envName, key := range fileSources {
+			envVars = append(envVars, envName)
+			if !seen[key] {
+				seen[key] = true
+				secretKeys = append(secretKeys, key)
+			}
 		}
-		auditLog(project, args, secretKeys)
+		sort.Strings(secretKeys)
+		sort.Strings(envVars)
+		auditLog(project, args, secretKeys, envVars, "env_file")
 	}
 
 	// Run and exit with child's exit code
-	if err := childCmd.Run(); err != nil {
+	runErr := childCmd.Run()
+	if envMask {
+		finishMasking(args, stdoutMask, stderrMask)
+	}
+	if err := runErr; err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
+			cleanup() // os.Exit skips deferred calls
 			os.Exit(exitErr.ExitCode())
 		}
 		return err
@@ -117,7 +298,272 @@ func runEnv(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func auditLog(project *config.ProjectConfig, cmdArgs []string, secretKeys []string) {
+// resolveSecretFiles parses --file flags into the values to write (ENV_NAME → value)
+// and the secret key each came from. Proxy-only secrets are refused and audited.
+func resolveSecretFiles(projectID string, allSecrets map[string]string, cmdArgs []string) (map[string]string, map[string]string, error) {
+	values := make(map[string]string)
+	sources := make(map[string]string)
+	var blocked []string
+	for _, f := range envFiles {
+		envName, key, err := splitFlag(f, "file")
+		if err != nil {
+			return nil, nil, err
+		}
+		if !secretFileNameRegex.MatchString(envName) {
+			return nil, nil, fmt.Errorf("--file %s: ENV_NAME must be letters, digits and underscores, not starting with a digit", envName)
+		}
+		value, ok := allSecrets[key]
+		if !ok {
+			return nil, nil, fmt.Errorf("secret %s (for --file %s) not found in project", key, envName)
+		}
+		proxyOnly, err := secrets.IsProxyOnly(projectID, key)
+		if err != nil {
+			return nil, nil, err
+		}
+		if proxyOnly {
+			blocked = append(blocked, key)
+			continue
+		}
+		values[envName] = value
+		sources[envName] = key
+	}
+	if len(blocked) > 0 {
+		sort.Strings(blocked)
+		secrets.AuditBlockedExport(blocked, "ENV", strings.Join(cmdArgs, " "))
+		return nil, nil, &secrets.ProxyOnlyError{Keys: blocked}
+	}
+	return values, sources, nil
+}
+
+// dropProxyOnly removes proxy-only secrets from env and sources and audits the
+// attempt. Asking for one by name (--only, --map or a profile) is an error;
+// otherwise it is skipped with a warning.
+func dropProxyOnly(projectID string, profile *config.EnvProfile, env, sources map[string]string, cmdArgs []string) error {
+	proxyOnly, err := secrets.ProxyOnlyKeys(projectID)
+	if err != nil {
+		return err
+	}
+	if len(proxyOnly) == 0 {
+		return nil
+	}
+
+	explicit := namedKeys(profile)
+	seen := make(map[string]bool)
+	var blocked, named []string
+	for envName, key := range sources {
+		if !proxyOnly[key] {
+			continue
+		}
+		delete(env, envName)
+		delete(sources, envName)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		blocked = append(blocked, key)
+		if explicit[key] {
+			named = append(named, key)
+		}
+	}
+	if len(blocked) == 0 {
+		return nil
+	}
+
+	sort.Strings(blocked)
+	sort.Strings(named)
+	secrets.AuditBlockedExport(blocked, "ENV", strings.Join(cmdArgs, " "))
+	if len(named) > 0 {
+		return &secrets.ProxyOnlyError{Keys: named}
+	}
+	ui.Warning(fmt.Sprintf("Skipping proxy-only secrets: %s (use agentsecrets call or --phantom)", strings.Join(blocked, ", ")))
+	return nil
+}
+
+// weakPhantomSources returns the entries of sources whose values are too weak
+// for a phantom token.
+func weakPhantomSources(env, sources map[string]string) map[string]string {
+	weak := make(map[string]string)
+	for envName, key := range sources {
+		if _, err := proxy.PhantomToken(env[envName]); errors.Is(err, proxy.ErrPhantomWeak) {
+			weak[envName] = key
+		}
+	}
+	return weak
+}
+
+// dropFileSecrets removes from env and sources what the --file mounts replace:
+// the mounted ENV_NAMEs, and any secret a file carries that was only picked up
+// by selecting everything or by prefix. A secret also asked for by name (--only,
+// --map or a profile) stays in the environment.
+func dropFileSecrets(profile *config.EnvProfile, env, sources, fileSources map[string]string) {
+	explicit := namedKeys(profile)
+	mounted := make(map[string]bool, len(fileSources))
+	for envName, key := range fileSources {
+		delete(env, envName)
+		delete(sources, envName)
+		if !explicit[key] {
+			mounted[key] = true
+		}
+	}
+	for envName, key := range sources {
+		if mounted[key] {
+			delete(env, envName)
+			delete(sources, envName)
+		}
+	}
+}
+
+// namedKeys returns the secret keys the profile asks for by name.
+func namedKeys(profile *config.EnvProfile) map[string]bool {
+	named := make(map[string]bool)
+	for _, key := range profile.Only {
+		named[key] = true
+	}
+	for _, key := range profile.Map {
+		named[key] = true
+	}
+	return named
+}
+
+// startPhantomProxy starts an ephemeral phantom proxy for the child process.
+// It returns the env vars to add (placeholders, proxy and CA settings) and a stop
+// function that shuts the proxy down and removes the CA bundle.
+func startPhantomProxy(project *config.ProjectConfig, secrets map[string]string) ([]string, func(), error) {
+	engine, err := proxy.NewEngine(project.ProjectID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize proxy engine: %w", err)
+	}
+
+	p, placeholders, err := proxy.NewPhantomProxy(engine, secrets)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create phantom tokens: %w", err)
+	}
+
+	proxyURL, err := p.Start()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// CreateTemp opens with 0600, so only the current user can read the bundle.
+	caFile, err := os.CreateTemp("", "agentsecrets-ca-*.pem")
+	if err != nil {
+		p.Close()
+		return nil, nil, fmt.Errorf("failed to write proxy CA: %w", err)
+	}
+	for _, path := range caBundlePaths {
+		if system, err := os.ReadFile(path); err == nil {
+			caFile.Write(system)
+			caFile.Write([]byte("\n"))
+			break
+		}
+	}
+	caFile.Write(p.CACertPEM())
+	caFile.Close()
+
+	stop := func() {
+		p.Close()
+		os.Remove(caFile.Name())
+	}
+
+	env := make([]string, 0, len(placeholders)+9)
+	for key, token := range placeholders {
+		env = append(env, fmt.Sprintf("%s=%s", key, token))
+	}
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+		env = append(env, fmt.Sprintf("%s=%s", name, proxyURL))
+	}
+	for _, name := range []string{"SSL_CERT_FILE", "NODE_EXTRA_CA_CERTS", "REQUESTS_CA_BUNDLE", "CURL_CA_BUNDLE", "GIT_SSL_CAINFO"} {
+		env = append(env, fmt.Sprintf("%s=%s", name, caFile.Name()))
+	}
+
+	ui.Info(fmt.Sprintf("Phantom proxy on %s — real values are only sent to allowlisted domains", proxyURL))
+	return env, stop, nil
+}
+
+// resolveEnvProfile builds the secret selection from --profile (or the project's
+// default profile when no selection flags are given) plus any selection flags.
+func resolveEnvProfile() (*config.EnvProfile, error) {
+	flags := &config.EnvProfile{
+		Only:    envOnly,
+		Prefix:  envPrefix,
+		Exclude: envExclude,
+	}
+	for _, m := range envMap {
+		envName, key, err := splitFlag(m, "map")
+		if err != nil {
+			return nil, err
+		}
+		if flags.Map == nil {
+			flags.Map = make(map[string]string)
+		}
+		flags.Map[envName] = key
+	}
+
+	name := envProfile
+	if name == "" && !flags.IsEmpty() {
+		return flags, nil
+	}
+
+	profiles, err := config.LoadEnvProfiles()
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		name = profiles.Default
+	}
+	if name == "" {
+		return flags, nil
+	}
+
+	base, err := profiles.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	profile := &config.EnvProfile{}
+	profile.Merge(base)
+	profile.Merge(flags)
+	return profile, nil
+}
+
+// finishMasking flushes the masking writers and audits which keys were masked.
+func finishMasking(cmdArgs []string, writers ...*proxy.MaskingWriter) {
+	counts := make(map[string]int)
+	for _, w := range writers {
+		_ = w.Flush()
+		for k, n := range w.Counts() {
+			counts[k] += n
+		}
+	}
+	if len(counts) == 0 {
+		return
+	}
+
+	audit, err := proxy.NewAuditLogger("")
+	if err != nil {
+		return // non-critical
+	}
+	defer audit.Close()
+
+	maskedKeys := make([]string, 0, len(counts))
+	for k := range counts {
+		maskedKeys = append(maskedKeys, k)
+	}
+	sort.Strings(maskedKeys)
+
+	_ = audit.Log(proxy.AuditEvent{
+		Timestamp:  time.Now().UTC(),
+		SecretKeys: maskedKeys,
+		Method:     "ENV",
+		TargetURL:  strings.Join(cmdArgs, " "),
+		AuthStyles: []string{"env_mask"},
+		StatusCode: 0,
+		Status:     "OK",
+		Reason:     "output_masked",
+		Redacted:   true,
+	})
+}
+
+func auditLog(project *config.ProjectConfig, cmdArgs []string, secretKeys []string, envVars []string, authStyle string) {
 	audit, err := proxy.NewAuditLogger("")
 	if err != nil {
 		return // non-critical
@@ -129,7 +575,8 @@ func auditLog(project *config.ProjectConfig, cmdArgs []string, secretKeys []stri
 		SecretKeys: secretKeys,
 		Method:     "ENV",
 		TargetURL:  strings.Join(cmdArgs, " "),
-		AuthStyles: []string{"env_inject"},
+		AuthStyles: []string{authStyle},
+		EnvVars:    envVars,
 		StatusCode: 0,
 		Status:     "OK",
 		Reason:     "-",
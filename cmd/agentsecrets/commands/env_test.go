@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"maps"
+	"testing"
+
+	"github.com/The-17/agentsecrets/pkg/config"
+)
+
+func TestDropFileSecrets(t *testing.T) {
+	secrets := map[string]string{"GCP_SA_JSON": "{...}", "KUBECONFIG_STAGING": "apiVersion: v1", "DB_URL": "postgres://"}
+	files := map[string]string{"GOOGLE_APPLICATION_CREDENTIALS": "GCP_SA_JSON", "KUBECONFIG": "KUBECONFIG_STAGING"}
+
+	tests := []struct {
+		name    string
+		profile *config.EnvProfile
+		want    map[string]string // ENV_NAME → secret key left in the environment
+	}{
+		{"everything selected", &config.EnvProfile{}, map[string]string{"DB_URL": "DB_URL"}},
+		{"by prefix", &config.EnvProfile{Prefix: []string{"GCP_", "DB_"}}, map[string]string{"DB_URL": "DB_URL"}},
+		{"named with only", &config.EnvProfile{Only: []string{"GCP_SA_JSON"}}, map[string]string{"GCP_SA_JSON": "GCP_SA_JSON"}},
+		{"named with map", &config.EnvProfile{Map: map[string]string{"SA": "GCP_SA_JSON", "KUBECONFIG": "KUBECONFIG_STAGING"}}, map[string]string{"SA": "GCP_SA_JSON"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, sources, err := tt.profile.Apply(secrets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dropFileSecrets(tt.profile, env, sources, files)
+			if !maps.Equal(sources, tt.want) {
+				t.Errorf("sources = %v, want %v", sources, tt.want)
+			}
+			for envName := range env {
+				if _, ok := tt.want[envName]; !ok {
+					t.Errorf("%s is still in the environment", envName)
+				}
+			}
+		})
+	}
+}
+
+func TestResolveSecretFilesRejectsPathNames(t *testing.T) {
+	defer func(saved []string) { envFiles = saved }(envFiles)
+	all := map[string]string{"GCP_SA_JSON": "{...}"}
+	for _, name := range []string{"../../.bashrc", "a/b", "1ST", "A.B", `C:\x`} {
+		envFiles = []string{name + "=GCP_SA_JSON"}
+		if _, _, err := resolveSecretFiles("proj", all, nil); err == nil {
+			t.Errorf("--file %s=GCP_SA_JSON should be refused", name)
+		}
+	}
+}
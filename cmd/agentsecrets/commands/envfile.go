@@ -0,0 +1,111 @@
+package commands
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// secretFilesPrefix names the per-invocation directories `env --file` creates.
+// The owning PID is part of the name so a later run can tell which are stale.
+const secretFilesPrefix = "agentsecrets-files-"
+
+// secretFileNameRegex is what an ENV_NAME must look like to name a secret
+// file; anything else, such as ../x or a/b, could escape the directory.
+var secretFileNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretFiles is a private directory of 0600 files holding secret values for
+// tools that only read credentials from disk.
+type secretFiles struct {
+	dir string
+}
+
+// secretFilesBase returns where secret files are written: a per-user tmpfs on
+// Linux so values never reach a physical disk, the per-user temp dir elsewhere.
+func secretFilesBase() string {
+	if runtime.GOOS == "linux" {
+		if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() {
+				return dir
+			}
+		}
+		if info, err := os.Stat("/dev/shm"); err == nil && info.IsDir() {
+			return "/dev/shm"
+		}
+	}
+	return os.TempDir()
+}
+
+// writeSecretFiles writes each value (ENV_NAME → value) to its own file and
+// returns the ENV_NAME=path pairs to inject.
+func writeSecretFiles(values map[string]string) (*secretFiles, []string, error) {
+	dir, err := os.MkdirTemp(secretFilesBase(), fmt.Sprintf("%s%d-", secretFilesPrefix, os.Getpid()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("create secret file directory: %w", err)
+	}
+	files := &secretFiles{dir: dir}
+
+	var env []string
+	for envName, value := range values {
+		path := filepath.Join(dir, envName)
+		if err := os.WriteFile(path, []byte(value), 0600); err != nil {
+			files.remove()
+			return nil, nil, fmt.Errorf("write secret file for %s: %w", envName, err)
+		}
+		env = append(env, fmt.Sprintf("%s=%s", envName, path))
+	}
+	return files, env, nil
+}
+
+// remove shreds every file and deletes the directory.
+func (f *secretFiles) remove() {
+	shredDir(f.dir)
+}
+
+// cleanStaleSecretFiles shreds directories left behind by `env --file` runs
+// that were killed before they could clean up.
+func cleanStaleSecretFiles() {
+	base := secretFilesBase()
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), secretFilesPrefix) {
+			continue
+		}
+		pidStr, _, _ := strings.Cut(strings.TrimPrefix(e.Name(), secretFilesPrefix), "-")
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil || processAlive(pid) {
+			continue
+		}
+		shredDir(filepath.Join(base, e.Name()))
+	}
+}
+
+func shredDir(dir string) {
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		shredFile(filepath.Join(dir, e.Name()))
+	}
+	_ = os.RemoveAll(dir)
+}
+
+// shredFile overwrites a file with random bytes before removing it.
+func shredFile(path string) {
+	if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+		if info, err := f.Stat(); err == nil && info.Size() > 0 {
+			junk := make([]byte, info.Size())
+			_, _ = rand.Read(junk)
+			_, _ = f.WriteAt(junk, 0)
+			_ = f.Sync()
+		}
+		f.Close()
+	}
+	_ = os.Remove(path)
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package commands
+
+import "os"
+
+// processAlive reports whether a process with the given PID exists.
+// On Windows FindProcess fails if there is no such process.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
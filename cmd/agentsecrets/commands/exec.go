@@ -10,6 +10,7 @@ import (
 
 	"github.com/The-17/agentsecrets/pkg/config"
 	"github.com/The-17/agentsecrets/pkg/keyring"
+	"github.com/The-17/agentsecrets/pkg/secrets"
 )
 
 type ExecRequest struct {
@@ -83,7 +84,22 @@ func runExec(cmd *cobra.Command, args []string) {
 		project = &config.ProjectConfig{ProjectID: globalProjectID}
 	}
 
+	proxyOnly, err := secrets.ProxyOnlyKeys(project.ProjectID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	var blocked []string
+
 	for _, id := range req.IDs {
+		if proxyOnly[id] {
+			if resp.Errors == nil {
+				resp.Errors = make(map[string]ExecSecretError)
+			}
+			resp.Errors[id] = ExecSecretError{Message: "secret is proxy-only and cannot be exported"}
+			blocked = append(blocked, id)
+			continue
+		}
 		val, err := keyring.GetSecret(project.ProjectID, id)
 		if err != nil || val == "" {
 			if resp.Errors == nil {
@@ -99,6 +115,10 @@ func runExec(cmd *cobra.Command, args []string) {
 		}
 	}
 
+	if len(blocked) > 0 {
+		secrets.AuditBlockedExport(blocked, "EXEC", "exec provider "+req.Provider)
+	}
+
 	out, err := json.Marshal(resp)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to serialize response: %v\n", err)
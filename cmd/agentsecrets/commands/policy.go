@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/The-17/agentsecrets/pkg/proxy"
+	"github.com/The-17/agentsecrets/pkg/ui"
+)
+
+var (
+	policyFile     string
+	policyAgent    string
+	policyToken    string
+	policyMethod   string
+	policyURL      string
+	policySecrets  []string
+	policyBodySize int64
+	policyAt       string
+)
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Check and manage the per-agent proxy policy",
+	Long: `The policy file (.agentsecrets/policy.yaml) grants each agent identity the
+secrets, domains, methods, paths, body sizes and time windows it may use through
+the proxy. It is evaluated before any secret is resolved; denials are audited.`,
+}
+
+var policyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Check a sample request against the policy",
+	Example: `  agentsecrets policy test --agent billing-bot --method POST \
+    --url https://api.stripe.com/v1/charges --secret STRIPE_KEY
+  agentsecrets policy test --agent billing-bot --url https://api.stripe.com/v1/balance \
+    --secret STRIPE_KEY --at 2026-03-07T22:00:00+01:00`,
+	SilenceUsage: true,
+	RunE:         runPolicyTest,
+}
+
+var policyTokenCmd = &cobra.Command{
+	Use:   "token <agent>",
+	Short: "Generate a proxy token for an agent",
+	Long: `Generate a random proxy token for an agent. Put the printed token_sha256 under
+the agent in policy.yaml and give the token to the agent: as X-AS-Agent-Token on
+proxy requests, or as AGENTSECRETS_AGENT_TOKEN for agentsecrets call and mcp serve.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runPolicyToken,
+}
+
+func init() {
+	policyCmd.PersistentFlags().StringVar(&policyFile, "file", proxy.DefaultPolicyPath, "Policy file")
+
+	policyTestCmd.Flags().StringVar(&policyAgent, "agent", "", "Agent ID the request claims (X-AS-Agent-ID)")
+	policyTestCmd.Flags().StringVar(&policyToken, "token", "", "Agent proxy token the request presents")
+	policyTestCmd.Flags().StringVar(&policyMethod, "method", "GET", "HTTP method")
+	policyTestCmd.Flags().StringVar(&policyURL, "url", "", "Target URL (required)")
+	policyTestCmd.Flags().StringSliceVar(&policySecrets, "secret", nil, "Secret key names injected (comma-separated)")
+	policyTestCmd.Flags().Int64Var(&policyBodySize, "body-size", 0, "Request body size in bytes")
+	policyTestCmd.Flags().StringVar(&policyAt, "at", "", "Evaluate at this time (RFC 3339) instead of now")
+	_ = policyTestCmd.MarkFlagRequired("url")
+
+	policyCmd.AddCommand(policyTestCmd)
+	policyCmd.AddCommand(policyTokenCmd)
+}
+
+func runPolicyTest(cmd *cobra.Command, args []string) error {
+	policy, err := proxy.LoadPolicy(policyFile)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		ui.Info(fmt.Sprintf("No policy at %s — every agent is allowed (the allowlist still applies).", policyFile))
+		return nil
+	}
+
+	when := time.Now()
+	if policyAt != "" {
+		if when, err = time.Parse(time.RFC3339, policyAt); err != nil {
+			return fmt.Errorf("--at must be an RFC 3339 time, e.g. 2026-03-07T22:00:00Z")
+		}
+	}
+
+	decision := policy.Evaluate(proxy.PolicyRequest{
+		AgentID:    policyAgent,
+		AgentToken: policyToken,
+		Method:     policyMethod,
+		TargetURL:  policyURL,
+		SecretKeys: policySecrets,
+		BodySize:   policyBodySize,
+		Time:       when,
+	})
+
+	fmt.Println()
+	ui.StatusRow("Agent:", decision.Agent)
+	if decision.Allowed {
+		ui.Success("ALLOW")
+		fmt.Println()
+		return nil
+	}
+	ui.StatusRow("Reason:", decision.Reason)
+	ui.Error(fmt.Sprintf("DENY: %s", decision.Message))
+	fmt.Println()
+	os.Exit(1)
+	return nil
+}
+
+func runPolicyToken(cmd *cobra.Command, args []string) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("generate token: %w", err)
+	}
+	token := "ast_" + base64.RawURLEncoding.EncodeToString(buf)
+
+	fmt.Println()
+	ui.StatusRow("Token:", token)
+	fmt.Println()
+	fmt.Printf("Add to %s:\n\n", policyFile)
+	fmt.Printf("  agents:\n    %s:\n      token_sha256: %s\n\n", args[0], proxy.HashAgentToken(token))
+	ui.Warning("The token is shown once. Anyone holding it acts as " + args[0] + ".")
+	return nil
+}
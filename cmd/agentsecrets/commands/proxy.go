@@ -2,14 +2,19 @@ package commands
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/The-17/agentsecrets/pkg/client"
 	"github.com/The-17/agentsecrets/pkg/config"
 	"github.com/The-17/agentsecrets/pkg/proxy"
 	"github.com/The-17/agentsecrets/pkg/ui"
@@ -17,6 +22,11 @@ import (
 
 var (
 	proxyPort      int
+	proxySocket    string
+	proxyRecord    string
+	proxyReplay    string
+	proxyProjects  []string
+	reloadProject  string
 	logsSecretFlag string
 	logsLastFlag   int
 )
@@ -34,6 +44,13 @@ var proxyStartCmd = &cobra.Command{
 	RunE:  runProxyStart,
 }
 
+var proxyReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload policy, upstream, budget and cache files in the running proxy",
+	Long:  `Make a running proxy re-read each loaded project's .agentsecrets/ files and workspace allowlist. Requests already in flight finish on the previous configuration. The proxy also reloads on SIGHUP and when those files change.`,
+	RunE:  runProxyReload,
+}
+
 var proxyStatusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Check if the proxy is running",
@@ -49,11 +66,23 @@ var proxyLogsCmd = &cobra.Command{
 
 func init() {
 	proxyStartCmd.Flags().IntVar(&proxyPort, "port", 8765, "Port to listen on")
+	proxyStartCmd.Flags().StringVar(&proxySocket, "socket", "", "Listen on this Unix socket instead of a TCP port")
+	proxyStartCmd.Flags().StringVar(&proxyRecord, "record", "", "Record upstream traffic to HAR files in this directory, with secrets masked")
+	proxyStartCmd.Flags().StringVar(&proxyReplay, "replay", "", "Answer from the HAR files in this directory instead of the network")
+	proxyStartCmd.Flags().StringArrayVar(&proxyProjects, "project", nil, "Also serve the project in this directory (repeatable); requests select one with X-AS-Project")
+	proxyStartCmd.MarkFlagsMutuallyExclusive("record", "replay")
+	proxyStartCmd.MarkFlagsMutuallyExclusive("project", "record")
+	proxyStartCmd.MarkFlagsMutuallyExclusive("project", "replay")
+
+	proxyReloadCmd.Flags().IntVar(&proxyPort, "port", 8765, "Port the proxy listens on")
+	proxyReloadCmd.Flags().StringVar(&proxySocket, "socket", "", "Unix socket the proxy listens on")
+	proxyReloadCmd.Flags().StringVar(&reloadProject, "project", "", "Reload only this project (name or ID)")
 
 	proxyLogsCmd.Flags().StringVar(&logsSecretFlag, "secret", "", "Filter logs by secret key name")
 	proxyLogsCmd.Flags().IntVar(&logsLastFlag, "last", 20, "Number of recent log entries to show")
 
 	proxyCmd.AddCommand(proxyStartCmd)
+	proxyCmd.AddCommand(proxyReloadCmd)
 	proxyCmd.AddCommand(proxyStatusCmd)
 	proxyCmd.AddCommand(proxyLogsCmd)
 }
@@ -63,24 +92,115 @@ func runProxyStart(cmd *cobra.Command, args []string) error {
 	ui.Banner("AgentSecrets Proxy")
 	ui.Divider()
 
-	// Load project context
-	project, err := config.LoadProjectConfig()
-	if err != nil || project.ProjectID == "" {
+	// The project in the current directory, if any, is the default
+	projects := &proxy.ProjectSet{}
+	if project, err := config.LoadProjectConfig(); err == nil && project.ProjectID != "" {
+		p, err := projects.Add(".")
+		if err != nil {
+			ui.Error(fmt.Sprintf("Failed to load project: %v", err))
+			return nil
+		}
+		projects.Default = p
+	}
+	for _, dir := range proxyProjects {
+		if _, err := projects.Add(dir); err != nil {
+			ui.Error(fmt.Sprintf("Failed to load project %s: %v", dir, err))
+			return nil
+		}
+	}
+	list := projects.List()
+	if len(list) == 0 {
 		ui.Error("No project found. Run 'agentsecrets project use <name>' first.")
 		return nil
 	}
+	if projects.Default == nil && len(list) == 1 {
+		projects.Default = list[0]
+	}
 
-	ui.StatusRow("Project:", project.ProjectName)
-	ui.StatusRow("Port:", fmt.Sprintf("%d", proxyPort))
+	for _, p := range list {
+		label := p.Name
+		if len(list) > 1 && p == projects.Default {
+			label += " (default)"
+		}
+		ui.StatusRow("Project:", label)
+	}
+	if proxySocket != "" {
+		ui.StatusRow("Socket:", proxySocket)
+	} else {
+		ui.StatusRow("Port:", fmt.Sprintf("%d", proxyPort))
+	}
+
+	switch {
+	case proxyRecord != "":
+		projects.Setup = func(e *proxy.Engine) error { return e.Record(proxyRecord) }
+		ui.StatusRow("Recording to:", proxyRecord)
+	case proxyReplay != "":
+		projects.Setup = func(e *proxy.Engine) error { return e.Replay(proxyReplay) }
+		ui.StatusRow("Replaying from:", proxyReplay)
+	}
 	fmt.Println()
 
-	engine, err := proxy.NewEngine(project.ProjectID)
+	// Other projects load on their first request; the default fails now
+	if projects.Default != nil {
+		if _, err := projects.Default.Engine(); err != nil {
+			ui.Error(fmt.Sprintf("Failed to initialize proxy engine: %v", err))
+			return nil
+		}
+	}
+
+	server := proxy.NewProjectServer(proxyPort, projects)
+	tokenPath, err := proxy.AdminTokenPath(proxyPort, proxySocket)
+	if err == nil {
+		server.AdminToken, err = proxy.NewAdminToken(tokenPath)
+	}
 	if err != nil {
-		ui.Error(fmt.Sprintf("Failed to initialize proxy engine: %v", err))
-		return nil
+		ui.Warning(fmt.Sprintf("Reloading over /admin/reload is disabled: %v", err))
+	} else {
+		defer os.Remove(tokenPath)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				for _, report := range projects.Reload("sighup") {
+					printReload(report)
+				}
+			}
+		}
+	}()
+	go projects.Watch(ctx, proxy.DefaultWatchInterval, printReload)
+
+	return serveProxy(server)
+}
+
+// printReload reports a reload while the proxy runs.
+func printReload(report *proxy.ReloadReport) {
+	if report.Error != "" {
+		ui.Error(fmt.Sprintf("Reload of %s failed, keeping the previous configuration: %s", report.Project, report.Error))
+		return
 	}
+	if len(report.Changes) == 0 {
+		ui.Info(fmt.Sprintf("Reloaded %s: no changes", report.Project))
+		return
+	}
+	ui.Success(fmt.Sprintf("Reloaded %s: %s", report.Project, strings.Join(report.Changes, "; ")))
+}
 
-	server := proxy.NewServer(proxyPort, engine)
+func serveProxy(server *proxy.Server) error {
+	if proxySocket != "" {
+		ui.Success(fmt.Sprintf("Proxy listening on unix:%s", proxySocket))
+		ui.Info("Press Ctrl+C to stop")
+		fmt.Println()
+		return server.StartUnix(proxySocket)
+	}
 
 	ui.Success(fmt.Sprintf("Proxy listening on http://localhost:%d/proxy", proxyPort))
 	ui.Info("Press Ctrl+C to stop")
@@ -89,6 +209,61 @@ func runProxyStart(cmd *cobra.Command, args []string) error {
 	return server.Start()
 }
 
+// runProxyReload asks a running proxy to reload its projects' files, with
+// the admin token `proxy start` wrote.
+func runProxyReload(cmd *cobra.Command, args []string) error {
+	tokenPath, err := proxy.AdminTokenPath(proxyPort, proxySocket)
+	if err != nil {
+		return err
+	}
+	token, err := os.ReadFile(tokenPath)
+	if err != nil {
+		return fmt.Errorf("no running proxy found (%s is missing); is 'agentsecrets proxy start' running with the same --port or --socket?", tokenPath)
+	}
+
+	c := client.New(fmt.Sprintf("http://localhost:%d", proxyPort))
+	if proxySocket != "" {
+		c = client.NewUnix(proxySocket)
+	}
+	req, err := http.NewRequestWithContext(cmd.Context(), http.MethodPost, c.BaseURL+"/admin/reload", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+string(token))
+	if reloadProject != "" {
+		req.Header.Set(proxy.ProjectHeader, reloadProject)
+	}
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the proxy: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Reloaded []*proxy.ReloadReport `json:"reloaded"`
+		Error    string                `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return fmt.Errorf("unexpected response from the proxy (%d): %w", resp.StatusCode, err)
+	}
+	if body.Error != "" {
+		return fmt.Errorf("reload refused: %s", body.Error)
+	}
+
+	fmt.Println()
+	if len(body.Reloaded) == 0 {
+		ui.Info("No project is loaded yet; each reads its files on its first request.")
+	}
+	for _, report := range body.Reloaded {
+		printReload(report)
+	}
+	fmt.Println()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("reload failed")
+	}
+	return nil
+}
+
 func runProxyStatus(cmd *cobra.Command, args []string) error {
 	fmt.Println()
 	ui.Banner("Proxy Status")
@@ -196,6 +371,10 @@ func runProxyLogs(cmd *cobra.Command, args []string) error {
 			statusStr = ui.ErrorStyle.Render("✗ BLOCK")
 		} else if statusStr == "OK" {
 			statusStr = ui.SuccessStyle.Render("✓ OK")
+		} else if statusStr == "CACHED" {
+			statusStr = ui.SuccessStyle.Render("✓ CACHE")
+		} else if statusStr == "FAILED" {
+			statusStr = ui.ErrorStyle.Render("✗ FAIL")
 		} else {
 			statusStr = "✓ OK" // backward compat for old logs
 		}
@@ -72,6 +72,7 @@ func init() {
 	rootCmd.AddCommand(projectCmd)
 	rootCmd.AddCommand(secretsCmd)
 	rootCmd.AddCommand(proxyCmd)
+	rootCmd.AddCommand(policyCmd)
 	rootCmd.AddCommand(mcpCmd)
 	rootCmd.AddCommand(callCmd)
 	rootCmd.AddCommand(NewEnvCmd())
@@ -19,6 +19,7 @@ var (
 	secretsService *secrets.Service
 	pullForce      bool
 	pushForce      bool
+	setClass       string
 )
 
 // InitSecretsService sets up the service for the CLI
@@ -35,7 +36,12 @@ var secretsCmd = &cobra.Command{
 var secretsSetCmd = &cobra.Command{
 	Use:   "set KEY=VALUE [KEY2=VALUE2...]",
 	Short: "Add or update one or more secrets",
-	Args:  cobra.MinimumNArgs(1),
+	Long: `Add or update one or more secrets.
+
+Use --class proxy-only for secrets that should only ever be injected by the proxy.
+Proxy-only secrets are refused by env, exec, secrets get and .env files on every
+workspace member's machine. Use --class default to make a secret exportable again.`,
+	Args: cobra.MinimumNArgs(1),
 	RunE:  runSecretsSet,
 }
 
@@ -78,6 +84,7 @@ var secretsDiffCmd = &cobra.Command{
 }
 
 func init() {
+	secretsSetCmd.Flags().StringVar(&setClass, "class", "", "Sensitivity class: proxy-only or default (unchanged if omitted)")
 	secretsPullCmd.Flags().BoolVarP(&pullForce, "force", "f", false, "Overwrite local changes without prompting")
 	secretsPushCmd.Flags().BoolVarP(&pushForce, "force", "f", false, "Push without prompting for missing keys")
 
@@ -107,15 +114,28 @@ func runSecretsSet(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
+	class := ""
+	if setClass != "" {
+		var err error
+		if class, err = secrets.ParseClass(setClass); err != nil {
+			ui.Error(err.Error())
+			return nil
+		}
+	}
+
 	if err := ui.Spinner(fmt.Sprintf("Encrypting and syncing %d secrets...", len(kv)), func() error {
-		return secretsService.BatchSet(kv)
+		return secretsService.BatchSetClass(kv, class)
 	}); err != nil {
 		ui.Error(fmt.Sprintf("Failed to set secrets: %v", err))
 		return nil
 	}
 
 	for k := range kv {
-		ui.Success(fmt.Sprintf("Set %s", k))
+		if class == secrets.ClassProxyOnly {
+			ui.Success(fmt.Sprintf("Set %s (proxy-only)", k))
+		} else {
+			ui.Success(fmt.Sprintf("Set %s", k))
+		}
 	}
 	return nil
 }
@@ -152,11 +172,15 @@ func runSecretsList(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	headers := []string{"Key"}
+	headers := []string{"Key", "Class"}
 
 	rows := make([][]string, len(list))
 	for i, s := range list {
-		rows[i] = []string{ui.BrandStyle.Render(s.Key)}
+		class := ""
+		if s.Class == secrets.ClassProxyOnly {
+			class = ui.WarningStyle.Render(s.Class)
+		}
+		rows[i] = []string{ui.BrandStyle.Render(s.Key), class}
 	}
 
 	renderedTable := ui.RenderTable(headers, rows)
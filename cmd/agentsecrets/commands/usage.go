@@ -0,0 +1,178 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/The-17/agentsecrets/pkg/proxy"
+	"github.com/The-17/agentsecrets/pkg/ui"
+)
+
+var usageByFlag string
+
+var proxyUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Report LLM token usage, cost and budgets",
+	Long: `Report the tokens and cost of LLM API calls made through the proxy this month,
+from the audit log, and how much of each budget in budget.yaml is used.`,
+	RunE: runProxyUsage,
+}
+
+func init() {
+	proxyUsageCmd.Flags().StringVar(&usageByFlag, "by", "secret", "Group usage by secret, agent or model")
+	proxyCmd.AddCommand(proxyUsageCmd)
+}
+
+func runProxyUsage(cmd *cobra.Command, args []string) error {
+	if usageByFlag != "secret" && usageByFlag != "agent" && usageByFlag != "model" {
+		return fmt.Errorf("--by must be secret, agent or model")
+	}
+
+	fmt.Println()
+	ui.Banner("LLM Usage")
+
+	logPath, err := proxy.DefaultLogPath()
+	if err != nil {
+		ui.Error("Could not determine log file path")
+		return nil
+	}
+	now := time.Now().UTC()
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	today := now.Truncate(24 * time.Hour)
+
+	var events []proxy.AuditEvent
+	if f, err := os.Open(logPath); err == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var event proxy.AuditEvent
+			if json.Unmarshal(scanner.Bytes(), &event) != nil || event.Usage == nil || event.Timestamp.Before(monthStart) {
+				continue
+			}
+			events = append(events, event)
+		}
+		f.Close()
+	}
+
+	if len(events) == 0 {
+		ui.Info("No LLM usage recorded this month.")
+		fmt.Println()
+	} else {
+		type group struct {
+			calls, input, output int64
+			costToday, costMonth float64
+			unpriced             bool
+		}
+		groups := make(map[string]*group)
+		for _, e := range events {
+			key := e.Usage.Model
+			switch usageByFlag {
+			case "secret":
+				key = strings.Join(e.SecretKeys, ", ")
+			case "agent":
+				key = e.AgentID
+			}
+			if key == "" {
+				key = "-"
+			}
+			g := groups[key]
+			if g == nil {
+				g = &group{}
+				groups[key] = g
+			}
+			g.calls++
+			g.input += e.Usage.InputTokens
+			g.output += e.Usage.OutputTokens
+			g.costMonth += e.Usage.CostUSD
+			if !e.Timestamp.Before(today) {
+				g.costToday += e.Usage.CostUSD
+			}
+			g.unpriced = g.unpriced || e.Usage.Unpriced
+		}
+
+		keys := make([]string, 0, len(groups))
+		for k := range groups {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return groups[keys[i]].costMonth > groups[keys[j]].costMonth })
+
+		headers := []string{strings.ToUpper(usageByFlag[:1]) + usageByFlag[1:], "Calls", "Input", "Output", "Today", "This month"}
+		var rows [][]string
+		var anyUnpriced bool
+		for _, k := range keys {
+			g := groups[k]
+			month := fmt.Sprintf("$%.2f", g.costMonth)
+			if g.unpriced {
+				month += "*"
+				anyUnpriced = true
+			}
+			rows = append(rows, []string{
+				k,
+				fmt.Sprintf("%d", g.calls),
+				fmt.Sprintf("%d", g.input),
+				fmt.Sprintf("%d", g.output),
+				fmt.Sprintf("$%.2f", g.costToday),
+				month,
+			})
+		}
+		fmt.Printf("%s\n", ui.RenderTable(headers, rows))
+		if anyUnpriced {
+			ui.Info("* includes models with no price; add them to prices in budget.yaml")
+		}
+		fmt.Println()
+	}
+
+	budget, err := proxy.LoadBudgetConfigs()
+	if err != nil {
+		ui.Error(err.Error())
+		return nil
+	}
+	if budget == nil || len(budget.Budgets) == 0 {
+		ui.Info("No budgets set. Add them to .agentsecrets/budget.yaml to cap LLM spend.")
+		fmt.Println()
+		return nil
+	}
+
+	ui.Banner("Budgets")
+	var rows [][]string
+	for _, b := range budget.Budgets {
+		today, month := b.Spent(events, now)
+		rows = append(rows, []string{
+			b.String(),
+			budgetCell(today.CostUSD, b.Daily, float64(today.Tokens), float64(b.DailyTokens)),
+			budgetCell(month.CostUSD, b.Monthly, float64(month.Tokens), float64(b.MonthlyTokens)),
+		})
+	}
+	fmt.Printf("%s\n", ui.RenderTable([]string{"Budget", "Today", "This month"}, rows))
+	fmt.Println()
+	return nil
+}
+
+// budgetCell renders spend against the cost and token limits that are set.
+func budgetCell(cost, costLimit, tokens, tokenLimit float64) string {
+	var parts []string
+	if costLimit > 0 {
+		parts = append(parts, usedUp(fmt.Sprintf("$%.2f / $%.2f", cost, costLimit), cost >= costLimit))
+	}
+	if tokenLimit > 0 {
+		parts = append(parts, usedUp(fmt.Sprintf("%.0f / %.0f tokens", tokens, tokenLimit), tokens >= tokenLimit))
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func usedUp(cell string, exceeded bool) string {
+	if exceeded {
+		return ui.ErrorStyle.Render(cell + " (used up)")
+	}
+	return cell
+}
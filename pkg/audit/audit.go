@@ -0,0 +1,165 @@
+// Package audit writes and reads the audit log that every use of a secret
+// value is recorded in: proxied calls, env and exec exports, and refused reads.
+// It has no dependencies beyond the standard library, so any package can log.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Event records a single proxied API call, or another use of secrets such as
+// an export or a refused read.
+// Secret KEY NAMES are logged. Secret VALUES are NEVER logged.
+type Event struct {
+	Timestamp  time.Time `json:"timestamp"`
+	SecretKeys []string  `json:"secret_keys"`        // KEY NAMES e.g. ["STRIPE_SECRET_KEY"]
+	AgentID    string    `json:"agent_id,omitempty"` // from agent identification
+	Method     string    `json:"method"`
+	TargetURL  string    `json:"target_url"`
+	Domain     string    `json:"domain,omitempty"` // Target domain (e.g. "api.stripe.com")
+	AuthStyles []string  `json:"auth_styles"`      // e.g. ["bearer"]
+	StatusCode int       `json:"status_code"`
+	DurationMs int64     `json:"duration_ms"`
+	Status     string    `json:"status"`           // "OK", "BLOCKED" or "CACHED"; "FAILED" for a reload or a refused WebSocket handshake
+	Reason     string    `json:"reason,omitempty"` // "domain_not_in_allowlist" or "-"
+	Redacted   bool      `json:"redacted"`
+	// CapturedKeys lists KEY NAMES stored from the response body (never the values).
+	CapturedKeys []string `json:"captured_keys,omitempty"`
+	// EnvVars lists the environment variable NAMES secrets were exposed as by `env`.
+	EnvVars []string `json:"env_vars,omitempty"`
+	// ApprovalID links a call to the human approval it was held for.
+	ApprovalID string `json:"approval_id,omitempty"`
+	// DomainRequestID links a blocked call to the allowlist request opened for it.
+	DomainRequestID string `json:"domain_request_id,omitempty"`
+	// Redirects lists the URLs of each redirect hop followed after TargetURL.
+	Redirects []string `json:"redirects,omitempty"`
+	// BytesSent and BytesReceived count streamed (WebSocket, gRPC) traffic to and from the upstream.
+	BytesSent     int64 `json:"bytes_sent,omitempty"`
+	BytesReceived int64 `json:"bytes_received,omitempty"`
+	// GRPCStatus is the grpc-status an RPC ended with.
+	GRPCStatus string `json:"grpc_status,omitempty"`
+	// Usage is the token usage and cost an LLM provider reported.
+	Usage *Usage `json:"usage,omitempty"`
+	// ProjectID is the project whose engine made the call.
+	ProjectID string `json:"project_id,omitempty"`
+	// Changes summarizes what a configuration reload changed.
+	Changes []string `json:"changes,omitempty"`
+}
+
+// Usage is the token usage an LLM provider reported for one call, priced with
+// the budget config's price table.
+type Usage struct {
+	Model        string  `json:"model,omitempty"`
+	InputTokens  int64   `json:"input_tokens"`
+	OutputTokens int64   `json:"output_tokens"`
+	CostUSD      float64 `json:"cost_usd"`
+	Unpriced     bool    `json:"unpriced,omitempty"` // no price for Model; CostUSD is 0
+}
+
+// Tokens is the total of input and output tokens.
+func (u *Usage) Tokens() int64 {
+	return u.InputTokens + u.OutputTokens
+}
+
+// Logger writes Events as JSONL to an append-only log file.
+type Logger struct {
+	// ProjectID is stamped on events that don't name a project.
+	ProjectID string
+
+	file *os.File
+	path string
+	mu   sync.Mutex
+}
+
+// DefaultLogPath returns the default audit log path: ~/.agentsecrets/proxy.log
+func DefaultLogPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	dir := filepath.Join(home, ".agentsecrets")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("cannot create config directory: %w", err)
+	}
+	return filepath.Join(dir, "proxy.log"), nil
+}
+
+// NewLogger creates an audit logger that appends to the given file path.
+// If logPath is empty, the default path (~/.agentsecrets/proxy.log) is used.
+func NewLogger(logPath string) (*Logger, error) {
+	if logPath == "" {
+		var err error
+		logPath, err = DefaultLogPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open audit log: %w", err)
+	}
+
+	return &Logger{file: f, path: logPath}, nil
+}
+
+// SeenDomains returns the domains with at least one successful proxied call
+// in the audit log.
+func (a *Logger) SeenDomains() map[string]bool {
+	seen := make(map[string]bool)
+	f, err := os.Open(a.path)
+	if err != nil {
+		return seen
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+		if event.Status == "OK" && event.Domain != "" {
+			seen[event.Domain] = true
+		}
+	}
+	return seen
+}
+
+// Path returns the log file's path.
+func (a *Logger) Path() string {
+	return a.path
+}
+
+// Log writes a single audit event as a JSON line.
+func (a *Logger) Log(event Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if event.ProjectID == "" {
+		event.ProjectID = a.ProjectID
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal audit event: %w", err)
+	}
+
+	data = append(data, '\n')
+	_, err = a.file.Write(data)
+	return err
+}
+
+// Close closes the underlying log file.
+func (a *Logger) Close() error {
+	if a.file != nil {
+		return a.file.Close()
+	}
+	return nil
+}
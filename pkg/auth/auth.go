@@ -172,6 +172,14 @@ func (s *Service) PerformLogin(email, password string, privateKey, publicKey []b
 		return fmt.Errorf("login: failed to save keypair: %w", err)
 	}
 
+	approvalKey, approvalSalt, err := crypto.NewApprovalKey(password)
+	if err != nil {
+		return fmt.Errorf("login: failed to derive approval key: %w", err)
+	}
+	if err := keyring.SetApprovalKey(approvalKey, approvalSalt); err != nil {
+		return fmt.Errorf("login: failed to save approval key: %w", err)
+	}
+
 	// 4. Decrypt and cache all workspace keys
 	workspaceCache := make(map[string]config.WorkspaceCacheEntry)
 
@@ -0,0 +1,233 @@
+// Package client is a Go SDK for the AgentSecrets proxy.
+//
+// Agents name the secrets to inject; the proxy resolves their values from the
+// OS keychain, so they never enter the agent's process. Client.Call makes one
+// call through the proxy's JSON API, and Client.Transport returns an
+// http.RoundTripper so that existing SDKs can be pointed at the proxy:
+//
+//	c := client.FromEnv()
+//	httpClient := &http.Client{Transport: c.Transport(client.Bearer("STRIPE_KEY"))}
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/The-17/agentsecrets/pkg/proxyapi"
+)
+
+// DefaultAddr is where 'agentsecrets proxy start' listens unless told otherwise.
+const DefaultAddr = "localhost:8765"
+
+// Environment variables read by FromEnv.
+const (
+	// ProxyEnv locates the proxy: host:port, http://host:port, or
+	// unix:/path/to/socket for 'agentsecrets proxy start --socket'.
+	ProxyEnv = "AGENTSECRETS_PROXY"
+	// AgentIDEnv is the agent identifier sent with every call.
+	AgentIDEnv = "AGENTSECRETS_AGENT_ID"
+	// AgentTokenFileEnv names a file holding the agent's proxy token, for
+	// tokens mounted as files rather than set in the environment.
+	AgentTokenFileEnv = "AGENTSECRETS_AGENT_TOKEN_FILE"
+	// ProjectEnv names the project to use on a proxy serving several.
+	ProjectEnv = "AGENTSECRETS_PROJECT"
+)
+
+// Client talks to a running AgentSecrets proxy.
+type Client struct {
+	BaseURL    string // e.g. http://localhost:8765
+	HTTPClient *http.Client
+	AgentID    string // optional, for audit logging and policy
+	AgentToken string // optional proxy token proving the agent identity
+	Project    string // optional project name or ID, for a proxy serving several
+}
+
+// New creates a client for the proxy listening on addr over TCP. An empty
+// addr means DefaultAddr.
+func New(addr string) *Client {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	return &Client{
+		BaseURL:    strings.TrimSuffix(addr, "/"),
+		HTTPClient: &http.Client{},
+	}
+}
+
+// NewUnix creates a client for the proxy listening on the Unix socket at path.
+func NewUnix(path string) *Client {
+	dialer := &net.Dialer{}
+	return &Client{
+		BaseURL: "http://agentsecrets", // the host is ignored; every connection goes to the socket
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		}},
+	}
+}
+
+// FromEnv creates a client configured by the environment: the proxy address
+// from AGENTSECRETS_PROXY (DefaultAddr if unset), the agent ID from
+// AGENTSECRETS_AGENT_ID, the project from AGENTSECRETS_PROJECT, and the proxy
+// token found by DiscoverToken.
+func FromEnv() *Client {
+	var c *Client
+	if addr := os.Getenv(ProxyEnv); strings.HasPrefix(addr, "unix:") {
+		c = NewUnix(strings.TrimPrefix(strings.TrimPrefix(addr, "unix:"), "//"))
+	} else {
+		c = New(addr)
+	}
+	c.AgentID = os.Getenv(AgentIDEnv)
+	c.Project = os.Getenv(ProjectEnv)
+	c.AgentToken, _ = DiscoverToken()
+	return c
+}
+
+// DiscoverToken finds the agent's proxy token: AGENTSECRETS_AGENT_TOKEN, or
+// else the contents of the file named by AGENTSECRETS_AGENT_TOKEN_FILE. It
+// returns "" without an error when neither is set.
+func DiscoverToken() (string, error) {
+	if token := os.Getenv(proxyapi.AgentTokenEnv); token != "" {
+		return token, nil
+	}
+	path := os.Getenv(AgentTokenFileEnv)
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read agent token: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Request is one call to make through the proxy.
+type Request struct {
+	Method  string // GET if empty
+	URL     string
+	Header  http.Header
+	Body    []byte
+	Inject  []Injection // at least one
+	Capture []Capture
+	DryRun  bool // check and build the request without sending it; see Response.Explain
+
+	Select   string // JSON projection of the response body, e.g. "$.data[*].{id,email}"
+	MaxBytes int    // cap on the response body; see Response.Shaping
+	Cursor   string // Response.Shaping.NextCursor of an earlier call; only MaxBytes applies alongside it
+}
+
+// Response is the upstream's response, with secret values redacted.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Redacted   bool     // a secret value was removed from Body
+	Captured   []string // secret keys stored by Request.Capture
+	Cached     bool     // answered from the proxy's response cache
+
+	// Explain is set for dry runs, including ones the proxy would block:
+	// those return a Response rather than a *BlockedError.
+	Explain *proxyapi.Explanation
+
+	// Shaping is set when Select or MaxBytes applied. Its NextCursor fetches
+	// the rest of a truncated body.
+	Shaping *proxyapi.Shaping
+}
+
+// Call makes req through the proxy. A call the proxy refuses returns a
+// *BlockedError, an upstream 429 a *RateLimitedError, and a request the proxy
+// could not handle a *ProxyError; any other upstream response, including
+// error statuses, is returned as is.
+func (c *Client) Call(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := c.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return resp, newRateLimitedError(resp)
+	}
+	return resp, nil
+}
+
+// call makes req and returns the upstream response, or an error for a
+// blocked or failed call.
+func (c *Client) call(ctx context.Context, req *Request) (*Response, error) {
+	body := proxyapi.CallRequest{
+		URL:        req.URL,
+		Method:     req.Method,
+		Headers:    req.Header,
+		AgentID:    c.AgentID,
+		AgentToken: c.AgentToken,
+		DryRun:     req.DryRun,
+		Select:     req.Select,
+		MaxBytes:   req.MaxBytes,
+		Cursor:     req.Cursor,
+		Injections: req.Inject,
+		Captures:   req.Capture,
+	}
+	if len(req.Body) > 0 {
+		body.BodyBase64 = base64.StdEncoding.EncodeToString(req.Body)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/v1/call", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if c.Project != "" {
+		httpReq.Header.Set(proxyapi.ProjectHeader, c.Project)
+	}
+	httpResp, err := c.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("agentsecrets proxy unreachable at %s: %w", c.BaseURL, err)
+	}
+	defer httpResp.Body.Close()
+	respBody, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read proxy response: %w", err)
+	}
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, newProxyError(httpResp.StatusCode, respBody)
+	}
+	var envelope proxyapi.CallResponse
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return nil, fmt.Errorf("parse proxy response: %w", err)
+	}
+
+	resp := &Response{
+		StatusCode: envelope.Status,
+		Header:     http.Header(envelope.Headers),
+		Body:       []byte(envelope.Body),
+		Redacted:   envelope.Redacted,
+		Captured:   envelope.Captured,
+		Explain:    envelope.Explain,
+		Shaping:    envelope.Shaping,
+		Cached:     envelope.Cached,
+	}
+	if envelope.BodyBase64 != "" {
+		if resp.Body, err = base64.StdEncoding.DecodeString(envelope.BodyBase64); err != nil {
+			return nil, fmt.Errorf("parse proxy response: %w", err)
+		}
+	}
+	if envelope.Blocked != "" && envelope.Explain == nil {
+		return nil, newBlockedError(envelope.Blocked, resp.Body)
+	}
+	return resp, nil
+}
@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/The-17/agentsecrets/pkg/proxy"
+)
+
+func testEngine() *proxy.Engine {
+	return &proxy.Engine{
+		ProjectID: "test-project",
+		Client:    &http.Client{},
+		ResolveSecret: func(key string) (string, error) {
+			if key != "API_KEY" {
+				return "", errors.New("not found")
+			}
+			return "sk_test_123", nil
+		},
+		SkipAllowlist: true,
+	}
+}
+
+func testProxy(t *testing.T, engine *proxy.Engine) *Client {
+	t.Helper()
+	srv := httptest.NewServer(proxy.NewServer(0, engine).Handler())
+	t.Cleanup(srv.Close)
+	return New(srv.URL)
+}
+
+func TestTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test_123" {
+			w.WriteHeader(401)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Id", "req_1")
+		w.WriteHeader(201)
+		w.Write([]byte(`{"method":"` + r.Method + `","body":"` + string(body) + `","accept":"` + strings.Join(r.Header.Values("Accept"), ",") + `"}`))
+	}))
+	defer upstream.Close()
+
+	c := testProxy(t, testEngine())
+	httpClient := &http.Client{Transport: c.Transport(Bearer("API_KEY"))}
+
+	req, _ := http.NewRequest("POST", upstream.URL+"/v1/customers", strings.NewReader("email=a@b.c"))
+	req.Header.Add("Accept", "application/json")
+	req.Header.Add("Accept", "text/plain")
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != 201 || resp.Header.Get("X-Request-Id") != "req_1" {
+		t.Errorf("status %d, headers %v", resp.StatusCode, resp.Header)
+	}
+	if want := `{"method":"POST","body":"email=a@b.c","accept":"application/json,text/plain"}`; string(body) != want {
+		t.Errorf("body = %s, want %s", body, want)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3")
+		w.WriteHeader(429)
+	}))
+	defer upstream.Close()
+
+	engine := testEngine()
+	c := testProxy(t, engine)
+	ctx := context.Background()
+
+	_, err := c.Call(ctx, &Request{URL: upstream.URL, Inject: []Injection{Bearer("API_KEY")}})
+	var limited *RateLimitedError
+	if !errors.As(err, &limited) || limited.RetryAfter != 3*time.Second || limited.Response.StatusCode != 429 {
+		t.Errorf("429: err = %v, want a RateLimitedError with RetryAfter 3s", err)
+	}
+
+	_, err = c.Call(ctx, &Request{URL: upstream.URL, Inject: []Injection{Bearer("MISSING_KEY")}})
+	var proxyErr *ProxyError
+	if !errors.As(err, &proxyErr) || proxyErr.StatusCode != 502 || !strings.Contains(proxyErr.Message, "MISSING_KEY") {
+		t.Errorf("missing secret: err = %v, want a ProxyError", err)
+	}
+
+	engine.Policy = &proxy.Policy{Default: "deny"}
+	_, err = c.Call(ctx, &Request{URL: upstream.URL, Inject: []Injection{Bearer("API_KEY")}})
+	var blocked *BlockedError
+	if !errors.As(err, &blocked) || blocked.Reason == "" || blocked.Domain != "127.0.0.1" {
+		t.Errorf("denied call: err = %v, want a BlockedError", err)
+	}
+
+	// The transport surfaces blocks as errors too, but passes 429s through
+	_, err = (&http.Client{Transport: c.Transport(Bearer("API_KEY"))}).Get(upstream.URL)
+	if !errors.As(err, &blocked) {
+		t.Errorf("transport: err = %v, want a BlockedError", err)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok " + r.URL.Query().Get("key")))
+	}))
+	defer upstream.Close()
+
+	dir, err := os.MkdirTemp("", "as") // short path: socket paths are limited to ~100 bytes
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "proxy.sock")
+	go proxy.NewServer(0, testEngine()).StartUnix(path)
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Setenv(ProxyEnv, "unix:"+path)
+	resp, err := FromEnv().Call(context.Background(), &Request{URL: upstream.URL, Inject: []Injection{Query("key", "API_KEY")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Body) != "ok [REDACTED_BY_AGENTSECRETS]" || !resp.Redacted {
+		t.Errorf("response = %q, redacted %v", resp.Body, resp.Redacted)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("ast_from_file\n"), 0600)
+	t.Setenv(ProxyEnv, "127.0.0.1:9000")
+	t.Setenv(AgentIDEnv, "deploy-bot")
+	t.Setenv(proxy.AgentTokenEnv, "")
+	t.Setenv(AgentTokenFileEnv, tokenFile)
+
+	c := FromEnv()
+	if c.BaseURL != "http://127.0.0.1:9000" || c.AgentID != "deploy-bot" || c.AgentToken != "ast_from_file" {
+		t.Errorf("FromEnv() = %+v", c)
+	}
+
+	t.Setenv(proxy.AgentTokenEnv, "ast_from_env")
+	if token, _ := DiscoverToken(); token != "ast_from_env" {
+		t.Errorf("DiscoverToken() = %q, the environment variable comes first", token)
+	}
+}
@@ -0,0 +1,80 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// BlockedError is returned when the proxy refuses a call, e.g. because the
+// domain is not in the workspace allowlist or a policy denies it.
+type BlockedError struct {
+	Reason    string // e.g. "domain_not_in_allowlist", "policy_method_denied"
+	Domain    string
+	Message   string
+	RequestID string // set when an allowlist request was opened for the domain
+}
+
+func (e *BlockedError) Error() string {
+	return fmt.Sprintf("agentsecrets blocked the call to %s (%s): %s", e.Domain, e.Reason, e.Message)
+}
+
+func newBlockedError(reason string, body []byte) *BlockedError {
+	var parsed struct {
+		Domain    string `json:"domain"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id"`
+	}
+	_ = json.Unmarshal(body, &parsed)
+	return &BlockedError{Reason: reason, Domain: parsed.Domain, Message: parsed.Message, RequestID: parsed.RequestID}
+}
+
+// RateLimitedError is returned for an upstream 429. The response is returned
+// alongside it.
+type RateLimitedError struct {
+	RetryAfter time.Duration // from Retry-After; zero if the upstream sent none
+	Response   *Response
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("upstream rate limit: retry after %s", e.RetryAfter)
+	}
+	return "upstream rate limit"
+}
+
+func newRateLimitedError(resp *Response) *RateLimitedError {
+	e := &RateLimitedError{Response: resp}
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			e.RetryAfter = time.Duration(secs) * time.Second
+		} else if at, err := http.ParseTime(v); err == nil {
+			e.RetryAfter = max(time.Until(at), 0)
+		}
+	}
+	return e
+}
+
+// ProxyError is returned when the proxy could not make the call at all: a
+// malformed request, a secret missing from the keychain, or an unreachable
+// upstream.
+type ProxyError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ProxyError) Error() string {
+	return fmt.Sprintf("agentsecrets proxy error (%d): %s", e.StatusCode, e.Message)
+}
+
+func newProxyError(status int, body []byte) *ProxyError {
+	var parsed struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) != nil || parsed.Error == "" {
+		parsed.Error = string(body)
+	}
+	return &ProxyError{StatusCode: status, Message: parsed.Error}
+}
@@ -0,0 +1,40 @@
+package client
+
+import "github.com/The-17/agentsecrets/pkg/proxyapi"
+
+// Injection names a secret and where the proxy puts its value.
+type Injection = proxyapi.Injection
+
+// Capture names a value in the JSON response, e.g. "$.access_token", and the
+// secret key the proxy stores it under. The agent only sees the key name.
+type Capture = proxyapi.Capture
+
+// Bearer sends the secret as Authorization: Bearer <value>.
+func Bearer(secretKey string) Injection {
+	return Injection{Style: "bearer", SecretKey: secretKey}
+}
+
+// Basic sends the secret, in user:password form, as HTTP basic auth.
+func Basic(secretKey string) Injection {
+	return Injection{Style: "basic", SecretKey: secretKey}
+}
+
+// Header sends the secret in the named header.
+func Header(name, secretKey string) Injection {
+	return Injection{Style: "header", Target: name, SecretKey: secretKey}
+}
+
+// Query sends the secret as the named query parameter.
+func Query(param, secretKey string) Injection {
+	return Injection{Style: "query", Target: param, SecretKey: secretKey}
+}
+
+// Body sets the secret at a dotted path in a JSON request body, e.g. "auth.api-key".
+func Body(path, secretKey string) Injection {
+	return Injection{Style: "body", Target: path, SecretKey: secretKey}
+}
+
+// Form sets the secret as a field of a form-encoded request body.
+func Form(field, secretKey string) Injection {
+	return Injection{Style: "form", Target: field, SecretKey: secretKey}
+}
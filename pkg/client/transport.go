@@ -0,0 +1,66 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that sends every request through the
+// proxy with its injections, so SDKs built on net/http (stripe-go, go-github,
+// ...) authenticate without holding the credential:
+//
+//	gh := github.NewClient(&http.Client{Transport: c.Transport(client.Bearer("GITHUB_TOKEN"))})
+//
+// Upstream responses, including error statuses and 429s, are returned as is
+// for the SDK to handle; a call the proxy blocks fails with a *BlockedError.
+type Transport struct {
+	Client *Client
+	Inject []Injection
+}
+
+// Transport returns a RoundTripper that injects inject into every request.
+func (c *Client) Transport(inject ...Injection) *Transport {
+	return &Transport{Client: c, Inject: inject}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.Client.call(req.Context(), &Request{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Header: req.Header,
+		Body:   body,
+		Inject: t.Inject,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	header := resp.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+		StatusCode:    resp.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}, nil
+}
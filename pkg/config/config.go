@@ -187,7 +187,12 @@ func SaveTokens(tokens *TokenConfig) error {
 
 // LoadProjectConfig reads .agentsecrets/project.json from the current directory.
 func LoadProjectConfig() (*ProjectConfig, error) {
-	projectFile := filepath.Join(".", ".agentsecrets", "project.json")
+	return LoadProjectConfigAt(".")
+}
+
+// LoadProjectConfigAt reads .agentsecrets/project.json from the project rooted at dir.
+func LoadProjectConfigAt(dir string) (*ProjectConfig, error) {
+	projectFile := filepath.Join(dir, ".agentsecrets", "project.json")
 	var config ProjectConfig
 	if err := readJSON(projectFile, &config); err != nil {
 		return nil, err
@@ -126,3 +126,79 @@ func TestProjectConfig(t *testing.T) {
 		t.Error("Loaded project config does not match saved")
 	}
 }
+
+func TestEnvProfileApply(t *testing.T) {
+	secrets := map[string]string{
+		"STRIPE_KEY":      "sk_test",
+		"STRIPE_WEBHOOK":  "whsec",
+		"PROD_DB_URL":     "postgres://prod",
+		"OPENAI_API_KEY":  "sk-openai",
+		"STRIPE_LIVE_KEY": "sk_live",
+	}
+
+	p := &EnvProfile{
+		Prefix:  []string{"STRIPE_"},
+		Exclude: []string{"STRIPE_LIVE_KEY"},
+		Map:     map[string]string{"DATABASE_URL": "PROD_DB_URL"},
+	}
+	env, sources, err := p.Apply(secrets)
+	if err != nil {
+		t.Fatalf("Apply() error: %v", err)
+	}
+
+	if len(env) != 3 {
+		t.Errorf("got %d env vars, want 3: %v", len(env), sources)
+	}
+	if env["DATABASE_URL"] != "postgres://prod" || sources["DATABASE_URL"] != "PROD_DB_URL" {
+		t.Error("DATABASE_URL was not mapped from PROD_DB_URL")
+	}
+	if _, ok := env["PROD_DB_URL"]; ok {
+		t.Error("mapped source key should not be injected under its own name")
+	}
+	if _, ok := env["STRIPE_LIVE_KEY"]; ok {
+		t.Error("excluded key was injected")
+	}
+	if _, ok := env["OPENAI_API_KEY"]; ok {
+		t.Error("unselected key was injected")
+	}
+
+	all, _, err := (&EnvProfile{}).Apply(secrets)
+	if err != nil || len(all) != len(secrets) {
+		t.Errorf("empty profile should select every secret, got %d (err %v)", len(all), err)
+	}
+
+	if _, _, err := (&EnvProfile{Only: []string{"MISSING"}}).Apply(secrets); err == nil {
+		t.Error("expected error for unknown key in Only")
+	}
+}
+
+func TestLoadEnvProfiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+	os.Chdir(tmpDir)
+
+	cfg, err := LoadEnvProfiles()
+	if err != nil || len(cfg.Profiles) != 0 {
+		t.Fatalf("missing env.json should load empty, got %+v (err %v)", cfg, err)
+	}
+
+	os.MkdirAll(filepath.Join(tmpDir, ".agentsecrets"), 0755)
+	data := `{"default": "test", "profiles": {"test": {"only": ["TEST_DB_URL"], "map": {"DATABASE_URL": "TEST_DB_URL"}}}}`
+	os.WriteFile(filepath.Join(tmpDir, ".agentsecrets", "env.json"), []byte(data), 0644)
+
+	cfg, err = LoadEnvProfiles()
+	if err != nil {
+		t.Fatalf("LoadEnvProfiles() error: %v", err)
+	}
+	p, err := cfg.Profile(cfg.Default)
+	if err != nil {
+		t.Fatalf("Profile(%q) error: %v", cfg.Default, err)
+	}
+	if p.Map["DATABASE_URL"] != "TEST_DB_URL" {
+		t.Errorf("profile map = %v", p.Map)
+	}
+	if _, err := cfg.Profile("prod"); err == nil {
+		t.Error("expected error for undefined profile")
+	}
+}
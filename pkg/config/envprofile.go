@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// EnvProfile selects and renames the secrets `agentsecrets env` injects.
+//
+// With no Only, Prefix or Map set every secret is selected. Otherwise only the
+// secrets named by Only, matching a Prefix, or used as the source of a Map entry
+// are injected. Exclude is applied last and always wins.
+type EnvProfile struct {
+	Only    []string          `json:"only,omitempty"`    // exact secret key names
+	Prefix  []string          `json:"prefix,omitempty"`  // e.g. "STRIPE_"
+	Exclude []string          `json:"exclude,omitempty"` // secret key names never injected
+	Map     map[string]string `json:"map,omitempty"`     // ENV_NAME → SECRET_KEY
+}
+
+// EnvProfilesConfig represents ./.agentsecrets/env.json
+type EnvProfilesConfig struct {
+	Default  string                 `json:"default,omitempty"` // profile used when no selection flags are given
+	Profiles map[string]*EnvProfile `json:"profiles,omitempty"`
+}
+
+// LoadEnvProfiles reads .agentsecrets/env.json from the current directory.
+// A missing file yields an empty config.
+func LoadEnvProfiles() (*EnvProfilesConfig, error) {
+	path := filepath.Join(".", ".agentsecrets", "env.json")
+	var cfg EnvProfilesConfig
+	if err := readJSON(path, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Profile returns the named profile, or an error if it is not defined.
+func (c *EnvProfilesConfig) Profile(name string) (*EnvProfile, error) {
+	p, ok := c.Profiles[name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("env profile %q not found in .agentsecrets/env.json", name)
+	}
+	return p, nil
+}
+
+// IsEmpty reports whether the profile selects everything unchanged.
+func (p *EnvProfile) IsEmpty() bool {
+	return len(p.Only) == 0 && len(p.Prefix) == 0 && len(p.Exclude) == 0 && len(p.Map) == 0
+}
+
+// Merge adds the selections of other to p.
+func (p *EnvProfile) Merge(other *EnvProfile) {
+	if other == nil {
+		return
+	}
+	p.Only = append(p.Only, other.Only...)
+	p.Prefix = append(p.Prefix, other.Prefix...)
+	p.Exclude = append(p.Exclude, other.Exclude...)
+	for envName, key := range other.Map {
+		if p.Map == nil {
+			p.Map = make(map[string]string)
+		}
+		p.Map[envName] = key
+	}
+}
+
+// Apply selects secrets (key name → value) according to the profile.
+// It returns the environment to inject (ENV_NAME → value) and, for each
+// ENV_NAME, the secret key it came from. Keys named by Only or Map that do
+// not exist are an error, so typos don't silently inject nothing.
+func (p *EnvProfile) Apply(secrets map[string]string) (map[string]string, map[string]string, error) {
+	excluded := make(map[string]bool, len(p.Exclude))
+	for _, k := range p.Exclude {
+		excluded[k] = true
+	}
+
+	env := make(map[string]string)
+	sources := make(map[string]string)
+	add := func(envName, key string) {
+		if excluded[key] {
+			return
+		}
+		env[envName] = secrets[key]
+		sources[envName] = key
+	}
+
+	selectAll := len(p.Only) == 0 && len(p.Prefix) == 0 && len(p.Map) == 0
+
+	for _, key := range p.Only {
+		if _, ok := secrets[key]; !ok {
+			return nil, nil, fmt.Errorf("secret %s not found in project", key)
+		}
+		add(key, key)
+	}
+
+	for key := range secrets {
+		if selectAll {
+			add(key, key)
+			continue
+		}
+		for _, prefix := range p.Prefix {
+			if strings.HasPrefix(key, prefix) {
+				add(key, key)
+				break
+			}
+		}
+	}
+
+	envNames := make([]string, 0, len(p.Map))
+	for envName := range p.Map {
+		envNames = append(envNames, envName)
+	}
+	sort.Strings(envNames)
+	for _, envName := range envNames {
+		key := p.Map[envName]
+		if _, ok := secrets[key]; !ok {
+			return nil, nil, fmt.Errorf("secret %s (mapped to %s) not found in project", key, envName)
+		}
+		add(envName, key)
+	}
+
+	return env, sources, nil
+}
@@ -14,6 +14,7 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
@@ -248,6 +249,33 @@ func DecryptFromUser(privateKey, publicKey, encrypted []byte) ([]byte, error) {
 	return decrypted, nil
 }
 
+// NewApprovalKey derives a signing key for approval decisions from the
+// user's password under a fresh salt. Only the public key and the salt are
+// stored, so signing an approval always takes the password.
+// Returns (public key, hex salt).
+func NewApprovalKey(password string) (ed25519.PublicKey, string, error) {
+	salt, err := randomBytes(SaltSize)
+	if err != nil {
+		return nil, "", err
+	}
+	saltHex := hex.EncodeToString(salt)
+	key, err := ApprovalKey(password, saltHex)
+	if err != nil {
+		return nil, "", err
+	}
+	return key.Public().(ed25519.PublicKey), saltHex, nil
+}
+
+// ApprovalKey re-derives the approval signing key from the password and the
+// salt NewApprovalKey returned.
+func ApprovalKey(password, saltHex string) (ed25519.PrivateKey, error) {
+	seed, err := DeriveKeyFromPassword(password, saltHex)
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
 // --- Internal Helpers ---
 
 func randomBytes(size int) ([]byte, error) {
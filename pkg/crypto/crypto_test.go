@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"bytes"
+	"crypto/ed25519"
 	"encoding/hex"
 	"testing"
 )
@@ -144,6 +145,26 @@ func TestSetupUser(t *testing.T) {
 	}
 }
 
+func TestApprovalKey(t *testing.T) {
+	pub, salt, err := NewApprovalKey("correct horse")
+	if err != nil {
+		t.Fatalf("NewApprovalKey failed: %v", err)
+	}
+
+	key, err := ApprovalKey("correct horse", salt)
+	if err != nil {
+		t.Fatalf("ApprovalKey failed: %v", err)
+	}
+	if !bytes.Equal(key.Public().(ed25519.PublicKey), pub) {
+		t.Error("Re-derived approval key does not match the stored public key")
+	}
+
+	wrong, _ := ApprovalKey("wrong password", salt)
+	if ed25519.Verify(pub, []byte("approve"), ed25519.Sign(wrong, []byte("approve"))) {
+		t.Error("A key derived from another password verified")
+	}
+}
+
 func TestEncryptForUserRoundtrip(t *testing.T) {
 	// Generate a recipient
 	priv, pub, err := GenerateKeypair()
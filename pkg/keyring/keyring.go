@@ -11,7 +11,9 @@ package keyring
 
 import (
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -211,7 +213,62 @@ func SetSecret(projectID, key, value string) error {
 			return fmt.Errorf("set secret %s: %w", name, err)
 		}
 	}
-	return addKeyToIndex(projectID, key)
+	if err := addKeyToIndex(projectID, key); err != nil {
+		return err
+	}
+	// A value set by hand is no longer one a capture may replace
+	return setCaptured(projectID, key, false)
+}
+
+// SetCapturedSecret stores a value a proxy capture lifted from an API
+// response, and marks the key so later captures may replace it.
+func SetCapturedSecret(projectID, key, value string) error {
+	if err := SetSecret(projectID, key, value); err != nil {
+		return err
+	}
+	return setCaptured(projectID, key, true)
+}
+
+// HasSecret reports whether the project's key index lists key.
+func HasSecret(projectID, key string) bool {
+	for _, k := range getProjectKeys(projectID) {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// IsCapturedSecret reports whether a secret was last stored by a capture.
+func IsCapturedSecret(projectID, key string) bool {
+	for _, k := range getKeyList(capturedKeysName(projectID)) {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func capturedKeysName(projectID string) string {
+	return fmt.Sprintf("CapturedKeys_%s", projectID)
+}
+
+func setCaptured(projectID, key string, captured bool) error {
+	name := capturedKeysName(projectID)
+	keys := getKeyList(name)
+	var kept []string
+	for _, k := range keys {
+		if k != key {
+			kept = append(kept, k)
+		}
+	}
+	if captured {
+		kept = append(kept, key)
+	}
+	if len(kept) == len(keys) && !captured {
+		return nil // nothing to clear
+	}
+	return saveKeyList(name, kept)
 }
 
 // GetSecret retrieves a secret from the keyring.
@@ -242,9 +299,93 @@ func DeleteSecret(projectID, key string) error {
 	} else {
 		_ = gokeyring.Delete(serviceName, name)
 	}
+	_ = SetSecretClass(projectID, key, "")
+	_ = setCaptured(projectID, key, false)
 	return removeKeyFromIndex(projectID, key)
 }
 
+func secretClassesName(projectID string) string {
+	return fmt.Sprintf("SecretClasses_%s", projectID)
+}
+
+// GetSecretClasses returns the sensitivity class of each classified secret in a
+// project (key name → class). Secrets without a class are not listed. A store
+// that exists but can't be read or parsed is an error.
+func GetSecretClasses(projectID string) (map[string]string, error) {
+	name := secretClassesName(projectID)
+	var val string
+
+	if useFileBackend {
+		entries, err := loadKeyringFile()
+		if err != nil {
+			return nil, fmt.Errorf("read secret classes: %w", err)
+		}
+		if entry, ok := entries[name]; ok && entry.Private != "" {
+			v, err := base64.StdEncoding.DecodeString(entry.Private)
+			if err != nil {
+				return nil, fmt.Errorf("read secret classes: %w", err)
+			}
+			val = string(v)
+		}
+	} else {
+		v, err := gokeyring.Get(serviceName, name)
+		if err != nil && !errors.Is(err, gokeyring.ErrNotFound) {
+			return nil, fmt.Errorf("read secret classes: %w", err)
+		}
+		val = v
+	}
+
+	classes := make(map[string]string)
+	if val == "" {
+		return classes, nil
+	}
+	if err := json.Unmarshal([]byte(val), &classes); err != nil {
+		return nil, fmt.Errorf("parse secret classes: %w", err)
+	}
+	return classes, nil
+}
+
+// GetSecretClass returns the sensitivity class of a secret, or "" if it has none.
+func GetSecretClass(projectID, key string) (string, error) {
+	classes, err := GetSecretClasses(projectID)
+	if err != nil {
+		return "", err
+	}
+	return classes[key], nil
+}
+
+// SetSecretClass records the sensitivity class of a secret. An empty class
+// clears it. A store that can't be read is left alone rather than replaced,
+// which would drop every other secret's class.
+func SetSecretClass(projectID, key, class string) error {
+	classes, err := GetSecretClasses(projectID)
+	if err != nil {
+		return err
+	}
+	if classes[key] == class {
+		return nil
+	}
+	if class == "" {
+		delete(classes, key)
+	} else {
+		classes[key] = class
+	}
+
+	valBytes, err := json.Marshal(classes)
+	if err != nil {
+		return fmt.Errorf("serialize secret classes: %w", err)
+	}
+	name := secretClassesName(projectID)
+	if useFileBackend {
+		encoded := base64.StdEncoding.EncodeToString(valBytes)
+		return fileSet(name, encoded, "")
+	}
+	if err := gokeyring.Set(serviceName, name, string(valBytes)); err != nil {
+		return fmt.Errorf("set secret classes %s: %w", name, err)
+	}
+	return nil
+}
+
 // --- Key Index Management ---
 // We maintain a comma-separated list of keys per project so we can iterate them 
 // since go-keyring lacks a list/iterate feature.
@@ -304,13 +445,102 @@ func GetWorkspaceAllowlist(workspaceID string) ([]string, error) {
 	return domains, nil
 }
 
+const approvalKeyName = "agentsecrets:approval-key"
+
+type approvalKey struct {
+	PublicKey string `json:"public_key"` // hex
+	Salt      string `json:"salt"`       // hex
+}
+
+// SetApprovalKey stores the public key approval decisions are verified with,
+// and the salt its private key is derived with. It lives in the keychain
+// rather than the config file, which an agent running as the user could
+// rewrite to approve its own requests.
+func SetApprovalKey(publicKey []byte, saltHex string) error {
+	valBytes, err := json.Marshal(approvalKey{PublicKey: hex.EncodeToString(publicKey), Salt: saltHex})
+	if err != nil {
+		return fmt.Errorf("serialize approval key: %w", err)
+	}
+	if useFileBackend {
+		return fileSet(approvalKeyName, base64.StdEncoding.EncodeToString(valBytes), "")
+	}
+	if err := gokeyring.Set(serviceName, approvalKeyName, string(valBytes)); err != nil {
+		return fmt.Errorf("set approval key: %w", err)
+	}
+	return nil
+}
+
+// GetApprovalKey retrieves what SetApprovalKey stored.
+func GetApprovalKey() (publicKey []byte, saltHex string, err error) {
+	var val string
+	if useFileBackend {
+		v, err := fileGetKey(approvalKeyName, "private")
+		if err != nil {
+			return nil, "", fmt.Errorf("get approval key: %w", err)
+		}
+		val = string(v)
+	} else {
+		if val, err = gokeyring.Get(serviceName, approvalKeyName); err != nil {
+			return nil, "", fmt.Errorf("get approval key: %w", err)
+		}
+	}
+
+	var key approvalKey
+	if err := json.Unmarshal([]byte(val), &key); err != nil {
+		return nil, "", fmt.Errorf("parse approval key: %w", err)
+	}
+	if publicKey, err = hex.DecodeString(key.PublicKey); err != nil {
+		return nil, "", fmt.Errorf("parse approval key: %w", err)
+	}
+	return publicKey, key.Salt, nil
+}
+
+func responseCacheKeyName(projectID string) string {
+	return fmt.Sprintf("agentsecrets:response-cache:%s", projectID)
+}
+
+// SetResponseCacheKey stores the key the proxy encrypts a project's cached
+// responses with on disk.
+func SetResponseCacheKey(projectID string, key []byte) error {
+	name := responseCacheKeyName(projectID)
+	encoded := base64.StdEncoding.EncodeToString(key)
+	if useFileBackend {
+		return fileSet(name, encoded, "")
+	}
+	if err := gokeyring.Set(serviceName, name, encoded); err != nil {
+		return fmt.Errorf("set response cache key %s: %w", name, err)
+	}
+	return nil
+}
+
+// GetResponseCacheKey retrieves the key set by SetResponseCacheKey.
+func GetResponseCacheKey(projectID string) ([]byte, error) {
+	name := responseCacheKeyName(projectID)
+	if useFileBackend {
+		key, err := fileGetKey(name, "private")
+		if err != nil {
+			return nil, fmt.Errorf("get response cache key: %w", err)
+		}
+		return key, nil
+	}
+	encoded, err := gokeyring.Get(serviceName, name)
+	if err != nil {
+		return nil, fmt.Errorf("get response cache key: %w", err)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
 func projectIndexName(projectID string) string {
 	return fmt.Sprintf("ProjectKeys_%s", projectID)
 }
 
 func getProjectKeys(projectID string) []string {
+	return getKeyList(projectIndexName(projectID))
+}
+
+// getKeyList reads a comma-separated list of key names stored under name.
+func getKeyList(name string) []string {
 	var val string
-	name := projectIndexName(projectID)
 
 	if useFileBackend {
 		if v, err := fileGetKey(name, "private"); err == nil {
@@ -329,7 +559,10 @@ func getProjectKeys(projectID string) []string {
 }
 
 func saveProjectKeys(projectID string, keys []string) error {
-	name := projectIndexName(projectID)
+	return saveKeyList(projectIndexName(projectID), keys)
+}
+
+func saveKeyList(name string, keys []string) error {
 	val := strings.Join(keys, ",")
 
 	if useFileBackend {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/The-17/agentsecrets/pkg/api"
@@ -14,7 +15,8 @@ import (
 	"github.com/mark3labs/mcp-go/server"
 )
 
-// NewServer creates an MCP server with api_call and list_secrets tools.
+// NewServer creates an MCP server with api_call, api_batch, list_secrets and
+// request_domain_access tools.
 func NewServer() *server.MCPServer {
 	s := server.NewMCPServer(
 		"AgentSecrets",
@@ -23,7 +25,9 @@ func NewServer() *server.MCPServer {
 	)
 
 	s.AddTool(apiCallTool(), handleAPICall)
+	s.AddTool(apiBatchTool(), handleAPIBatch)
 	s.AddTool(listSecretsTool(), handleListSecrets)
+	s.AddTool(requestDomainAccessTool(), handleRequestDomainAccess)
 
 	return s
 }
@@ -41,11 +45,12 @@ func apiCallTool() mcp.Tool {
 		mcp.WithDescription(
 			"Make an authenticated API call. Credentials are injected from the OS keychain — "+
 				"you will NEVER see the actual secret values. "+
-				"Use list_secrets first to discover available key names.",
+				"Use list_secrets first to discover available key names. "+
+				"Some calls are held until a human approves them; the call then waits "+
+				"(reporting progress) and returns 403 approval_denied if it is rejected.",
 		),
 		mcp.WithString("url",
-			mcp.Required(),
-			mcp.Description("Target API URL (e.g. https://api.stripe.com/v1/charges)"),
+			mcp.Description("Target API URL (e.g. https://api.stripe.com/v1/charges). Required unless cursor is given"),
 		),
 		mcp.WithString("method",
 			mcp.Description("HTTP method: GET, POST, PUT, PATCH, DELETE. Default: GET"),
@@ -57,13 +62,62 @@ func apiCallTool() mcp.Tool {
 			mcp.Description("Extra request headers as key-value pairs"),
 		),
 		mcp.WithObject("injections",
-			mcp.Required(),
 			mcp.Description(
-				"Map of injection_spec to secret_key_name. "+
+				"Map of injection_spec to secret_key_name. Required unless cursor is given. "+
 					"Specs: \"bearer\", \"basic\", \"header:X-Name\", \"query:param\", \"body:json.path\", \"form:field\". "+
 					"Example: {\"bearer\": \"STRIPE_KEY\"} or {\"header:X-API-Key\": \"API_KEY\"}",
 			),
 		),
+		mcp.WithObject("capture",
+			mcp.Description(
+				"Map of JSON path in the response to the secret key name to store it under. "+
+					"The value is saved to the keychain and replaced in the response with a placeholder, "+
+					"so session tokens from login endpoints never reach you. "+
+					"A key that already holds a secret not stored by a capture cannot be captured into. "+
+					"Example: {\"$.access_token\": \"VENDOR_SESSION\"}",
+			),
+		),
+		mcp.WithBoolean("dry_run",
+			mcp.Description(
+				"Check the allowlist and policy and build the request, but don't send it. "+
+					"Returns each check's outcome and the request with secrets shown by name — "+
+					"use it to debug injections or find out why a call is blocked.",
+			),
+		),
+		mcp.WithString("select",
+			mcp.Description(
+				"Return only part of a JSON response. A JSON path where [*] maps over an array "+
+					"and a trailing {a,b.c} keeps only those fields. "+
+					"Example: \"$.data[*].{id,email}\" or \"$.items[0].name\"",
+			),
+		),
+		mcp.WithNumber("max_bytes",
+			mcp.Description("Cut the response body to this many bytes; a cursor for the rest is returned. Default: the whole body"),
+			mcp.Min(1),
+		),
+		mcp.WithString("cursor",
+			mcp.Description("Cursor from a truncated response: returns its next slice without calling the API again. Only max_bytes applies alongside it"),
+		),
+	)
+}
+
+func apiBatchTool() mcp.Tool {
+	return mcp.NewTool("api_batch",
+		mcp.WithDescription(
+			"Make many authenticated API calls at once, e.g. fetch 30 issues or check 10 customers. "+
+				"Each call takes the same parameters as api_call and is checked, injected and audited the same way. "+
+				"Calls run concurrently; results come back in the order given, each with its own status or error.",
+		),
+		mcp.WithArray("calls",
+			mcp.Required(),
+			mcp.Description(fmt.Sprintf("Up to %d objects with api_call's parameters: url, method, body, headers, injections, capture, dry_run, select, max_bytes, cursor", proxy.MaxBatchCalls)),
+			mcp.Items(map[string]any{"type": "object"}),
+		),
+		mcp.WithNumber("concurrency",
+			mcp.Description(fmt.Sprintf("How many calls run at once. Default: %d, at most %d", proxy.DefaultBatchConcurrency, proxy.MaxBatchConcurrency)),
+			mcp.Min(1),
+			mcp.Max(proxy.MaxBatchConcurrency),
+		),
 	)
 }
 
@@ -77,15 +131,129 @@ func listSecretsTool() mcp.Tool {
 	)
 }
 
+func requestDomainAccessTool() mcp.Tool {
+	return mcp.NewTool("request_domain_access",
+		mcp.WithDescription(
+			"Ask a workspace admin to add a domain to the allowlist. "+
+				"When api_call is blocked with domain_not_in_allowlist, the response carries a request_id; "+
+				"pass it here with a short justification. The admin approves or rejects it with "+
+				"'agentsecrets workspace allowlist approve <id>'. Retry the api_call once it is approved.",
+		),
+		mcp.WithString("request_id",
+			mcp.Required(),
+			mcp.Description("The request_id from the blocked api_call response"),
+		),
+		mcp.WithString("justification",
+			mcp.Required(),
+			mcp.Description("Why you need this domain, shown to the admin (e.g. \"fetch the customer's invoices from the billing API\")"),
+		),
+	)
+}
+
 // --- Handlers ---
 
 func handleAPICall(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	call, err := parseCallArgs(req.GetArguments())
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	call.Progress = approvalProgress(ctx, req)
+
+	engine, err := projectEngine()
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	// Execute
+	result, err := engine.Execute(call)
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("API call failed: %v", err)), nil
+	}
+	return mcp.NewToolResultText(formatResult(result)), nil
+}
+
+func handleAPIBatch(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
 	args := req.GetArguments()
 
+	rawCalls, ok := args["calls"].([]interface{})
+	if !ok || len(rawCalls) == 0 {
+		return mcp.NewToolResultError("missing required parameter: calls — provide a list of api_call argument objects"), nil
+	}
+	if len(rawCalls) > proxy.MaxBatchCalls {
+		return mcp.NewToolResultError(fmt.Sprintf("too many calls: %d (at most %d per batch)", len(rawCalls), proxy.MaxBatchCalls)), nil
+	}
+	concurrency, _ := args["concurrency"].(float64)
+
+	// Calls with invalid arguments are reported in place; the rest still run
+	parseErrs := make([]error, len(rawCalls))
+	var calls []proxy.CallRequest
+	var slots []int
+	for i, raw := range rawCalls {
+		callArgs, ok := raw.(map[string]interface{})
+		if !ok {
+			parseErrs[i] = fmt.Errorf("each call must be an object with api_call's parameters")
+			continue
+		}
+		call, err := parseCallArgs(callArgs)
+		if err != nil {
+			parseErrs[i] = err
+			continue
+		}
+		calls = append(calls, call)
+		slots = append(slots, i)
+	}
+
+	engine, err := projectEngine()
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+	results := make([]proxy.BatchResult, len(rawCalls))
+	for j, res := range engine.ExecuteBatch(calls, int(concurrency)) {
+		results[slots[j]] = res
+	}
+
+	var sb strings.Builder
+	for i, res := range results {
+		if i > 0 {
+			sb.WriteString("\n\n")
+		}
+		fmt.Fprintf(&sb, "--- call %d ---\n", i+1)
+		switch {
+		case parseErrs[i] != nil:
+			fmt.Fprintf(&sb, "Error: %v", parseErrs[i])
+		case res.Err != nil:
+			fmt.Fprintf(&sb, "Error: API call failed: %v", res.Err)
+		default:
+			sb.WriteString(formatResult(res.Result))
+		}
+	}
+	return mcp.NewToolResultText(sb.String()), nil
+}
+
+// parseCallArgs turns api_call's arguments into a CallRequest.
+func parseCallArgs(args map[string]interface{}) (proxy.CallRequest, error) {
+	// Optional: shaping
+	var maxBytes int
+	if n, ok := args["max_bytes"].(float64); ok {
+		if n < 1 {
+			return proxy.CallRequest{}, fmt.Errorf("max_bytes must be at least 1")
+		}
+		maxBytes = int(n)
+	}
+	if cursor, _ := args["cursor"].(string); cursor != "" {
+		return proxy.CallRequest{
+			Cursor:     cursor,
+			MaxBytes:   maxBytes,
+			AgentID:    "mcp",
+			AgentToken: os.Getenv(proxy.AgentTokenEnv),
+		}, nil
+	}
+	sel, _ := args["select"].(string)
+
 	// Required: url
 	url, ok := args["url"].(string)
 	if !ok || url == "" {
-		return mcp.NewToolResultError("missing required parameter: url"), nil
+		return proxy.CallRequest{}, fmt.Errorf("missing required parameter: url")
 	}
 
 	// Optional: method (default GET)
@@ -101,11 +269,11 @@ func handleAPICall(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolR
 	}
 
 	// Optional: headers
-	headers := make(map[string]string)
+	headers := make(map[string][]string)
 	if hdrs, ok := args["headers"].(map[string]interface{}); ok {
 		for k, v := range hdrs {
 			if s, ok := v.(string); ok {
-				headers[k] = s
+				headers[k] = []string{s}
 			}
 		}
 	}
@@ -113,42 +281,67 @@ func handleAPICall(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolR
 	// Required: injections
 	rawInjections, ok := args["injections"].(map[string]interface{})
 	if !ok || len(rawInjections) == 0 {
-		return mcp.NewToolResultError("missing required parameter: injections — provide at least one injection like {\"bearer\": \"SECRET_KEY\"}"), nil
+		return proxy.CallRequest{}, fmt.Errorf("missing required parameter: injections — provide at least one injection like {\"bearer\": \"SECRET_KEY\"}")
 	}
 
 	injections, err := parseInjections(rawInjections)
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("invalid injections: %v", err)), nil
+		return proxy.CallRequest{}, fmt.Errorf("invalid injections: %v", err)
 	}
 
-	// Load project config for project ID
-	project, err := config.LoadProjectConfig()
-	if err != nil || project.ProjectID == "" {
-		return mcp.NewToolResultError("no project configured — run 'agentsecrets init' first"), nil
-	}
-
-	// Create engine
-	engine, err := proxy.NewEngine(project.ProjectID)
-	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("failed to initialize proxy engine: %v", err)), nil
+	// Optional: capture
+	var captures []proxy.Capture
+	if rawCaptures, ok := args["capture"].(map[string]interface{}); ok {
+		captures, err = parseCaptures(rawCaptures)
+		if err != nil {
+			return proxy.CallRequest{}, fmt.Errorf("invalid capture: %v", err)
+		}
 	}
 
-	// Execute
-	result, err := engine.Execute(proxy.CallRequest{
+	return proxy.CallRequest{
 		TargetURL:  url,
 		Method:     method,
 		Headers:    headers,
 		Body:       body,
 		Injections: injections,
+		Captures:   captures,
 		AgentID:    "mcp",
-	})
+		AgentToken: os.Getenv(proxy.AgentTokenEnv),
+		DryRun:     args["dry_run"] == true,
+		Select:     sel,
+		MaxBytes:   maxBytes,
+	}, nil
+}
+
+// projectEngine creates a proxy engine for the current project.
+func projectEngine() (*proxy.Engine, error) {
+	project, err := config.LoadProjectConfig()
+	if err != nil || project.ProjectID == "" {
+		return nil, fmt.Errorf("no project configured — run 'agentsecrets init' first")
+	}
+
+	engine, err := proxy.NewEngine(project.ProjectID)
 	if err != nil {
-		return mcp.NewToolResultError(fmt.Sprintf("API call failed: %v", err)), nil
+		return nil, fmt.Errorf("failed to initialize proxy engine: %v", err)
 	}
+	return engine, nil
+}
 
-	// Format response
+// formatResult renders a call result for the agent.
+func formatResult(result *proxy.CallResult) string {
+	if result.Explain != nil {
+		return result.Explain.String()
+	}
 	response := fmt.Sprintf("HTTP %d\n\n%s", result.StatusCode, string(result.Body))
-	return mcp.NewToolResultText(response), nil
+	if result.Shaping != nil {
+		if notice := result.Shaping.Notice(); notice != "" {
+			response += "\n\n" + notice
+		}
+	}
+	if len(result.Captured) > 0 {
+		response += fmt.Sprintf("\n\nCaptured into keychain: %s", strings.Join(result.Captured, ", "))
+	}
+	return response
 }
 
 func handleListSecrets(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
@@ -179,6 +372,52 @@ func handleListSecrets(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallT
 	return mcp.NewToolResultText(sb.String()), nil
 }
 
+func handleRequestDomainAccess(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+	args := req.GetArguments()
+
+	id, _ := args["request_id"].(string)
+	if id == "" {
+		return mcp.NewToolResultError("missing required parameter: request_id — use the request_id from the blocked api_call response"), nil
+	}
+	justification, _ := args["justification"].(string)
+	if strings.TrimSpace(justification) == "" {
+		return mcp.NewToolResultError("missing required parameter: justification"), nil
+	}
+
+	store, err := proxy.NewDomainRequestStore("")
+	if err != nil {
+		return mcp.NewToolResultError(fmt.Sprintf("failed to open domain requests: %v", err)), nil
+	}
+	r, err := store.Justify(id, justification)
+	if err != nil {
+		return mcp.NewToolResultError(err.Error()), nil
+	}
+
+	return mcp.NewToolResultText(fmt.Sprintf(
+		"Access to %s requested (request %s). A workspace admin must approve it with:\n\n  agentsecrets workspace allowlist approve %s\n\nRetry the api_call once it is approved.",
+		r.Domain, r.ID, r.ID,
+	)), nil
+}
+
+// approvalProgress relays approval-wait messages to the client as progress
+// notifications, if the client asked for progress on this call.
+func approvalProgress(ctx context.Context, req mcp.CallToolRequest) func(string) {
+	srv := server.ServerFromContext(ctx)
+	if srv == nil || req.Params.Meta == nil || req.Params.Meta.ProgressToken == nil {
+		return nil
+	}
+	token := req.Params.Meta.ProgressToken
+	step := 0.0
+	return func(message string) {
+		step++
+		_ = srv.SendNotificationToClient(ctx, "notifications/progress", map[string]any{
+			"progressToken": token,
+			"progress":      step,
+			"message":       message,
+		})
+	}
+}
+
 // parseInjections converts the agent's map format into proxy.Injection structs.
 //
 // Supported formats:
@@ -224,6 +463,26 @@ func parseInjections(raw map[string]interface{}) ([]proxy.Injection, error) {
 	return injections, nil
 }
 
+// parseCaptures converts the agent's capture map into proxy.Capture structs.
+//
+//	"$.access_token": "VENDOR_SESSION" → {Path: "$.access_token", SecretKey: "VENDOR_SESSION"}
+func parseCaptures(raw map[string]interface{}) ([]proxy.Capture, error) {
+	var captures []proxy.Capture
+
+	for path, val := range raw {
+		secretKey, ok := val.(string)
+		if !ok || secretKey == "" {
+			return nil, fmt.Errorf("capture value for %q must be a secret key name", path)
+		}
+		if path == "" {
+			return nil, fmt.Errorf("capture path for %s must not be empty", secretKey)
+		}
+		captures = append(captures, proxy.Capture{Path: path, SecretKey: secretKey})
+	}
+
+	return captures, nil
+}
+
 // ParseInjectionsJSON parses a JSON string of injections into proxy.Injection structs.
 // Exported for testing.
 func ParseInjectionsJSON(jsonStr string) ([]proxy.Injection, error) {
@@ -129,3 +129,45 @@ func TestNewServerCreation(t *testing.T) {
 		t.Fatal("NewServer() returned nil")
 	}
 }
+
+func TestParseCaptures(t *testing.T) {
+	got, err := parseCaptures(map[string]interface{}{"$.access_token": "VENDOR_SESSION"})
+	if err != nil {
+		t.Fatalf("parseCaptures() error = %v", err)
+	}
+	if len(got) != 1 || got[0].Path != "$.access_token" || got[0].SecretKey != "VENDOR_SESSION" {
+		t.Errorf("parseCaptures() = %+v", got)
+	}
+
+	if _, err := parseCaptures(map[string]interface{}{"$.token": 42}); err == nil {
+		t.Error("expected error for non-string capture value")
+	}
+}
+
+func TestParseCallArgs(t *testing.T) {
+	got, err := parseCallArgs(map[string]interface{}{
+		"url":        "https://api.github.com/repos/o/r/issues/1",
+		"headers":    map[string]interface{}{"Accept": "application/json"},
+		"injections": map[string]interface{}{"bearer": "GITHUB_TOKEN"},
+	})
+	if err != nil {
+		t.Fatalf("parseCallArgs() error = %v", err)
+	}
+	if got.Method != "GET" || got.AgentID != "mcp" || got.Headers["Accept"][0] != "application/json" || len(got.Injections) != 1 {
+		t.Errorf("parseCallArgs() = %+v", got)
+	}
+
+	if got.MaxBytes != 0 {
+		t.Errorf("MaxBytes = %d, want no cut unless max_bytes is given", got.MaxBytes)
+	}
+
+	if _, err := parseCallArgs(map[string]interface{}{"url": "https://api.github.com"}); err == nil {
+		t.Error("expected an error without injections")
+	}
+
+	// A cursor needs neither url nor injections
+	got, err = parseCallArgs(map[string]interface{}{"cursor": "ab12:100", "max_bytes": float64(500)})
+	if err != nil || got.Cursor != "ab12:100" || got.MaxBytes != 500 || got.TargetURL != "" || got.AgentID != "mcp" {
+		t.Errorf("parseCallArgs(cursor) = %+v, %v", got, err)
+	}
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/The-17/agentsecrets/pkg/proxyapi"
+)
+
+// OpenAPISpec describes the proxy's JSON API. It is served at /v1/openapi.json.
+//
+//go:embed openapi.json
+var OpenAPISpec []byte
+
+// The JSON API's wire types live in pkg/proxyapi, so that SDKs can use them
+// without importing the proxy.
+type (
+	APICallRequest   = proxyapi.CallRequest
+	APIInjection     = proxyapi.Injection
+	APICapture       = proxyapi.Capture
+	APICallResponse  = proxyapi.CallResponse
+	APIBatchRequest  = proxyapi.BatchRequest
+	APIBatchResponse = proxyapi.BatchResponse
+	APIBatchResult   = proxyapi.BatchResult
+	Explanation      = proxyapi.Explanation
+	ExplainStep      = proxyapi.ExplainStep
+	OutboundPreview  = proxyapi.OutboundPreview
+	Shaping          = proxyapi.Shaping
+)
+
+// callRequest validates r and converts it for the engine.
+func callRequest(r *APICallRequest) (CallRequest, error) {
+	if r.MaxBytes < 0 {
+		return CallRequest{}, fmt.Errorf("max_bytes must not be negative")
+	}
+	if r.Cursor != "" {
+		if r.URL != "" || len(r.Injections) > 0 {
+			return CallRequest{}, fmt.Errorf("a cursor continues an earlier call; leave out url and injections")
+		}
+		return CallRequest{Cursor: r.Cursor, MaxBytes: r.MaxBytes, AgentID: r.AgentID, AgentToken: r.AgentToken}, nil
+	}
+	if r.URL == "" {
+		return CallRequest{}, fmt.Errorf("url is required")
+	}
+	if len(r.Injections) == 0 {
+		return CallRequest{}, fmt.Errorf("at least one injection is required")
+	}
+	if r.Body != "" && r.BodyBase64 != "" {
+		return CallRequest{}, fmt.Errorf("set body or body_base64, not both")
+	}
+
+	req := CallRequest{
+		TargetURL:  r.URL,
+		Method:     strings.ToUpper(r.Method),
+		Headers:    r.Headers,
+		Body:       []byte(r.Body),
+		AgentID:    r.AgentID,
+		AgentToken: r.AgentToken,
+		DryRun:     r.DryRun,
+		Select:     r.Select,
+		MaxBytes:   r.MaxBytes,
+	}
+	if r.BodyBase64 != "" {
+		body, err := base64.StdEncoding.DecodeString(r.BodyBase64)
+		if err != nil {
+			return CallRequest{}, fmt.Errorf("body_base64 is not valid base64: %w", err)
+		}
+		req.Body = body
+	}
+
+	for i, inj := range r.Injections {
+		style := strings.ToLower(inj.Style)
+		switch style {
+		case "bearer", "basic":
+		case "header", "query", "body", "form":
+			if inj.Target == "" {
+				return CallRequest{}, fmt.Errorf("injections[%d]: %s injection requires a target", i, style)
+			}
+		default:
+			return CallRequest{}, fmt.Errorf("injections[%d]: unknown style %q — must be bearer, basic, header, query, body, or form", i, inj.Style)
+		}
+		if inj.SecretKey == "" {
+			return CallRequest{}, fmt.Errorf("injections[%d]: secret_key is required", i)
+		}
+		req.Injections = append(req.Injections, Injection{Style: style, Target: inj.Target, SecretKey: inj.SecretKey})
+	}
+	for i, c := range r.Captures {
+		if c.Path == "" || c.SecretKey == "" {
+			return CallRequest{}, fmt.Errorf("captures[%d]: path and secret_key are required", i)
+		}
+		req.Captures = append(req.Captures, Capture{Path: c.Path, SecretKey: c.SecretKey})
+	}
+	return req, nil
+}
+
+// NewAPICallResponse wraps an engine result in the /v1/call envelope.
+func NewAPICallResponse(result *CallResult) *APICallResponse {
+	resp := &APICallResponse{
+		Status:   result.StatusCode,
+		Headers:  result.Headers,
+		Redacted: result.Redacted,
+		Captured: result.Captured,
+		Blocked:  result.Blocked,
+		Explain:  result.Explain,
+		Shaping:  result.Shaping,
+		Cached:   result.Cached,
+	}
+	if resp.Headers == nil {
+		resp.Headers = map[string][]string{}
+	}
+	if utf8.Valid(result.Body) {
+		resp.Body = string(result.Body)
+	} else {
+		resp.BodyBase64 = base64.StdEncoding.EncodeToString(result.Body)
+	}
+	return resp
+}
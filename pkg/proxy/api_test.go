@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func postCall(t *testing.T, srv *httptest.Server, body string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/v1/call", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	return resp, data
+}
+
+func TestServerV1Call(t *testing.T) {
+	var gotAccept []string
+	var gotBody []byte
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Values("Accept")
+		gotBody, _ = io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"echo":"sk_test_123"}`))
+	}))
+	defer upstream.Close()
+
+	engine, _ := redirectEngine(t)
+	proxySrv := httptest.NewServer(NewServer(0, engine).mux)
+	defer proxySrv.Close()
+
+	// A hyphenated JSON path, which X-AS-Inject-Body-* cannot express
+	resp, data := postCall(t, proxySrv, `{
+		"url": "`+upstream.URL+`/v1/orders",
+		"method": "post",
+		"headers": {"Accept": ["application/json", "text/plain"]},
+		"body": "{\"api-auth\":{}}",
+		"injections": [{"style": "body", "target": "api-auth.key", "secret_key": "API_KEY"}]
+	}`)
+	if resp.StatusCode != 200 {
+		t.Fatalf("status = %d: %s", resp.StatusCode, data)
+	}
+
+	var out APICallResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Status != 200 || !out.Redacted || out.Body != `{"echo":"[REDACTED_BY_AGENTSECRETS]"}` {
+		t.Errorf("envelope = %+v", out)
+	}
+	if len(gotAccept) != 2 {
+		t.Errorf("upstream Accept = %v, want both values", gotAccept)
+	}
+	if !bytes.Contains(gotBody, []byte(`"api-auth":{"key":"sk_test_123"}`)) {
+		t.Errorf("upstream body = %s", gotBody)
+	}
+}
+
+func TestServerV1CallBinaryBodies(t *testing.T) {
+	payload := []byte{0x89, 'P', 'N', 'G', 0xff, 0x00}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	engine, _ := redirectEngine(t)
+	proxySrv := httptest.NewServer(NewServer(0, engine).mux)
+	defer proxySrv.Close()
+
+	_, data := postCall(t, proxySrv, `{
+		"url": "`+upstream.URL+`/upload",
+		"method": "PUT",
+		"body_base64": "`+base64.StdEncoding.EncodeToString(payload)+`",
+		"injections": [{"style": "bearer", "secret_key": "API_KEY"}]
+	}`)
+	var out APICallResponse
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := base64.StdEncoding.DecodeString(out.BodyBase64)
+	if out.Body != "" || !bytes.Equal(got, payload) {
+		t.Errorf("envelope = %+v, want the payload in body_base64", out)
+	}
+}
+
+func TestServerV1CallBlockedAndInvalid(t *testing.T) {
+	engine, _ := redirectEngine(t)
+	engine.Policy = &Policy{Default: "deny"}
+	proxySrv := httptest.NewServer(NewServer(0, engine).mux)
+	defer proxySrv.Close()
+
+	resp, data := postCall(t, proxySrv, `{"url": "https://api.stripe.com/v1/charges", "injections": [{"style": "bearer", "secret_key": "API_KEY"}]}`)
+	var out APICallResponse
+	json.Unmarshal(data, &out)
+	if resp.StatusCode != 200 || out.Status != 403 || out.Blocked == "" {
+		t.Errorf("blocked call: status %d, envelope %+v", resp.StatusCode, out)
+	}
+
+	bad := []string{
+		`{"url": "https://api.stripe.com"}`,
+		`{"url": "https://api.stripe.com", "injections": [{"style": "header", "secret_key": "API_KEY"}]}`,
+		`{"url": "https://api.stripe.com", "injections": [{"style": "cookie", "secret_key": "API_KEY"}]}`,
+		`{"url": "https://api.stripe.com", "body": "x", "body_base64": "eA==", "injections": [{"style": "bearer", "secret_key": "API_KEY"}]}`,
+		`{"target_url": "https://api.stripe.com"}`,
+		`{"url": "https://api.stripe.com", "max_bytes": -1, "injections": [{"style": "bearer", "secret_key": "API_KEY"}]}`,
+		`{"url": "https://api.stripe.com", "cursor": "ab12:10"}`,
+	}
+	for _, body := range bad {
+		if resp, data := postCall(t, proxySrv, body); resp.StatusCode != 400 {
+			t.Errorf("%s: status %d (%s), want 400", body, resp.StatusCode, data)
+		}
+	}
+}
+
+func TestOpenAPISpecMatchesTypes(t *testing.T) {
+	var spec struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(OpenAPISpec, &spec); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+
+	// Every field of the wire types is documented
+	for schema, v := range map[string]any{
+		"CallRequest":  APICallRequest{},
+		"Injection":    APIInjection{},
+		"Capture":      APICapture{},
+		"CallResponse": APICallResponse{},
+		"Shaping":      Shaping{},
+	} {
+		typ := reflect.TypeOf(v)
+		for i := 0; i < typ.NumField(); i++ {
+			name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+			if _, ok := spec.Components.Schemas[schema].Properties[name]; !ok {
+				t.Errorf("%s.%s is missing from openapi.json", schema, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/The-17/agentsecrets/pkg/keyring"
+)
+
+// DefaultApprovalTimeout is how long a held request waits for a human decision.
+const DefaultApprovalTimeout = 5 * time.Minute
+
+// Approval statuses.
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalDenied   = "denied"
+)
+
+// PendingApproval is a request held until a human approves or denies it.
+// It carries key names only, never secret values.
+type PendingApproval struct {
+	ID         string    `json:"id"`
+	ProjectID  string    `json:"project_id,omitempty"`
+	AgentID    string    `json:"agent_id,omitempty"`
+	Method     string    `json:"method"`
+	TargetURL  string    `json:"target_url"`
+	SecretKeys []string  `json:"secret_keys"`
+	Reason     string    `json:"reason"` // which approval rule matched
+	CreatedAt  time.Time `json:"created_at"`
+	Status     string    `json:"status"`
+	DecidedAt  time.Time `json:"decided_at,omitempty"`
+	Signature  string    `json:"signature,omitempty"` // hex signature of an approval
+}
+
+// ApprovalQueue stores pending approvals as files so the process holding a
+// request (proxy, MCP server, call) and `agentsecrets proxy approve` can be
+// different processes.
+//
+// Anyone who can write the files can mark a request approved, agents
+// included, so an approval only counts when it is signed with the key derived
+// from the user's password. The holding process checks the signature against
+// VerifyKey and against its own copy of the request.
+type ApprovalQueue struct {
+	Dir       string
+	VerifyKey ed25519.PublicKey // checks approval signatures; Submit refuses requests without one
+}
+
+// DefaultApprovalDir returns ~/.agentsecrets/approvals
+func DefaultApprovalDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".agentsecrets", "approvals"), nil
+}
+
+// approvalVerifyKey reads the approval public key `agentsecrets login` stores
+// in the keychain. It is nil for users who have not logged in since.
+func approvalVerifyKey() ed25519.PublicKey {
+	key, _, err := keyring.GetApprovalKey()
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil
+	}
+	return ed25519.PublicKey(key)
+}
+
+// NewApprovalQueue opens the queue in dir, or the default directory if dir is empty.
+func NewApprovalQueue(dir string) (*ApprovalQueue, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultApprovalDir(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create approvals directory: %w", err)
+	}
+	return &ApprovalQueue{Dir: dir}, nil
+}
+
+// Submit adds a pending approval and assigns its ID.
+func (q *ApprovalQueue) Submit(p *PendingApproval) error {
+	if len(q.VerifyKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("approvals cannot be verified: run 'agentsecrets login' once to set up your approval key")
+	}
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("generate approval id: %w", err)
+	}
+	p.ID = hex.EncodeToString(buf)
+	p.Status = ApprovalPending
+	p.CreatedAt = time.Now().UTC()
+	return q.save(p)
+}
+
+// Get returns the approval with the given ID.
+func (q *ApprovalQueue) Get(id string) (*PendingApproval, error) {
+	if !validHexID(id) {
+		return nil, fmt.Errorf("invalid approval id %q", id)
+	}
+	data, err := os.ReadFile(q.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no approval request %s (it may have timed out)", id)
+		}
+		return nil, err
+	}
+	var p PendingApproval
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse approval %s: %w", id, err)
+	}
+	return &p, nil
+}
+
+// Pending lists approvals still waiting for a decision, oldest first.
+func (q *ApprovalQueue) Pending() ([]*PendingApproval, error) {
+	entries, err := os.ReadDir(q.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*PendingApproval
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		p, err := q.Get(id)
+		if err != nil || p.Status != ApprovalPending {
+			continue
+		}
+		pending = append(pending, p)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+	return pending, nil
+}
+
+// Decide approves or denies a pending request. Approving signs the request
+// with key, the approval key derived from the user's password; denying needs
+// no key.
+func (q *ApprovalQueue) Decide(id string, approve bool, key ed25519.PrivateKey) (*PendingApproval, error) {
+	p, err := q.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if p.Status != ApprovalPending {
+		return nil, fmt.Errorf("approval request %s was already %s", id, p.Status)
+	}
+	p.Status = ApprovalDenied
+	if approve {
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, fmt.Errorf("approving request %s requires your approval key", id)
+		}
+		p.Status = ApprovalApproved
+		p.Signature = hex.EncodeToString(ed25519.Sign(key, p.signedBytes()))
+	}
+	p.DecidedAt = time.Now().UTC()
+	return p, q.save(p)
+}
+
+// signedBytes is what an approval signs: the request as it was held, so a
+// file edited to show another call does not carry its approval over.
+func (p *PendingApproval) signedBytes() []byte {
+	data, _ := json.Marshal(struct {
+		ID         string   `json:"id"`
+		ProjectID  string   `json:"project_id"`
+		AgentID    string   `json:"agent_id"`
+		Method     string   `json:"method"`
+		TargetURL  string   `json:"target_url"`
+		SecretKeys []string `json:"secret_keys"`
+		Reason     string   `json:"reason"`
+		CreatedAt  int64    `json:"created_at"`
+		Status     string   `json:"status"`
+	}{p.ID, p.ProjectID, p.AgentID, p.Method, p.TargetURL, p.SecretKeys, p.Reason, p.CreatedAt.UnixNano(), ApprovalApproved})
+	return data
+}
+
+// Wait polls until the request held submitted is decided or timeout passes,
+// calling progress every few seconds while it waits. It returns the final
+// status; a request that times out is removed and reported as pending. An
+// approval whose signature does not verify against held is an error.
+func (q *ApprovalQueue) Wait(held *PendingApproval, timeout time.Duration, progress func(waited time.Duration)) (string, error) {
+	id := held.ID
+	defer q.remove(id)
+
+	start := time.Now()
+	lastProgress := start
+	for {
+		p, err := q.Get(id)
+		if err != nil {
+			return "", err
+		}
+		if p.Status == ApprovalApproved && !q.verify(held, p.Signature) {
+			return "", fmt.Errorf("approval request %s was marked approved without a valid signature", id)
+		}
+		if p.Status != ApprovalPending {
+			return p.Status, nil
+		}
+		if time.Since(start) >= timeout {
+			return ApprovalPending, nil
+		}
+		if progress != nil && time.Since(lastProgress) >= 5*time.Second {
+			lastProgress = time.Now()
+			progress(time.Since(start))
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func (q *ApprovalQueue) verify(held *PendingApproval, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(q.VerifyKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(q.VerifyKey, held.signedBytes(), sig)
+}
+
+func (q *ApprovalQueue) path(id string) string {
+	return filepath.Join(q.Dir, id+".json")
+}
+
+func (q *ApprovalQueue) save(p *PendingApproval) error {
+	if err := writeJSONAtomic(q.Dir, q.path(p.ID), p); err != nil {
+		return fmt.Errorf("save approval: %w", err)
+	}
+	return nil
+}
+
+// writeJSONAtomic writes v to path through a temp file in dir so a reader in
+// another process never sees a half-written file.
+func writeJSONAtomic(dir, path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (q *ApprovalQueue) remove(id string) {
+	if validHexID(id) {
+		_ = os.Remove(q.path(id))
+	}
+}
+
+func validHexID(id string) bool {
+	if id == "" {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
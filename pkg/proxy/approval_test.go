@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decideFirst waits for a pending approval to appear and decides it with
+// decide.
+func decideFirst(t *testing.T, q *ApprovalQueue, decide func(p *PendingApproval)) {
+	t.Helper()
+	go func() {
+		for i := 0; i < 200; i++ {
+			pending, _ := q.Pending()
+			if len(pending) > 0 {
+				decide(pending[0])
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+// approvalEngine returns an engine that holds DELETE calls, and the key that
+// signs their approvals.
+func approvalEngine(t *testing.T, client *http.Client) (*Engine, ed25519.PrivateKey) {
+	t.Helper()
+	q, err := NewApprovalQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.VerifyKey = pub
+	return &Engine{
+		ProjectID:     "test-project",
+		Client:        client,
+		ResolveSecret: mockResolver(map[string]string{"STRIPE_KEY": "sk_test_123"}),
+		SkipAllowlist: true,
+		Policy: &Policy{Approvals: []ApprovalRule{
+			{Methods: []string{"DELETE"}},
+		}},
+		Approvals:       q,
+		ApprovalTimeout: 5 * time.Second,
+	}, key
+}
+
+func TestEngineExecuteApprovalGranted(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	defer upstream.Close()
+
+	engine, key := approvalEngine(t, upstream.Client())
+	decideFirst(t, engine.Approvals, func(p *PendingApproval) { engine.Approvals.Decide(p.ID, true, key) })
+
+	var notices []string
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  upstream.URL + "/v1/customers/cus_1",
+		Method:     "DELETE",
+		Injections: []Injection{{Style: "bearer", SecretKey: "STRIPE_KEY"}},
+		Progress:   func(msg string) { notices = append(notices, msg) },
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 204 {
+		t.Errorf("StatusCode = %d, want 204", result.StatusCode)
+	}
+	if len(notices) == 0 || !strings.Contains(notices[0], "STRIPE_KEY") {
+		t.Errorf("expected an approval notice naming the secret, got %v", notices)
+	}
+}
+
+func TestEngineExecuteApprovalDenied(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	engine, _ := approvalEngine(t, upstream.Client())
+	decideFirst(t, engine.Approvals, func(p *PendingApproval) { engine.Approvals.Decide(p.ID, false, nil) })
+
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  upstream.URL + "/v1/customers/cus_1",
+		Method:     "DELETE",
+		Injections: []Injection{{Style: "bearer", SecretKey: "STRIPE_KEY"}},
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 403 || !strings.Contains(string(result.Body), "approval_denied") {
+		t.Errorf("got %d %s, want 403 approval_denied", result.StatusCode, result.Body)
+	}
+	if called {
+		t.Error("a denied call must not reach upstream")
+	}
+	if pending, _ := engine.Approvals.Pending(); len(pending) != 0 {
+		t.Errorf("expected the queue to be empty, got %d pending", len(pending))
+	}
+}
+
+func TestEngineExecuteApprovalTampered(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	tests := []struct {
+		name   string
+		tamper func(q *ApprovalQueue, key ed25519.PrivateKey, p *PendingApproval)
+	}{
+		{"status written by hand", func(q *ApprovalQueue, _ ed25519.PrivateKey, p *PendingApproval) {
+			p.Status = ApprovalApproved
+			q.save(p)
+		}},
+		{"signed with another key", func(q *ApprovalQueue, _ ed25519.PrivateKey, p *PendingApproval) {
+			q.Decide(p.ID, true, otherKey)
+		}},
+		{"request rewritten before approval", func(q *ApprovalQueue, key ed25519.PrivateKey, p *PendingApproval) {
+			p.TargetURL = "https://api.stripe.com/v1/balance"
+			q.save(p)
+			q.Decide(p.ID, true, key)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, key := approvalEngine(t, upstream.Client())
+			decideFirst(t, engine.Approvals, func(p *PendingApproval) { tt.tamper(engine.Approvals, key, p) })
+
+			result, err := engine.Execute(CallRequest{
+				TargetURL:  upstream.URL + "/v1/customers/cus_1",
+				Method:     "DELETE",
+				Injections: []Injection{{Style: "bearer", SecretKey: "STRIPE_KEY"}},
+			})
+			if err != nil {
+				t.Fatalf("Execute() error: %v", err)
+			}
+			if result.StatusCode != 403 || !strings.Contains(string(result.Body), "approval_unavailable") {
+				t.Errorf("got %d %s, want 403 approval_unavailable", result.StatusCode, result.Body)
+			}
+			if called {
+				t.Error("a tampered approval must not reach upstream")
+			}
+		})
+	}
+}
+
+func TestApprovalQueueSubmitNeedsVerifyKey(t *testing.T) {
+	q, err := NewApprovalQueue(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Submit(&PendingApproval{Method: "DELETE"}); err == nil {
+		t.Fatal("a queue that cannot verify approvals accepted a request")
+	}
+	if entries, _ := os.ReadDir(q.Dir); len(entries) != 0 {
+		t.Errorf("%d files written", len(entries))
+	}
+
+	q.VerifyKey, _, _ = ed25519.GenerateKey(nil)
+	p := &PendingApproval{Method: "DELETE"}
+	if err := q.Submit(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Decide(p.ID, true, nil); err == nil {
+		t.Error("an approval without a key was accepted")
+	}
+	data, _ := os.ReadFile(q.path(p.ID))
+	var saved PendingApproval
+	json.Unmarshal(data, &saved)
+	if saved.Status != ApprovalPending {
+		t.Errorf("status = %q after a refused approval", saved.Status)
+	}
+}
+
+func TestApprovalRuleNewDomain(t *testing.T) {
+	p := &Policy{Approvals: []ApprovalRule{{NewDomain: true}}}
+	req := PolicyRequest{Method: "GET", TargetURL: "https://api.new.com/", NewDomain: true}
+
+	if d := p.Evaluate(req); !d.Allowed || !d.RequireApproval {
+		t.Errorf("first call to a domain should require approval, got %+v", d)
+	}
+	req.NewDomain = false
+	if d := p.Evaluate(req); !d.Allowed || d.RequireApproval {
+		t.Errorf("a known domain should not require approval, got %+v", d)
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"sync"
+
+	"github.com/The-17/agentsecrets/pkg/keyring"
+)
+
+// MaxBatchCalls caps the number of calls in one batch.
+const MaxBatchCalls = 100
+
+// DefaultBatchConcurrency is how many calls of a batch run at once unless the
+// caller asks otherwise; MaxBatchConcurrency bounds what it may ask for.
+const (
+	DefaultBatchConcurrency = 8
+	MaxBatchConcurrency     = 32
+)
+
+// BatchResult is the outcome of one call in a batch: a result, or the error
+// Execute would have returned for it.
+type BatchResult struct {
+	Result *CallResult
+	Err    error
+}
+
+// ExecuteBatch runs reqs with at most concurrency calls in flight and returns
+// their results in the same order. The allowlist is read once and each secret
+// resolved once for the whole batch; every call is still checked, forwarded
+// and audited on its own.
+func (e *Engine) ExecuteBatch(reqs []CallRequest, concurrency int) []BatchResult {
+	if concurrency <= 0 {
+		concurrency = DefaultBatchConcurrency
+	}
+	concurrency = min(concurrency, MaxBatchConcurrency)
+
+	b := &batchState{secrets: make(map[string]resolvedSecret)}
+	results := make([]BatchResult, len(reqs))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			req.batch = b
+			results[i].Result, results[i].Err = e.Execute(req)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// batchState memoizes keyring reads across the calls of one batch. A nil
+// batchState reads the keyring every time.
+type batchState struct {
+	allowlistOnce sync.Once
+	allowlistVal  []string
+	allowlistErr  error
+
+	mu      sync.Mutex
+	secrets map[string]resolvedSecret
+}
+
+type resolvedSecret struct {
+	value string
+	err   error
+}
+
+func (b *batchState) allowlist(workspaceID string) ([]string, error) {
+	if b == nil {
+		return keyring.GetWorkspaceAllowlist(workspaceID)
+	}
+	b.allowlistOnce.Do(func() {
+		b.allowlistVal, b.allowlistErr = keyring.GetWorkspaceAllowlist(workspaceID)
+	})
+	return b.allowlistVal, b.allowlistErr
+}
+
+func (b *batchState) resolve(resolve SecretResolver, key string) (string, error) {
+	if b == nil {
+		return resolve(key)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.secrets[key]
+	if !ok {
+		s.value, s.err = resolve(key)
+		b.secrets[key] = s
+	}
+	return s.value, s.err
+}
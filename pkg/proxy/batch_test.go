@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEngineExecuteBatch(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	var resolved atomic.Int32
+	engine.ResolveSecret = func(key string) (string, error) {
+		resolved.Add(1)
+		return mockResolver(map[string]string{"API_KEY": "sk_test_123"})(key)
+	}
+
+	var reqs []CallRequest
+	for _, path := range []string{"/issues/1", "/issues/2", "/issues/3", "/issues/4", "/issues/5", "/issues/6"} {
+		reqs = append(reqs, CallRequest{
+			TargetURL:  upstream.URL + path,
+			Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+		})
+	}
+	reqs = append(reqs, CallRequest{
+		TargetURL:  upstream.URL + "/issues/7",
+		Injections: []Injection{{Style: "bearer", SecretKey: "MISSING_KEY"}},
+	})
+
+	results := engine.ExecuteBatch(reqs, 3)
+	if len(results) != len(reqs) {
+		t.Fatalf("got %d results, want %d", len(results), len(reqs))
+	}
+	for i, res := range results[:6] {
+		if res.Err != nil || string(res.Result.Body) != strings.TrimPrefix(reqs[i].TargetURL, upstream.URL) {
+			t.Errorf("result %d = %+v, %v", i, res.Result, res.Err)
+		}
+	}
+	if results[6].Err == nil || !strings.Contains(results[6].Err.Error(), "MISSING_KEY") {
+		t.Errorf("result 6 error = %v, want the missing secret", results[6].Err)
+	}
+
+	if got := peak.Load(); got > 3 || got < 2 {
+		t.Errorf("peak concurrency = %d, want 2..3", got)
+	}
+	if got := resolved.Load(); got != 2 {
+		t.Errorf("resolved secrets %d times, want once per key", got)
+	}
+
+	// One audit event per successful call
+	data, _ := os.ReadFile(logPath)
+	if got := strings.Count(string(data), "\n"); got != 6 {
+		t.Errorf("audit log has %d events, want 6", got)
+	}
+}
+
+func TestServerV1Batch(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	engine, _ := redirectEngine(t)
+	proxySrv := httptest.NewServer(NewServer(0, engine).mux)
+	defer proxySrv.Close()
+
+	resp, err := http.Post(proxySrv.URL+"/v1/batch", "application/json", strings.NewReader(`{"calls": [
+		{"url": "`+upstream.URL+`/a", "injections": [{"style": "bearer", "secret_key": "API_KEY"}]},
+		{"url": "`+upstream.URL+`/b"},
+		{"url": "`+upstream.URL+`/c", "injections": [{"style": "bearer", "secret_key": "API_KEY"}]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var out APIBatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Results) != 3 {
+		t.Fatalf("results = %+v", out.Results)
+	}
+	if r := out.Results[0]; r.Response == nil || r.Response.Body != "/a" {
+		t.Errorf("result 0 = %+v", r)
+	}
+	if r := out.Results[1]; r.Response != nil || !strings.Contains(r.Error, "injection") {
+		t.Errorf("result 1 = %+v, want an injection error", r)
+	}
+	if r := out.Results[2]; r.Response == nil || r.Response.Body != "/c" {
+		t.Errorf("result 2 = %+v", r)
+	}
+
+	resp, err = http.Post(proxySrv.URL+"/v1/batch", "application/json", strings.NewReader(`{"calls": []}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 400 {
+		t.Errorf("empty batch status = %d, want 400", resp.StatusCode)
+	}
+}
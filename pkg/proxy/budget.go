@@ -0,0 +1,309 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultBudgetPath is the per-project budget file, relative to the project root.
+var DefaultBudgetPath = filepath.Join(".agentsecrets", "budget.yaml")
+
+// DefaultGlobalBudgetPath returns ~/.agentsecrets/budget.yaml, which applies
+// to every project.
+func DefaultGlobalBudgetPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".agentsecrets", "budget.yaml"), nil
+}
+
+// BudgetConfig caps what agents spend on LLM APIs. Usage is read from
+// provider responses and priced per million tokens.
+//
+//	providers: [api.groq.com]   # OpenAI-compatible APIs besides the built-in ones
+//	prices:                     # USD per million tokens; matched before the built-in table
+//	  - model: "gpt-4o-mini*"
+//	    input: 0.15
+//	    output: 0.60
+//	budgets:                    # periods are UTC days and months
+//	  - secret: OPENAI_KEY
+//	    daily: 20               # USD
+//	    monthly: 300
+//	  - agent: research-bot
+//	    monthly_tokens: 5000000
+type BudgetConfig struct {
+	Providers []string     `yaml:"providers,omitempty"`
+	Prices    []ModelPrice `yaml:"prices,omitempty"`
+	Budgets   []Budget     `yaml:"budgets,omitempty"`
+}
+
+// Budget limits the spend of calls using Secret, made by Agent, or both. A
+// budget naming neither covers every LLM call.
+type Budget struct {
+	Secret        string  `yaml:"secret,omitempty"`
+	Agent         string  `yaml:"agent,omitempty"`
+	Daily         float64 `yaml:"daily,omitempty"`   // USD
+	Monthly       float64 `yaml:"monthly,omitempty"` // USD
+	DailyTokens   int64   `yaml:"daily_tokens,omitempty"`
+	MonthlyTokens int64   `yaml:"monthly_tokens,omitempty"`
+}
+
+// LoadBudgetConfigs reads the global and the project budget files and merges
+// them. Budgets from both apply; project prices are matched first.
+func LoadBudgetConfigs() (*BudgetConfig, error) {
+	return loadBudgetConfigs(DefaultBudgetPath)
+}
+
+// loadBudgetConfigs is LoadBudgetConfigs with the project file at projectPath.
+func loadBudgetConfigs(projectPath string) (*BudgetConfig, error) {
+	globalPath, err := DefaultGlobalBudgetPath()
+	if err != nil {
+		return nil, err
+	}
+	global, err := LoadBudgetConfig(globalPath)
+	if err != nil {
+		return nil, err
+	}
+	project, err := LoadBudgetConfig(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil || global == nil {
+		if project != nil {
+			return project, nil
+		}
+		return global, nil
+	}
+	return &BudgetConfig{
+		Providers: append(append([]string{}, project.Providers...), global.Providers...),
+		Prices:    append(append([]ModelPrice{}, project.Prices...), global.Prices...),
+		Budgets:   append(append([]Budget{}, project.Budgets...), global.Budgets...),
+	}, nil
+}
+
+// LoadBudgetConfig reads a budget file. A missing file yields nil: usage is
+// still recorded, with the built-in prices, but nothing is capped.
+func LoadBudgetConfig(path string) (*BudgetConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read budget config: %w", err)
+	}
+
+	var c BudgetConfig
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse budget config %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid budget config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Validate checks for prices and limits that cannot be meant.
+func (c *BudgetConfig) Validate() error {
+	for i, p := range c.Prices {
+		if p.Model == "" {
+			return fmt.Errorf("price %d: model is required", i+1)
+		}
+		if p.Input < 0 || p.Output < 0 {
+			return fmt.Errorf("price %d: prices must not be negative", i+1)
+		}
+	}
+	for i, b := range c.Budgets {
+		if b.Daily < 0 || b.Monthly < 0 || b.DailyTokens < 0 || b.MonthlyTokens < 0 {
+			return fmt.Errorf("budget %d: limits must not be negative", i+1)
+		}
+		if b.Daily == 0 && b.Monthly == 0 && b.DailyTokens == 0 && b.MonthlyTokens == 0 {
+			return fmt.Errorf("budget %d: set daily, monthly, daily_tokens or monthly_tokens", i+1)
+		}
+	}
+	return nil
+}
+
+// IsProvider reports whether responses from domain are parsed for usage.
+func (c *BudgetConfig) IsProvider(domain string) bool {
+	if matchAny(llmDomains, domain, true) {
+		return true
+	}
+	return c != nil && matchAny(c.Providers, domain, true)
+}
+
+func (c *BudgetConfig) prices() []ModelPrice {
+	if c == nil {
+		return nil
+	}
+	return c.Prices
+}
+
+// covers reports whether a call using secretKeys made by agent counts
+// against b.
+func (b *Budget) covers(secretKeys []string, agent string) bool {
+	return (b.Secret == "" || slices.Contains(secretKeys, b.Secret)) &&
+		(b.Agent == "" || b.Agent == agent)
+}
+
+func (b *Budget) String() string {
+	switch {
+	case b.Secret != "" && b.Agent != "":
+		return fmt.Sprintf("secret %s for agent %q", b.Secret, b.Agent)
+	case b.Secret != "":
+		return "secret " + b.Secret
+	case b.Agent != "":
+		return fmt.Sprintf("agent %q", b.Agent)
+	}
+	return "all LLM calls"
+}
+
+// UsageTotals is what a set of calls spent in one period.
+type UsageTotals struct {
+	CostUSD float64
+	Tokens  int64
+	Calls   int
+}
+
+func (t *UsageTotals) add(u *Usage) {
+	t.CostUSD += u.CostUSD
+	t.Tokens += u.Tokens()
+	t.Calls++
+}
+
+// usageLedger keeps this month's LLM usage from the audit log. Each look
+// reads only what was appended since the last one, so calls made by other
+// processes, such as the MCP server and `agentsecrets call`, count too.
+type usageLedger struct {
+	mu     sync.Mutex
+	offset int64
+	events []AuditEvent // this month's events with usage
+}
+
+// refresh reads events appended to the log at path.
+func (l *usageLedger) refresh(path string, now time.Time) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() < l.offset {
+		l.offset, l.events = 0, nil // the log was rotated
+	}
+	if _, err := f.Seek(l.offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	month := monthStart(now)
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break // a partial line is read again next time
+		}
+		l.offset += int64(len(line))
+		var event AuditEvent
+		if json.Unmarshal(line, &event) == nil && event.Usage != nil && !event.Timestamp.Before(month) {
+			l.events = append(l.events, event)
+		}
+	}
+	// Drop last month's events once a new month starts
+	for len(l.events) > 0 && l.events[0].Timestamp.Before(month) {
+		l.events = l.events[1:]
+	}
+	return nil
+}
+
+// Spent totals the usage in events that b covers since the start of today
+// and of this month, in UTC.
+func (b *Budget) Spent(events []AuditEvent, now time.Time) (today, month UsageTotals) {
+	day, start := now.UTC().Truncate(24*time.Hour), monthStart(now)
+	for i := range events {
+		e := &events[i]
+		if e.Usage == nil || e.Timestamp.Before(start) || !b.covers(e.SecretKeys, e.AgentID) {
+			continue
+		}
+		month.add(e.Usage)
+		if !e.Timestamp.Before(day) {
+			today.add(e.Usage)
+		}
+	}
+	return today, month
+}
+
+func monthStart(now time.Time) time.Time {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// checkBudget returns a message when a budget covering call is used up.
+// Calls to domains that are not LLM providers are never limited.
+func (e *Engine) checkBudget(call *checkedCall) (string, error) {
+	if e.Budget == nil || len(e.Budget.Budgets) == 0 || !e.Budget.IsProvider(call.domain) {
+		return "", nil
+	}
+	if e.Audit == nil {
+		// Without the log nothing is metered, so a cap could never be reached
+		msg := "budgets need the audit log, which is unavailable; calls to LLM providers are blocked while budgets are set"
+		call.explain("budget", "block", msg)
+		return msg, nil
+	}
+
+	now := time.Now()
+	e.ledger.mu.Lock()
+	defer e.ledger.mu.Unlock()
+	if err := e.ledger.refresh(e.Audit.Path(), now); err != nil {
+		return "", fmt.Errorf("read usage from the audit log: %w", err)
+	}
+
+	var details []string
+	for i := range e.Budget.Budgets {
+		b := &e.Budget.Budgets[i]
+		if !b.covers(call.secretKeys, call.req.AgentID) {
+			continue
+		}
+		today, month := b.Spent(e.ledger.events, now)
+		for _, limit := range []struct {
+			period  string
+			spent   float64
+			limit   float64
+			dollars bool
+		}{
+			{"daily", today.CostUSD, b.Daily, true},
+			{"monthly", month.CostUSD, b.Monthly, true},
+			{"daily token", float64(today.Tokens), float64(b.DailyTokens), false},
+			{"monthly token", float64(month.Tokens), float64(b.MonthlyTokens), false},
+		} {
+			if limit.limit == 0 {
+				continue
+			}
+			amount := fmt.Sprintf("%.0f of %.0f tokens", limit.spent, limit.limit)
+			if limit.dollars {
+				amount = fmt.Sprintf("$%.2f of $%.2f", limit.spent, limit.limit)
+			}
+			if limit.spent >= limit.limit {
+				msg := fmt.Sprintf("%s budget for %s is used up (%s)", limit.period, b, amount)
+				call.explain("budget", "block", msg)
+				return msg, nil
+			}
+			details = append(details, fmt.Sprintf("%s %s: %s", b, limit.period, amount))
+		}
+	}
+	if len(details) > 0 {
+		call.explain("budget", "pass", strings.Join(details, "; "))
+	}
+	return "", nil
+}
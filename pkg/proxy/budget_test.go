@@ -0,0 +1,210 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteEnforcesBudget(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gpt-4o","usage":{"prompt_tokens":400000,"completion_tokens":100000}}`)) // $2.00
+	}))
+	defer llm.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model":"gpt-4o","usage":{"prompt_tokens":1,"completion_tokens":1}}`))
+	}))
+	defer other.Close()
+
+	engine, logPath := redirectEngine(t)
+	engine.Budget = &BudgetConfig{
+		Providers: []string{"127.0.0.1"},
+		Budgets:   []Budget{{Secret: "API_KEY", Agent: "bot", Daily: 3}},
+	}
+	call := func(target, agent string) *CallResult {
+		t.Helper()
+		result, err := engine.Execute(CallRequest{
+			TargetURL:  target,
+			Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+			AgentID:    agent,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// Two calls fit under $3 before the budget is checked again
+	for i := 0; i < 2; i++ {
+		if result := call(llm.URL+"/v1/chat/completions", "bot"); result.Blocked != "" {
+			t.Fatalf("call %d blocked: %s", i+1, result.Body)
+		}
+	}
+	if event := lastAuditEvent(t, logPath); event.Usage == nil || event.Usage.Model != "gpt-4o" || event.Usage.CostUSD != 2 {
+		t.Fatalf("audit usage = %+v", event.Usage)
+	}
+
+	result := call(llm.URL+"/v1/chat/completions", "bot")
+	if result.StatusCode != 403 || result.Blocked != "budget_exceeded" || !strings.Contains(string(result.Body), "$4.00 of $3.00") {
+		t.Fatalf("over budget: %d %s", result.StatusCode, result.Body)
+	}
+	if event := lastAuditEvent(t, logPath); event.Reason != "budget_exceeded" {
+		t.Errorf("audit reason = %q", event.Reason)
+	}
+
+	// Another agent is not covered by the budget
+	if result := call(llm.URL+"/v1/chat/completions", "other-bot"); result.Blocked != "" {
+		t.Errorf("uncovered agent blocked: %s", result.Body)
+	}
+
+	// A call that is not to an LLM provider is neither limited nor metered
+	engine.Budget.Providers = nil
+	if result := call(other.URL, "bot"); result.Blocked != "" {
+		t.Errorf("non-provider call blocked: %s", result.Body)
+	}
+	if event := lastAuditEvent(t, logPath); event.Usage != nil {
+		t.Errorf("non-provider call metered: %+v", event.Usage)
+	}
+}
+
+func TestBudgetCountsOtherProcesses(t *testing.T) {
+	engine, logPath := redirectEngine(t)
+	engine.Budget = &BudgetConfig{
+		Providers: []string{"api.example-llm.com"},
+		Budgets:   []Budget{{Secret: "API_KEY", MonthlyTokens: 1000}},
+	}
+	call := &checkedCall{domain: "api.example-llm.com", secretKeys: []string{"API_KEY"}}
+	if msg, err := engine.checkBudget(call); err != nil || msg != "" {
+		t.Fatalf("empty log: %q, %v", msg, err)
+	}
+
+	// Another process logs usage; the next check sees it
+	other, err := NewAuditLogger(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.Log(AuditEvent{Timestamp: time.Now().UTC(), SecretKeys: []string{"API_KEY"}, Status: "OK", Usage: &Usage{InputTokens: 600, OutputTokens: 400}})
+	// Last month's usage does not count
+	other.Log(AuditEvent{Timestamp: time.Now().UTC().AddDate(0, -1, 0), SecretKeys: []string{"API_KEY"}, Status: "OK", Usage: &Usage{InputTokens: 5000}})
+
+	msg, err := engine.checkBudget(call)
+	if err != nil || !strings.Contains(msg, "monthly token budget for secret API_KEY is used up (1000 of 1000 tokens)") {
+		t.Errorf("after other process: %q, %v", msg, err)
+	}
+}
+
+func TestBudgetWithoutAuditLogBlocks(t *testing.T) {
+	engine, _ := redirectEngine(t)
+	engine.Audit = nil
+	engine.Budget = &BudgetConfig{
+		Providers: []string{"api.example-llm.com"},
+		Budgets:   []Budget{{Secret: "API_KEY", Daily: 5}},
+	}
+	call := &checkedCall{domain: "api.example-llm.com", secretKeys: []string{"API_KEY"}}
+	if msg, err := engine.checkBudget(call); err != nil || !strings.Contains(msg, "need the audit log") {
+		t.Errorf("without audit log: %q, %v", msg, err)
+	}
+
+	// Domains that are not providers are never limited
+	call.domain = "api.github.com"
+	if msg, err := engine.checkBudget(call); err != nil || msg != "" {
+		t.Errorf("non-provider: %q, %v", msg, err)
+	}
+}
+
+func TestPhantomProxyEnforcesBudget(t *testing.T) {
+	llm := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"gpt-4o","usage":{"prompt_tokens":400000,"completion_tokens":100000}}`)) // $2.00
+	}))
+	defer llm.Close()
+
+	engine, logPath := redirectEngine(t)
+	engine.Budget = &BudgetConfig{
+		Providers: []string{"127.0.0.1"},
+		Budgets:   []Budget{{Secret: "OPENAI_KEY", Daily: 1}},
+	}
+	p, placeholders, err := NewPhantomProxy(engine, map[string]string{"OPENAI_KEY": "sk-real-9f8e7d6c5b4a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyURL, err := p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	pu, _ := url.Parse(proxyURL)
+	child := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(pu)}}
+	call := func() *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest("POST", llm.URL+"/v1/chat/completions", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer "+placeholders["OPENAI_KEY"])
+		resp, err := child.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	if resp := call(); resp.StatusCode != 200 {
+		t.Fatalf("first call = %d", resp.StatusCode)
+	}
+	if event := lastAuditEvent(t, logPath); event.Usage == nil || event.Usage.CostUSD != 2 {
+		t.Fatalf("phantom call usage = %+v", event.Usage)
+	}
+	if resp := call(); resp.StatusCode != 403 {
+		t.Errorf("over budget = %d, want 403", resp.StatusCode)
+	}
+	if event := lastAuditEvent(t, logPath); event.Reason != "budget_exceeded" || event.AgentID != "env" {
+		t.Errorf("audit = %+v", event)
+	}
+}
+
+func TestLoadBudgetConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "budget.yaml")
+	os.WriteFile(path, []byte(`
+providers: [api.groq.com]
+prices:
+  - model: "llama-*"
+    input: 0.59
+    output: 0.79
+budgets:
+  - secret: OPENAI_KEY
+    daily: 20
+    monthly: 300
+`), 0600)
+	c, err := LoadBudgetConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.IsProvider("api.groq.com") || !c.IsProvider("api.openai.com") || c.IsProvider("api.github.com") {
+		t.Errorf("IsProvider wrong for %+v", c.Providers)
+	}
+	if len(c.Budgets) != 1 || c.Budgets[0].Monthly != 300 || c.Prices[0].Output != 0.79 {
+		t.Errorf("config = %+v", c)
+	}
+
+	if c, err := LoadBudgetConfig(filepath.Join(dir, "missing.yaml")); c != nil || err != nil {
+		t.Errorf("missing file = %v, %v", c, err)
+	}
+
+	for _, bad := range []string{
+		"budgets:\n  - secret: X\n",
+		"budgets:\n  - secret: X\n    daily: -1\n",
+		"prices:\n  - input: 1\n",
+	} {
+		os.WriteFile(path, []byte(bad), 0600)
+		if _, err := LoadBudgetConfig(path); err == nil {
+			t.Errorf("expected %q to be invalid", bad)
+		}
+	}
+}
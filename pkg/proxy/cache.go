@@ -0,0 +1,514 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/The-17/agentsecrets/pkg/crypto"
+	"github.com/The-17/agentsecrets/pkg/keyring"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultCacheEntries bounds the cache when the config does not.
+const DefaultCacheEntries = 500
+
+// DefaultCachePath is the per-project cache file, relative to the project root.
+var DefaultCachePath = filepath.Join(".agentsecrets", "cache.yaml")
+
+// DefaultGlobalCachePath returns ~/.agentsecrets/cache.yaml, which applies to
+// every project.
+func DefaultGlobalCachePath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".agentsecrets", "cache.yaml"), nil
+}
+
+// CacheConfig turns on caching of GET responses. Without one nothing is
+// cached: a stale answer is only harmless where the user says so.
+//
+//	ttl: 30s                  # for every domain; omit to cache only the rules below
+//	persist: true             # also keep entries on disk, encrypted, across restarts
+//	max_entries: 1000
+//	rules:                    # first match wins
+//	  - domains: [api.github.com]
+//	    ttl: 5m
+//	  - domains: ["*.stripe.com"]
+//	    ttl: 0                # never cached
+type CacheConfig struct {
+	TTL        time.Duration `yaml:"ttl,omitempty"`
+	Persist    bool          `yaml:"persist,omitempty"`
+	MaxEntries int           `yaml:"max_entries,omitempty"` // DefaultCacheEntries if zero
+	Rules      []CacheRule   `yaml:"rules,omitempty"`
+}
+
+// CacheRule sets the TTL for responses from Domains.
+type CacheRule struct {
+	Domains []string      `yaml:"domains"`
+	TTL     time.Duration `yaml:"ttl"`
+}
+
+// LoadCacheConfigs reads the global and the project cache files and merges
+// them: project rules are matched first and project settings win.
+func LoadCacheConfigs() (*CacheConfig, error) {
+	return loadCacheConfigs(DefaultCachePath)
+}
+
+// loadCacheConfigs is LoadCacheConfigs with the project file at projectPath.
+func loadCacheConfigs(projectPath string) (*CacheConfig, error) {
+	globalPath, err := DefaultGlobalCachePath()
+	if err != nil {
+		return nil, err
+	}
+	global, err := LoadCacheConfig(globalPath)
+	if err != nil {
+		return nil, err
+	}
+	project, err := LoadCacheConfig(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	if project == nil || global == nil {
+		if project != nil {
+			return project, nil
+		}
+		return global, nil
+	}
+	merged := *project
+	merged.Rules = append(append([]CacheRule{}, project.Rules...), global.Rules...)
+	if merged.TTL == 0 {
+		merged.TTL = global.TTL
+	}
+	if merged.MaxEntries == 0 {
+		merged.MaxEntries = global.MaxEntries
+	}
+	merged.Persist = project.Persist || global.Persist
+	return &merged, nil
+}
+
+// LoadCacheConfig reads a cache file. A missing file yields nil, which
+// caches nothing.
+func LoadCacheConfig(path string) (*CacheConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read cache config: %w", err)
+	}
+
+	var c CacheConfig
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse cache config %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cache config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Validate checks for settings that cannot be meant.
+func (c *CacheConfig) Validate() error {
+	if c.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	if c.MaxEntries < 0 {
+		return fmt.Errorf("max_entries must not be negative")
+	}
+	for i, r := range c.Rules {
+		if len(r.Domains) == 0 {
+			return fmt.Errorf("rule %d: domains is required", i+1)
+		}
+		if r.TTL < 0 {
+			return fmt.Errorf("rule %d: ttl must not be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// ttlFor returns how long responses from domain are kept; zero means they
+// are not cached.
+func (c *CacheConfig) ttlFor(domain string) time.Duration {
+	if c == nil {
+		return 0
+	}
+	for _, r := range c.Rules {
+		if matchAny(r.Domains, domain, true) {
+			return r.TTL
+		}
+	}
+	return c.TTL
+}
+
+// ResponseCache keeps redacted responses to GET calls in memory and, when
+// given a directory, on disk encrypted with its key. Entries are found by an
+// HMAC of the request, so neither URLs nor secret values appear in file names.
+type ResponseCache struct {
+	Config *CacheConfig
+
+	dir string
+	key []byte // AES-256 key for entries on disk, and HMAC key for names and states
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	StatusCode int                 `json:"status_code"`
+	Headers    map[string][]string `json:"headers"`
+	Body       []byte              `json:"body"`
+	Redacted   bool                `json:"redacted"`
+	StoredAt   time.Time           `json:"stored_at"`
+	Expires    time.Time           `json:"expires"`
+	State      string              `json:"state"` // HMAC of the allowlist and secret values the call was made with
+}
+
+// DefaultCacheDir returns ~/.agentsecrets/cache/<projectID>
+func DefaultCacheDir(projectID string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".agentsecrets", "cache", projectID), nil
+}
+
+// NewResponseCache creates a cache for config. With an empty dir entries
+// live in memory only, and a nil key is replaced by a random one; entries
+// persisted in dir can only be read back with the same key.
+func NewResponseCache(config *CacheConfig, dir string, key []byte) (*ResponseCache, error) {
+	if key == nil {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generate cache key: %w", err)
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("cache key must be 32 bytes, got %d", len(key))
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("cannot create cache directory: %w", err)
+		}
+	}
+	return &ResponseCache{Config: config, dir: dir, key: key, entries: make(map[string]*cacheEntry)}, nil
+}
+
+// newProjectCache creates the cache the cache files ask for, or nil when
+// there are none. Persisted entries are encrypted with a key kept in the
+// keyring, made on first use.
+func newProjectCache(projectPath, projectID string) (*ResponseCache, error) {
+	config, err := loadCacheConfigs(projectPath)
+	if err != nil || config == nil {
+		return nil, err
+	}
+	if !config.Persist {
+		return NewResponseCache(config, "", nil)
+	}
+
+	dir, err := DefaultCacheDir(projectID)
+	if err != nil {
+		return nil, err
+	}
+	key, err := keyring.GetResponseCacheKey(projectID)
+	if err != nil || len(key) != 32 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("generate cache key: %w", err)
+		}
+		if err := keyring.SetResponseCacheKey(projectID, key); err != nil {
+			return nil, err
+		}
+	}
+	return NewResponseCache(config, dir, key)
+}
+
+func (c *ResponseCache) mac(parts ...string) string {
+	m := hmac.New(sha256.New, c.key)
+	for _, p := range parts {
+		m.Write([]byte(p))
+		m.Write([]byte{0})
+	}
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func (c *ResponseCache) path(name string) string {
+	return filepath.Join(c.dir, name+".json")
+}
+
+// get returns the live entry stored under name. An entry made under a
+// different state means the allowlist or a secret changed since, and clears
+// the whole cache.
+func (c *ResponseCache) get(name, state string, now time.Time) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := c.entries[name]
+	if entry == nil && c.dir != "" {
+		entry = c.load(name)
+	}
+	switch {
+	case entry == nil:
+		return nil
+	case entry.State != state:
+		c.clear()
+		return nil
+	case !now.Before(entry.Expires):
+		c.remove(name)
+		return nil
+	}
+	c.entries[name] = entry
+	return entry
+}
+
+// put stores entry under name, evicting the entry closest to expiry when the
+// cache is full.
+func (c *ResponseCache) put(name string, entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	limit := DefaultCacheEntries
+	if c.Config != nil && c.Config.MaxEntries > 0 {
+		limit = c.Config.MaxEntries
+	}
+	for n, e := range c.entries {
+		if !entry.StoredAt.Before(e.Expires) {
+			c.remove(n)
+		}
+	}
+	if _, ok := c.entries[name]; !ok && len(c.entries) >= limit {
+		var oldest string
+		for n, e := range c.entries {
+			if oldest == "" || e.Expires.Before(c.entries[oldest].Expires) {
+				oldest = n
+			}
+		}
+		c.remove(oldest)
+	}
+	c.entries[name] = entry
+
+	if c.dir != "" {
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return
+		}
+		sealed, err := crypto.EncryptSecret(string(data), c.key)
+		if err != nil {
+			return
+		}
+		_ = writeJSONAtomic(c.dir, c.path(name), sealed)
+	}
+}
+
+func (c *ResponseCache) load(name string) *cacheEntry {
+	data, err := os.ReadFile(c.path(name))
+	if err != nil {
+		return nil
+	}
+	var sealed string
+	if json.Unmarshal(data, &sealed) != nil {
+		return nil
+	}
+	plain, err := crypto.DecryptSecret(sealed, c.key)
+	if err != nil {
+		return nil // written under another key; overwritten on the next put
+	}
+	var entry cacheEntry
+	if json.Unmarshal([]byte(plain), &entry) != nil {
+		return nil
+	}
+	return &entry
+}
+
+func (c *ResponseCache) remove(name string) {
+	delete(c.entries, name)
+	if c.dir != "" && validHexID(name) {
+		_ = os.Remove(c.path(name))
+	}
+}
+
+// Clear drops every entry, in memory and on disk.
+func (c *ResponseCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clear()
+}
+
+func (c *ResponseCache) clear() {
+	c.entries = make(map[string]*cacheEntry)
+	if c.dir == "" {
+		return
+	}
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") {
+			_ = os.Remove(filepath.Join(c.dir, f.Name()))
+		}
+	}
+}
+
+// cacheCall is how one call uses the cache.
+type cacheCall struct {
+	name   string
+	state  string
+	ttl    time.Duration
+	lookup bool // false when the request asked for a fresh response
+	store  bool // false when the request asked not to be stored
+}
+
+// cacheFor returns how call uses the cache, or nil when it does not: only
+// GETs to domains with a TTL are cached, and never dry runs or calls that
+// capture values from the response.
+func (e *Engine) cacheFor(call *checkedCall) *cacheCall {
+	if e.Cache == nil || call.method != "GET" || call.req.DryRun || len(call.req.Captures) > 0 {
+		return nil
+	}
+	ttl := e.Cache.Config.ttlFor(call.domain)
+	if ttl <= 0 {
+		return nil
+	}
+
+	var allowlist []string
+	if !e.SkipAllowlist {
+		var err error
+		if allowlist, err = call.req.batch.allowlist(e.WorkspaceID); err != nil {
+			return nil
+		}
+		allowlist = slices.Clone(allowlist)
+		for i := range allowlist {
+			allowlist[i] = strings.ToLower(allowlist[i])
+		}
+		slices.Sort(allowlist)
+	}
+	state := e.Cache.mac(append([]string{strings.Join(allowlist, ",")}, call.secretValues...)...)
+
+	// Everything the upstream could answer differently for, minus secret values
+	parts := []string{call.method, call.url.String()}
+	names := make([]string, 0, len(call.req.Headers))
+	for k := range call.req.Headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+	for _, k := range names {
+		parts = append(parts, strings.ToLower(k)+": "+strings.Join(call.req.Headers[k], ", "))
+	}
+	for _, inj := range call.req.Injections {
+		parts = append(parts, "inject "+inj.Style+" "+inj.Target+" "+inj.SecretKey)
+	}
+
+	cc := cacheControl(call.req.Headers)
+	_, noCache := cc["no-cache"]
+	_, noStore := cc["no-store"]
+	return &cacheCall{
+		name:   e.Cache.mac(parts...),
+		state:  state,
+		ttl:    ttl,
+		lookup: !noCache && !noStore && cc["max-age"] != "0",
+		store:  !noStore,
+	}
+}
+
+// cached answers call from entry, audited as CACHED.
+func (e *Engine) cached(call *checkedCall, entry *cacheEntry, now time.Time) *CallResult {
+	if e.Audit != nil {
+		_ = e.Audit.Log(AuditEvent{
+			Timestamp:  now.UTC(),
+			SecretKeys: call.secretKeys,
+			AgentID:    call.req.AgentID,
+			Method:     call.method,
+			TargetURL:  call.req.TargetURL,
+			Domain:     call.domain,
+			AuthStyles: call.authStyles,
+			StatusCode: entry.StatusCode,
+			Status:     "CACHED",
+			Reason:     "-",
+			Redacted:   entry.Redacted,
+			ApprovalID: call.approvalID,
+		})
+	}
+
+	headers := make(map[string][]string, len(entry.Headers)+1)
+	for k, v := range entry.Headers {
+		headers[k] = slices.Clone(v)
+	}
+	headers["Age"] = []string{strconv.Itoa(int(now.Sub(entry.StoredAt).Seconds()))}
+	return &CallResult{
+		StatusCode: entry.StatusCode,
+		Headers:    headers,
+		Body:       bytes.Clone(entry.Body),
+		Redacted:   entry.Redacted,
+		Cached:     true,
+	}
+}
+
+// store keeps a redacted 200 response for as long as both the TTL and the
+// upstream's Cache-Control allow.
+func (e *Engine) store(cc *cacheCall, result *ForwardResult, redacted bool, now time.Time) {
+	if !cc.store || result.StatusCode != 200 || len(result.Headers["Set-Cookie"]) > 0 || firstHeader(result.Headers, "Vary") == "*" {
+		return
+	}
+	ttl := cc.ttl
+	directives := cacheControl(result.Headers)
+	if _, ok := directives["no-store"]; ok {
+		return
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return
+	}
+	if v, ok := directives["max-age"]; ok {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return
+		}
+		ttl = min(ttl, time.Duration(secs)*time.Second)
+	}
+
+	headers := make(map[string][]string, len(result.Headers))
+	for k, v := range result.Headers {
+		headers[k] = slices.Clone(v)
+	}
+	e.Cache.put(cc.name, &cacheEntry{
+		StatusCode: result.StatusCode,
+		Headers:    headers,
+		Body:       bytes.Clone(result.Body),
+		Redacted:   redacted,
+		StoredAt:   now,
+		Expires:    now.Add(ttl),
+		State:      cc.state,
+	})
+}
+
+// cacheControl parses the Cache-Control directives in h, with names
+// lowercased and values unquoted.
+func cacheControl(h map[string][]string) map[string]string {
+	directives := make(map[string]string)
+	for k, vals := range h {
+		if !strings.EqualFold(k, "Cache-Control") {
+			continue
+		}
+		for _, v := range vals {
+			for _, d := range strings.Split(v, ",") {
+				name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+				if name != "" {
+					directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+				}
+			}
+		}
+	}
+	return directives
+}
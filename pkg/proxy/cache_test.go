@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheUpstream counts the calls it receives and echoes the credential back,
+// with the Cache-Control the test sets.
+func cacheUpstream(t *testing.T, cacheControl *string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		if *cacheControl != "" {
+			w.Header().Set("Cache-Control", *cacheControl)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"call":` + string(rune('0'+n)) + `,"token":"` + strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") + `"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &calls
+}
+
+func cachedGet(t *testing.T, engine *Engine, target string, headers map[string][]string) *CallResult {
+	t.Helper()
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  target,
+		Headers:    headers,
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestExecuteCachesGET(t *testing.T) {
+	noHeader := ""
+	upstream, calls := cacheUpstream(t, &noHeader)
+	engine, logPath := redirectEngine(t)
+	cache, err := NewResponseCache(&CacheConfig{TTL: time.Minute}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine.Cache = cache
+
+	first := cachedGet(t, engine, upstream.URL+"/repos", nil)
+	second := cachedGet(t, engine, upstream.URL+"/repos", nil)
+	if calls.Load() != 1 {
+		t.Fatalf("upstream called %d times, want 1", calls.Load())
+	}
+	if first.Cached || !second.Cached || string(second.Body) != string(first.Body) {
+		t.Fatalf("first cached=%v, second cached=%v %q", first.Cached, second.Cached, second.Body)
+	}
+	if !second.Redacted || strings.Contains(string(second.Body), "sk_test_123") {
+		t.Errorf("cached body must be redacted: %q", second.Body)
+	}
+	if len(second.Headers["Age"]) != 1 {
+		t.Errorf("Age header = %v", second.Headers["Age"])
+	}
+	if event := lastAuditEvent(t, logPath); event.Status != "CACHED" || event.StatusCode != 200 {
+		t.Errorf("audit event = %+v", event)
+	}
+
+	// A different URL or header is a different entry
+	cachedGet(t, engine, upstream.URL+"/repos?page=2", nil)
+	cachedGet(t, engine, upstream.URL+"/repos", map[string][]string{"Accept": {"text/plain"}})
+	if calls.Load() != 3 {
+		t.Errorf("upstream called %d times, want 3", calls.Load())
+	}
+
+	// The agent can ask for a fresh response
+	if r := cachedGet(t, engine, upstream.URL+"/repos", map[string][]string{"Cache-Control": {"no-cache"}}); r.Cached {
+		t.Error("no-cache request was answered from the cache")
+	}
+
+	// A rotated secret clears the cache
+	engine.ResolveSecret = mockResolver(map[string]string{"API_KEY": "sk_test_456"})
+	if r := cachedGet(t, engine, upstream.URL+"/repos", nil); r.Cached {
+		t.Error("response cached under the old secret was served")
+	}
+}
+
+func TestCacheHonorsCacheControl(t *testing.T) {
+	cacheControl := "no-store"
+	upstream, calls := cacheUpstream(t, &cacheControl)
+	engine, _ := redirectEngine(t)
+	engine.Cache, _ = NewResponseCache(&CacheConfig{
+		TTL:   time.Minute,
+		Rules: []CacheRule{{Domains: []string{"never.example.com"}, TTL: 0}},
+	}, "", nil)
+
+	cachedGet(t, engine, upstream.URL, nil)
+	cachedGet(t, engine, upstream.URL, nil)
+	if calls.Load() != 2 {
+		t.Errorf("no-store response was cached")
+	}
+
+	cacheControl = "max-age=0"
+	cachedGet(t, engine, upstream.URL+"/a", nil)
+	cachedGet(t, engine, upstream.URL+"/a", nil)
+	if calls.Load() != 4 {
+		t.Errorf("max-age=0 response was cached")
+	}
+
+	// max-age shortens the TTL
+	cc := &cacheCall{name: "ab", ttl: time.Minute, store: true}
+	now := time.Now()
+	engine.store(cc, &ForwardResult{StatusCode: 200, Headers: map[string][]string{"Cache-Control": {"public, max-age=5"}}}, false, now)
+	if entry := engine.Cache.get("ab", "", now.Add(6*time.Second)); entry != nil {
+		t.Errorf("entry outlived max-age: %+v", entry)
+	}
+
+	if ttl := engine.Cache.Config.ttlFor("never.example.com"); ttl != 0 {
+		t.Errorf("rule ttl = %s, want 0", ttl)
+	}
+}
+
+func TestCachePersistsEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	key := []byte(strings.Repeat("k", 32))
+	config := &CacheConfig{TTL: time.Minute, Persist: true}
+	now := time.Now()
+
+	c, err := NewResponseCache(config, dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.put("abcd", &cacheEntry{StatusCode: 200, Body: []byte(`{"repos":["agentsecrets"]}`), StoredAt: now, Expires: now.Add(time.Minute), State: "s1"})
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != 1 {
+		t.Fatalf("files on disk = %v", files)
+	}
+	data, _ := os.ReadFile(files[0])
+	if strings.Contains(string(data), "agentsecrets") {
+		t.Errorf("cache file is not encrypted: %s", data)
+	}
+
+	// A restarted proxy with the same key reads it back
+	restarted, _ := NewResponseCache(config, dir, key)
+	if entry := restarted.get("abcd", "s1", now); entry == nil || string(entry.Body) != `{"repos":["agentsecrets"]}` {
+		t.Fatalf("entry after restart = %+v", entry)
+	}
+
+	// A changed allowlist or secret clears every entry
+	if entry := restarted.get("abcd", "s2", now); entry != nil {
+		t.Error("entry served under a new state")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 {
+		t.Errorf("files left after clear: %v", files)
+	}
+
+	// Another key cannot read entries
+	c.put("abcd", &cacheEntry{StatusCode: 200, StoredAt: now, Expires: now.Add(time.Minute), State: "s1"})
+	other, _ := NewResponseCache(config, dir, []byte(strings.Repeat("x", 32)))
+	if entry := other.get("abcd", "s1", now); entry != nil {
+		t.Error("entry decrypted with the wrong key")
+	}
+}
+
+func TestLoadCacheConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+	os.WriteFile(path, []byte("ttl: 30s\nrules:\n  - domains: [api.github.com]\n    ttl: 5m\n"), 0600)
+	c, err := LoadCacheConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ttlFor("api.github.com") != 5*time.Minute || c.ttlFor("api.stripe.com") != 30*time.Second {
+		t.Errorf("config = %+v", c)
+	}
+
+	os.WriteFile(path, []byte("rules:\n  - ttl: 5m\n"), 0600)
+	if _, err := LoadCacheConfig(path); err == nil {
+		t.Error("expected a rule without domains to be invalid")
+	}
+	if c, err := LoadCacheConfig(filepath.Join(t.TempDir(), "missing.yaml")); c != nil || err != nil {
+		t.Errorf("missing file = %v, %v", c, err)
+	}
+}
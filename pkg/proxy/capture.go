@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Capture describes a value to lift out of a JSON response and store in the keyring.
+// The value is replaced in the response body with a placeholder, so the agent only
+// ever learns the key name it was stored under.
+type Capture struct {
+	Path      string // JSON path into the response body e.g. "$.access_token"
+	SecretKey string // keyring key name to store the value under e.g. "VENDOR_SESSION"
+}
+
+// SecretStore is a function that persists a secret value under a key name.
+// This allows the engine to be tested without touching the real keyring.
+type SecretStore func(key, value string) error
+
+// captureKeys returns the key names captures store into.
+func captureKeys(captures []Capture) []string {
+	var keys []string
+	for _, c := range captures {
+		keys = append(keys, c.SecretKey)
+	}
+	return keys
+}
+
+// captureWouldOverwrite reports whether storing under key would replace a
+// secret that no capture stored, e.g. one set by hand or pulled from the
+// cloud, whose value an agent must not be able to choose.
+func (e *Engine) captureWouldOverwrite(key string) bool {
+	if e.CapturedSecret != nil {
+		exists, captured := e.CapturedSecret(key)
+		return exists && !captured
+	}
+	if e.ResolveSecret == nil {
+		return false
+	}
+	_, err := e.ResolveSecret(key)
+	return err == nil
+}
+
+// capturedPlaceholder returns the marker that replaces a captured value in the body.
+func capturedPlaceholder(secretKey string) string {
+	return fmt.Sprintf("[CAPTURED_BY_AGENTSECRETS:%s]", secretKey)
+}
+
+// applyCaptures extracts each capture path from a JSON body, stores the value through
+// store, and returns the body with every captured value replaced by its placeholder.
+// It returns the key names that were stored. Every value is extracted before any is
+// stored, so a path that is missing or not a string is reported in the error and
+// nothing is stored for any capture of the call.
+func applyCaptures(body []byte, captures []Capture, store SecretStore) ([]byte, []string, error) {
+	if len(captures) == 0 {
+		return body, nil, nil
+	}
+	if store == nil {
+		return body, nil, fmt.Errorf("capture is not supported by this engine")
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return body, nil, fmt.Errorf("response is not valid JSON: %w", err)
+	}
+
+	values := make([]string, len(captures))
+	for i, c := range captures {
+		if c.SecretKey == "" {
+			return body, nil, fmt.Errorf("capture %q has no secret key name", c.Path)
+		}
+		segments, err := parseJSONPath(c.Path)
+		if err != nil {
+			return body, nil, err
+		}
+		raw, ok := lookupJSONPath(doc, segments)
+		if !ok {
+			return body, nil, fmt.Errorf("capture path %s not found in response", c.Path)
+		}
+
+		value, ok := raw.(string)
+		if !ok {
+			return body, nil, fmt.Errorf("capture path %s is not a string", c.Path)
+		}
+		if value == "" {
+			return body, nil, fmt.Errorf("capture path %s is empty", c.Path)
+		}
+		values[i] = value
+	}
+
+	var stored []string
+	for i, c := range captures {
+		value := values[i]
+		if err := store(c.SecretKey, value); err != nil {
+			return body, stored, fmt.Errorf("failed to store %s: %w", c.SecretKey, err)
+		}
+		stored = append(stored, c.SecretKey)
+
+		// Replace the JSON-encoded form first so escaped values are caught,
+		// then any raw occurrences elsewhere in the body.
+		placeholder := capturedPlaceholder(c.SecretKey)
+		encodedVal, _ := json.Marshal(value)
+		encodedPlaceholder, _ := json.Marshal(placeholder)
+		body = bytes.ReplaceAll(body, encodedVal, encodedPlaceholder)
+		body = bytes.ReplaceAll(body, []byte(value), []byte(placeholder))
+	}
+
+	return body, stored, nil
+}
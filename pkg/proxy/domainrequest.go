@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// DomainRequestTTL is how long a domain request is kept, decided or not.
+// Older requests are removed when the next one is opened.
+const DomainRequestTTL = 7 * 24 * time.Hour
+
+// DomainRequest asks a workspace admin to add a domain to the allowlist.
+// The engine opens one when it blocks a call with domain_not_in_allowlist;
+// the agent adds a justification through the request_domain_access tool.
+type DomainRequest struct {
+	ID            string    `json:"id"`
+	WorkspaceID   string    `json:"workspace_id"`
+	ProjectID     string    `json:"project_id,omitempty"`
+	Domain        string    `json:"domain"`
+	AgentID       string    `json:"agent_id,omitempty"`
+	Method        string    `json:"method"`
+	TargetURL     string    `json:"target_url"`
+	SecretKeys    []string  `json:"secret_keys"`
+	Justification string    `json:"justification,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+	Status        string    `json:"status"` // ApprovalPending, ApprovalApproved or ApprovalDenied
+	DecidedAt     time.Time `json:"decided_at,omitempty"`
+}
+
+// DomainRequestStore keeps allowlist requests as files, one per request, so the
+// process that blocked the call and `agentsecrets workspace allowlist approve`
+// can be different processes.
+type DomainRequestStore struct {
+	Dir string
+}
+
+// DefaultDomainRequestDir returns ~/.agentsecrets/domain-requests
+func DefaultDomainRequestDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".agentsecrets", "domain-requests"), nil
+}
+
+// NewDomainRequestStore opens the store in dir, or the default directory if dir is empty.
+func NewDomainRequestStore(dir string) (*DomainRequestStore, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultDomainRequestDir(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create domain requests directory: %w", err)
+	}
+	return &DomainRequestStore{Dir: dir}, nil
+}
+
+// Open records a request for r.Domain and assigns its ID. If the workspace
+// already has a pending request for the domain, that request is returned
+// instead, so an agent retrying a blocked call does not flood the queue.
+func (s *DomainRequestStore) Open(r *DomainRequest) (*DomainRequest, error) {
+	s.prune()
+	r.Domain = strings.ToLower(r.Domain)
+	r.clean()
+	pending, err := s.Pending(r.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pending {
+		if p.Domain == r.Domain {
+			return p, nil
+		}
+	}
+
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("generate request id: %w", err)
+	}
+	r.ID = hex.EncodeToString(buf)
+	r.Status = ApprovalPending
+	r.CreatedAt = time.Now().UTC()
+	return r, s.save(r)
+}
+
+// Get returns the request with the given ID. Requests older than
+// DomainRequestTTL are treated as gone.
+func (s *DomainRequestStore) Get(id string) (*DomainRequest, error) {
+	if !validHexID(id) {
+		return nil, fmt.Errorf("invalid request id %q", id)
+	}
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no domain request %s", id)
+		}
+		return nil, err
+	}
+	var r DomainRequest
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse domain request %s: %w", id, err)
+	}
+	if time.Since(r.CreatedAt) > DomainRequestTTL {
+		return nil, fmt.Errorf("domain request %s expired", id)
+	}
+	r.clean() // the file may have been written by anyone
+	return &r, nil
+}
+
+// Justify records why the agent needs the domain. Only pending requests can
+// be justified; a later justification replaces an earlier one.
+func (s *DomainRequestStore) Justify(id, justification string) (*DomainRequest, error) {
+	r, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != ApprovalPending {
+		return nil, fmt.Errorf("domain request %s was already %s", id, r.Status)
+	}
+	r.Justification = strings.TrimSpace(stripControl(justification))
+	return r, s.save(r)
+}
+
+// Pending lists requests waiting for a decision in workspaceID, oldest first.
+// An empty workspaceID lists every workspace.
+func (s *DomainRequestStore) Pending(workspaceID string) ([]*DomainRequest, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var pending []*DomainRequest
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		r, err := s.Get(id)
+		if err != nil || r.Status != ApprovalPending {
+			continue
+		}
+		if workspaceID != "" && r.WorkspaceID != workspaceID {
+			continue
+		}
+		pending = append(pending, r)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].CreatedAt.Before(pending[j].CreatedAt) })
+	return pending, nil
+}
+
+// Decide approves or rejects a pending request. It only records the decision;
+// the caller adds approved domains to the allowlist.
+func (s *DomainRequestStore) Decide(id string, approve bool) (*DomainRequest, error) {
+	r, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if r.Status != ApprovalPending {
+		return nil, fmt.Errorf("domain request %s was already %s", id, r.Status)
+	}
+	r.Status = ApprovalDenied
+	if approve {
+		r.Status = ApprovalApproved
+	}
+	r.DecidedAt = time.Now().UTC()
+	return r, s.save(r)
+}
+
+// clean strips control and formatting characters from the fields an agent
+// supplies, so printing a request cannot send escape sequences to the
+// admin's terminal.
+func (r *DomainRequest) clean() {
+	r.Domain = stripControl(r.Domain)
+	r.AgentID = stripControl(r.AgentID)
+	r.Method = stripControl(r.Method)
+	r.TargetURL = stripControl(r.TargetURL)
+	r.Justification = stripControl(r.Justification)
+	for i, key := range r.SecretKeys {
+		r.SecretKeys[i] = stripControl(key)
+	}
+}
+
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// prune removes requests last written more than DomainRequestTTL ago.
+func (s *DomainRequestStore) prune() {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err == nil && strings.HasSuffix(e.Name(), ".json") && time.Since(info.ModTime()) > DomainRequestTTL {
+			os.Remove(filepath.Join(s.Dir, e.Name()))
+		}
+	}
+}
+
+func (s *DomainRequestStore) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+func (s *DomainRequestStore) save(r *DomainRequest) error {
+	if err := writeJSONAtomic(s.Dir, s.path(r.ID), r); err != nil {
+		return fmt.Errorf("save domain request: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDomainRequestStoreLifecycle(t *testing.T) {
+	store, err := NewDomainRequestStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := store.Open(&DomainRequest{
+		WorkspaceID: "ws-1",
+		Domain:      "API.Vendor.com",
+		Method:      "GET",
+		TargetURL:   "https://api.vendor.com/v1/items",
+		SecretKeys:  []string{"VENDOR_KEY"},
+	})
+	if err != nil {
+		t.Fatalf("Open() error: %v", err)
+	}
+	if first.ID == "" || first.Domain != "api.vendor.com" {
+		t.Fatalf("got id=%q domain=%q", first.ID, first.Domain)
+	}
+
+	// A retried call reuses the pending request; another workspace gets its own
+	again, _ := store.Open(&DomainRequest{WorkspaceID: "ws-1", Domain: "api.vendor.com"})
+	if again.ID != first.ID {
+		t.Errorf("retry opened %s, want the pending %s", again.ID, first.ID)
+	}
+	other, _ := store.Open(&DomainRequest{WorkspaceID: "ws-2", Domain: "api.vendor.com"})
+	if other.ID == first.ID {
+		t.Error("a different workspace must get its own request")
+	}
+
+	if _, err := store.Justify(first.ID, "  sync the item catalogue  "); err != nil {
+		t.Fatalf("Justify() error: %v", err)
+	}
+	pending, _ := store.Pending("ws-1")
+	if len(pending) != 1 || pending[0].Justification != "sync the item catalogue" {
+		t.Fatalf("Pending(ws-1) = %+v", pending)
+	}
+
+	if _, err := store.Decide(first.ID, true); err != nil {
+		t.Fatalf("Decide() error: %v", err)
+	}
+	if pending, _ := store.Pending("ws-1"); len(pending) != 0 {
+		t.Errorf("expected no pending requests after approval, got %d", len(pending))
+	}
+	if _, err := store.Justify(first.ID, "late"); err == nil {
+		t.Error("expected an error justifying a decided request")
+	}
+	if _, err := store.Decide(first.ID, false); err == nil {
+		t.Error("expected an error deciding a request twice")
+	}
+	if _, err := store.Get("../etc/passwd"); err == nil {
+		t.Error("expected an error for a non-hex request id")
+	}
+}
+
+func TestDomainRequestStoreExpiry(t *testing.T) {
+	store, err := NewDomainRequestStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := store.Open(&DomainRequest{WorkspaceID: "ws-1", Domain: "api.old.com"})
+	old.CreatedAt = time.Now().Add(-DomainRequestTTL - time.Hour)
+	store.save(old)
+
+	if _, err := store.Get(old.ID); err == nil {
+		t.Error("an expired request is still returned")
+	}
+	if pending, _ := store.Pending("ws-1"); len(pending) != 0 {
+		t.Errorf("expected no pending requests, got %d", len(pending))
+	}
+	again, _ := store.Open(&DomainRequest{WorkspaceID: "ws-1", Domain: "api.old.com"})
+	if again.ID == old.ID {
+		t.Error("a blocked call reused an expired request")
+	}
+
+	stale := time.Now().Add(-DomainRequestTTL - time.Hour)
+	os.Chtimes(store.path(old.ID), stale, stale)
+	store.Open(&DomainRequest{WorkspaceID: "ws-1", Domain: "api.new.com"})
+	if _, err := os.Stat(store.path(old.ID)); !os.IsNotExist(err) {
+		t.Error("the expired request file was not pruned")
+	}
+}
+
+func TestDomainRequestStripsControlCharacters(t *testing.T) {
+	store, err := NewDomainRequestStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := store.Open(&DomainRequest{
+		WorkspaceID: "ws-1",
+		Domain:      "api.vendor.com",
+		Method:      "GET",
+		TargetURL:   "https://api.vendor.com/\x1b[2J\x1b]0;owned\x07",
+	})
+	r, _ = store.Justify(r.ID, "needed\x1b[1A\x1b[2K\r\u202eapproved by admin")
+
+	// A request file the agent wrote itself is cleaned when it is read
+	r.AgentID = "agent\x1b[31m"
+	store.save(r)
+
+	got, err := store.Get(r.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{got.TargetURL, got.Justification, got.AgentID} {
+		if strings.ContainsAny(field, "\x1b\x07\r\u202e") {
+			t.Errorf("control characters kept in %q", field)
+		}
+	}
+	if got.Justification != "needed[1A[2Kapproved by admin" {
+		t.Errorf("Justification = %q", got.Justification)
+	}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// proxyDirect disables proxying for the matching domains.
+const proxyDirect = "direct"
+
+func validateProxy(proxy, user, password string) error {
+	if proxy == "" {
+		if user != "" || password != "" {
+			return fmt.Errorf("proxy_user and proxy_password need a proxy")
+		}
+		return nil
+	}
+	if proxy == proxyDirect {
+		return nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("proxy %q is not a URL", proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("proxy %q must use http, https, socks5 or socks5h", proxy)
+	}
+	if u.User != nil {
+		return fmt.Errorf("proxy %q: put credentials in the keyring and reference them with proxy_user and proxy_password", u.Redacted())
+	}
+	if password != "" && user == "" {
+		return fmt.Errorf("proxy_password needs a proxy_user")
+	}
+	return nil
+}
+
+// egressFor returns the proxy settings for host. An empty proxy means the
+// environment decides.
+func (c *UpstreamConfig) egressFor(host string) (proxy, user, password string) {
+	if c == nil {
+		return "", "", ""
+	}
+	for _, r := range c.Egress {
+		if r.Proxy != "" && matchAny(r.Domains, host, true) {
+			return r.Proxy, r.ProxyUser, r.ProxyPassword
+		}
+	}
+	if matchAny(c.NoProxy, host, true) {
+		return proxyDirect, "", ""
+	}
+	return c.Proxy, c.ProxyUser, c.ProxyPassword
+}
+
+// timeoutFor returns the request timeout for host: the first egress rule with
+// a timeout, else the config's, else DefaultTimeout.
+func (c *UpstreamConfig) timeoutFor(host string) time.Duration {
+	if c == nil {
+		return DefaultTimeout
+	}
+	for _, r := range c.Egress {
+		if r.Timeout > 0 && matchAny(r.Domains, host, true) {
+			return r.Timeout
+		}
+	}
+	if c.Timeout > 0 {
+		return c.Timeout
+	}
+	return DefaultTimeout
+}
+
+// egressProxyKey carries the proxy route chose in the request's context.
+type egressProxyKey struct{}
+
+// route chooses the egress proxy for req and returns req carrying it. A proxy
+// resolves the target itself, so the target's addresses are checked here but
+// cannot be pinned; the proxy's own address is trusted for the connection to
+// the proxy, since the user configured it.
+func (g *dialGuard) route(req *http.Request) (*http.Request, error) {
+	proxyURL, err := g.proxyURL(req)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return req, nil
+	}
+	if _, err := g.resolve(req.Context(), req.URL.Hostname()); err != nil {
+		// Hosts only the proxy can resolve (e.g. behind a partner VPN) are fine;
+		// hosts that resolve to a blocked address are not
+		var ssrf *SSRFError
+		if errors.As(err, &ssrf) {
+			return nil, err
+		}
+	}
+	return req.WithContext(context.WithValue(req.Context(), egressProxyKey{}, proxyURL)), nil
+}
+
+// routedProxy is the transport's Proxy func: the proxy route chose, if any.
+func routedProxy(req *http.Request) (*url.URL, error) {
+	proxyURL, _ := req.Context().Value(egressProxyKey{}).(*url.URL)
+	return proxyURL, nil
+}
+
+func (g *dialGuard) proxyURL(req *http.Request) (*url.URL, error) {
+	raw, user, password := g.upstream.egressFor(strings.ToLower(req.URL.Hostname()))
+	switch raw {
+	case "":
+		return http.ProxyFromEnvironment(req)
+	case proxyDirect:
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", raw, err)
+	}
+	if user != "" {
+		if g.secret == nil {
+			return nil, fmt.Errorf("proxy credentials for %s need the keyring", proxyURL.Host)
+		}
+		name, err := g.secret(user)
+		if err != nil {
+			return nil, fmt.Errorf("proxy user secret '%s' not found in keychain", user)
+		}
+		if password == "" {
+			proxyURL.User = url.User(name)
+		} else {
+			pass, err := g.secret(password)
+			if err != nil {
+				return nil, fmt.Errorf("proxy password secret '%s' not found in keychain", password)
+			}
+			proxyURL.User = url.UserPassword(name, pass)
+		}
+	}
+	return proxyURL, nil
+}
@@ -2,11 +2,15 @@ package proxy
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/The-17/agentsecrets/pkg/config"
@@ -17,17 +21,26 @@ func redactSecretFromResponse(body []byte, secretValue string) []byte {
 	if secretValue == "" {
 		return body
 	}
-	return bytes.ReplaceAll(body, []byte(secretValue), []byte("[REDACTED_BY_AGENTSECRETS]"))
+	return bytes.ReplaceAll(body, []byte(secretValue), []byte(RedactedPlaceholder))
 }
 
 // CallRequest is the input to the engine — used by both MCP and HTTP paths.
 type CallRequest struct {
-	TargetURL  string            // full URL e.g. https://api.stripe.com/v1/charges
-	Method     string            // GET, POST, PUT, PATCH, DELETE
-	Headers    map[string]string // extra headers to forward (non-auth)
-	Body       []byte            // raw request body (optional)
-	Injections []Injection       // what to inject and where
-	AgentID    string            // optional, for audit logging
+	TargetURL  string               // full URL e.g. https://api.stripe.com/v1/charges
+	Method     string               // GET, POST, PUT, PATCH, DELETE
+	Headers    map[string][]string  // extra headers to forward (non-auth)
+	Body       []byte               // raw request body (optional)
+	Injections []Injection          // what to inject and where
+	Captures   []Capture            // response values to store in the keyring (optional)
+	AgentID    string               // optional, for audit logging and policy
+	AgentToken string               // optional proxy token proving the agent identity
+	Progress   func(message string) // optional, told while the call is held for approval
+	DryRun     bool                 // run every check and build the request, but don't send it
+	Select     string               // optional JSON projection of the response, see selectJSON
+	MaxBytes   int                  // optional cap on the response body; the rest is kept for Cursor
+	Cursor     string               // continue a truncated response instead of making a call
+
+	batch *batchState // shared keyring reads when run by ExecuteBatch
 }
 
 // Injection describes one credential to inject.
@@ -42,6 +55,12 @@ type CallResult struct {
 	StatusCode int
 	Headers    map[string][]string
 	Body       []byte
+	Captured   []string     // KEY NAMES stored from the response by Captures
+	Redacted   bool         // a secret value was removed from the response
+	Blocked    string       // the block reason when the engine refused the call
+	Explain    *Explanation // set for dry runs
+	Shaping    *Shaping     // set when Select or MaxBytes applied
+	Cached     bool         // answered from the response cache
 }
 
 // SecretResolver is a function that retrieves a secret value by key name.
@@ -55,7 +74,42 @@ type Engine struct {
 	Audit         *AuditLogger
 	Client        *http.Client
 	ResolveSecret SecretResolver
+	StoreSecret   SecretStore
 	SkipAllowlist bool
+	Policy        *Policy         // per-agent grants; nil means no policy
+	Upstream      *UpstreamConfig // how to reach upstreams; nil means defaults
+
+	// CapturedSecret reports whether key holds a secret and whether a capture
+	// stored it. Captures may only create keys or replace captured ones; when
+	// it is nil, every key ResolveSecret finds counts as not captured.
+	CapturedSecret func(key string) (exists, captured bool)
+
+	// Approvals holds requests a policy rule marks for human approval;
+	// such requests are denied when it is nil.
+	Approvals       *ApprovalQueue
+	ApprovalTimeout time.Duration // DefaultApprovalTimeout if zero
+
+	// DomainRequests records allowlist requests for blocked domains; when it
+	// is nil a blocked call carries no request ID.
+	DomainRequests *DomainRequestStore
+
+	// Responses keeps bodies cut short by MaxBytes for cursors; when it is
+	// nil they are still cut short but cannot be continued.
+	Responses *ResponseStore
+
+	// Budget prices LLM usage and caps it; nil means built-in prices and no caps.
+	Budget *BudgetConfig
+	ledger usageLedger
+
+	// Cache answers repeated GETs without calling the upstream; nil means
+	// nothing is cached.
+	Cache *ResponseCache
+
+	recorder    *harRecorder // set by Record
+	replayHosts []string     // set by Replay; the allowlist if the keyring has none
+
+	seenMu      sync.Mutex
+	seenDomains map[string]bool // domains with a successful call, from the audit log
 }
 
 // NewEngine creates an engine wired to the real keyring for the given project.
@@ -63,39 +117,170 @@ func NewEngine(projectID string) (*Engine, error) {
 	if projectID == "" {
 		return nil, fmt.Errorf("project ID is required — run 'agentsecrets project use <name>' first")
 	}
+	pc, err := config.LoadProjectConfig()
+	if err != nil || pc.WorkspaceID == "" {
+		return nil, fmt.Errorf("project config error, please run 'agentsecrets project use' first")
+	}
+	return newEngine("", projectID, pc.WorkspaceID)
+}
 
+// NewProjectEngine creates an engine for the project rooted at dir, reading
+// its project.json and its policy, upstream, budget and cache files from
+// there rather than from the working directory.
+func NewProjectEngine(dir string) (*Engine, error) {
+	pc, err := config.LoadProjectConfigAt(dir)
+	if err != nil {
+		return nil, err
+	}
+	if pc.ProjectID == "" || pc.WorkspaceID == "" {
+		return nil, fmt.Errorf("%s is not an agentsecrets project — run 'agentsecrets project use' there first", dir)
+	}
+	return newEngine(dir, pc.ProjectID, pc.WorkspaceID)
+}
+
+// newEngine reads the project's files relative to root, the working
+// directory if it is empty.
+func newEngine(root, projectID, workspaceID string) (*Engine, error) {
 	audit, err := NewAuditLogger("")
 	if err != nil {
 		// Audit logger is non-critical — log to stderr but continue
 		audit = nil
+	} else {
+		audit.ProjectID = projectID
 	}
 
-	pc, err := config.LoadProjectConfig()
-	if err != nil || pc.WorkspaceID == "" {
-		return nil, fmt.Errorf("project config error, please run 'agentsecrets project use' first")
+	// A policy that fails to load must not silently grant everything
+	policy, err := LoadPolicy(filepath.Join(root, DefaultPolicyPath))
+	if err != nil {
+		return nil, err
+	}
+
+	upstream, err := loadUpstreamConfigs(filepath.Join(root, DefaultUpstreamPath))
+	if err != nil {
+		return nil, err
+	}
+
+	approvals, err := NewApprovalQueue("")
+	if err != nil {
+		approvals = nil // requests needing approval will be denied
+	} else {
+		approvals.VerifyKey = approvalVerifyKey()
+	}
+
+	domainRequests, err := NewDomainRequestStore("")
+	if err != nil {
+		domainRequests = nil // blocks still work, just without a request ID
+	}
+
+	budget, err := loadBudgetConfigs(filepath.Join(root, DefaultBudgetPath))
+	if err != nil {
+		return nil, err
+	}
+
+	responses, err := NewResponseStore("")
+	if err != nil {
+		responses = nil // responses are truncated without a cursor
+	}
+
+	cache, err := newProjectCache(filepath.Join(root, DefaultCachePath), projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := func(key string) (string, error) {
+		return keyring.GetSecret(projectID, key)
 	}
 
 	return &Engine{
-		ProjectID:     projectID,
-		WorkspaceID:   pc.WorkspaceID,
-		Audit:         audit,
+		ProjectID:   projectID,
+		WorkspaceID: workspaceID,
+		Audit:       audit,
 		Client: &http.Client{
-			Timeout: DefaultTimeout,
+			Timeout:   DefaultTimeout,
+			Transport: newUpstreamTransport(upstream, resolve),
+		},
+		ResolveSecret: resolve,
+		StoreSecret: func(key, value string) error {
+			return keyring.SetCapturedSecret(projectID, key, value)
 		},
-		ResolveSecret: func(key string) (string, error) {
-			return keyring.GetSecret(projectID, key)
+		CapturedSecret: func(key string) (bool, bool) {
+			return keyring.HasSecret(projectID, key), keyring.IsCapturedSecret(projectID, key)
 		},
+		Policy:         policy,
+		Upstream:       upstream,
+		Approvals:      approvals,
+		DomainRequests: domainRequests,
+		Responses:      responses,
+		Budget:         budget,
+		Cache:          cache,
 	}, nil
 }
 
-// Execute runs the full proxy pipeline: resolve secrets → inject → forward → audit.
-func (e *Engine) Execute(req CallRequest) (*CallResult, error) {
+// CheckAllowlist reports whether credentials may be sent to domain.
+// It returns an empty reason when the domain is allowed, otherwise a block
+// reason (e.g. "domain_not_in_allowlist") and a message for the caller.
+func (e *Engine) CheckAllowlist(domain string) (reason, msg string, err error) {
+	return e.checkAllowlist(domain, nil)
+}
+
+// checkAllowlist is CheckAllowlist reading the allowlist through b, if any.
+func (e *Engine) checkAllowlist(domain string, b *batchState) (reason, msg string, err error) {
+	if e.SkipAllowlist {
+		return "", "", nil
+	}
+
+	allowlist, err := b.allowlist(e.WorkspaceID)
+	if err != nil && e.replayHosts != nil {
+		allowlist, err = e.replayHosts, nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read allowlist from keyring: %w", err)
+	}
+
+	if len(allowlist) == 0 {
+		msg := "Your workspace allowlist is empty. No credential injections are allowed until you add at least one domain.\nRun: agentsecrets workspace allowlist add <domain>"
+		return "empty_allowlist", strings.ReplaceAll(msg, "\n", " "), nil
+	}
+
+	domain = strings.ToLower(domain)
+	for _, raw := range allowlist {
+		if strings.ToLower(raw) == domain {
+			return "", "", nil
+		}
+	}
+
+	msg = fmt.Sprintf("%s is not in your workspace allowlist. To authorize it, run: agentsecrets workspace allowlist add %s", domain, domain)
+	return "domain_not_in_allowlist", msg, nil
+}
+
+// checkedCall is a request that passed validation, the allowlist, the policy,
+// LLM budgets and any approval, with its secrets resolved. Execute and DialWebSocket
+// continue from here.
+type checkedCall struct {
+	req          CallRequest
+	method       string
+	url          *url.URL
+	domain       string
+	secretKeys   []string
+	authStyles   []string
+	secretValues []string // in the order of req.Injections
+
+	approvalID      string   // set when the call was held for human approval
+	domainRequestID string   // set when an allowlist request was opened for the domain
+	redirects       []string // redirect hops followed, in order
+
+	steps []ExplainStep // what each check decided, reported by dry runs
+}
+
+// check runs every check that happens before a request leaves the machine.
+// It returns either the checked call, or a 403 result for a blocked request.
+func (e *Engine) check(req CallRequest) (*checkedCall, *CallResult, error) {
 	// --- Validate ---
 	if req.TargetURL == "" {
-		return nil, fmt.Errorf("target URL is required")
+		return nil, nil, fmt.Errorf("target URL is required")
 	}
 	if len(req.Injections) == 0 {
-		return nil, fmt.Errorf("at least one injection is required — specify how to authenticate (e.g. bearer, header, query)")
+		return nil, nil, fmt.Errorf("at least one injection is required — specify how to authenticate (e.g. bearer, header, query)")
 	}
 
 	method := strings.ToUpper(req.Method)
@@ -106,116 +291,327 @@ func (e *Engine) Execute(req CallRequest) (*CallResult, error) {
 	// --- Check Allowlist ---
 	u, err := url.Parse(req.TargetURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid target URL: %w", err)
+		return nil, nil, fmt.Errorf("invalid target URL: %w", err)
+	}
+	call := &checkedCall{
+		req:    req,
+		method: method,
+		url:    u,
+		domain: strings.ToLower(u.Hostname()),
+	}
+	for _, inj := range req.Injections {
+		call.secretKeys = append(call.secretKeys, inj.SecretKey)
+		call.authStyles = append(call.authStyles, inj.Style)
 	}
-	targetDomain := strings.ToLower(u.Hostname())
 
-	var allowlist []string
-	if !e.SkipAllowlist {
-		allowlist, err = keyring.GetWorkspaceAllowlist(e.WorkspaceID)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read allowlist from keyring: %w", err)
+	reason, msg, err := e.checkAllowlist(call.domain, req.batch)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case e.SkipAllowlist:
+		call.explain("allowlist", "skip", "allowlist checks are disabled")
+	case reason == "":
+		call.explain("allowlist", "pass", call.domain+" is in the workspace allowlist")
+	default:
+		call.explain("allowlist", "block", reason+": "+msg)
+	}
+	if reason == "domain_not_in_allowlist" && !req.DryRun {
+		if id := e.openDomainRequest(req, method, call.domain, call.secretKeys); id != "" {
+			call.domainRequestID = id
+			msg += fmt.Sprintf(". Or ask for access: call request_domain_access with request_id %s and a justification", id)
 		}
 	}
+	if reason != "" {
+		return nil, e.block(call, reason, msg), nil
+	}
 
-	secretKeys := make([]string, 0, len(req.Injections))
-	authStyles := make([]string, 0, len(req.Injections))
-	for _, inj := range req.Injections {
-		secretKeys = append(secretKeys, inj.SecretKey)
-		authStyles = append(authStyles, inj.Style)
+	// --- Check Policy (before any secret is resolved) ---
+	var approval *PolicyDecision
+	if e.Policy != nil {
+		decision := e.Policy.Evaluate(PolicyRequest{
+			AgentID:     req.AgentID,
+			AgentToken:  req.AgentToken,
+			Method:      method,
+			TargetURL:   req.TargetURL,
+			SecretKeys:  call.secretKeys,
+			CaptureKeys: captureKeys(req.Captures),
+			BodySize:    int64(len(req.Body)),
+			Time:        time.Now(),
+			NewDomain:   !e.domainSeen(call.domain),
+		})
+		call.req.AgentID = decision.Agent // audit under the proven identity
+		if !decision.Allowed {
+			call.explain("policy", "block", decision.Reason+": "+decision.Message)
+			return nil, e.block(call, decision.Reason, decision.Message), nil
+		}
+		if decision.Rule > 0 {
+			call.explain("policy", "pass", fmt.Sprintf("allowed by rule %d of agent %q", decision.Rule, decision.Agent))
+		} else {
+			call.explain("policy", "pass", fmt.Sprintf("agent %q has no rules and the policy allows by default", decision.Agent))
+		}
+		if decision.RequireApproval {
+			approval = &decision
+		}
+	} else {
+		call.explain("policy", "skip", "no policy file; every agent may use every secret")
 	}
 
-	logBlocked := func(reason, msg string) (*CallResult, error) {
-		if e.Audit != nil {
-			_ = e.Audit.Log(AuditEvent{
-				Timestamp:  time.Now().UTC(),
-				SecretKeys: secretKeys,
-				AgentID:    req.AgentID,
-				Method:     method,
-				TargetURL:  req.TargetURL,
-				Domain:     targetDomain,
-				AuthStyles: authStyles,
-				StatusCode: 403,
-				DurationMs: 0,
-				Status:     "BLOCKED",
-				Reason:     reason,
-			})
-		}
-		
-		bodyJSON := fmt.Sprintf(`{"error":"%s","domain":"%s","message":"%s"}`, reason, targetDomain, msg)
-		headers := make(map[string][]string)
-		headers["Content-Type"] = []string{"application/json"}
-		return &CallResult{
-			StatusCode: 403,
-			Headers:    headers,
-			Body:       []byte(bodyJSON),
-		}, nil
-	}
-
-	if !e.SkipAllowlist {
-		if len(allowlist) == 0 {
-			msg := "Your workspace allowlist is empty. No credential injections are allowed until you add at least one domain.\nRun: agentsecrets workspace allowlist add <domain>"
-			return logBlocked("empty_allowlist", string(bytes.ReplaceAll([]byte(msg), []byte("\n"), []byte(" "))))
-		}
-
-		allowed := false
-		for _, raw := range allowlist {
-			if strings.ToLower(raw) == targetDomain {
-				allowed = true
-				break
-			}
+	// --- Check capture keys ---
+	for _, c := range req.Captures {
+		if e.captureWouldOverwrite(c.SecretKey) {
+			msg := fmt.Sprintf("%s already holds a secret that no capture stored; captures only create keys or replace captured ones", c.SecretKey)
+			call.explain("capture", "block", "capture_key_exists: "+msg)
+			return nil, e.block(call, "capture_key_exists", msg), nil
 		}
+	}
+
+	// --- Check LLM budgets (before anyone is asked to approve) ---
+	if msg, err := e.checkBudget(call); err != nil {
+		return nil, nil, err
+	} else if msg != "" {
+		return nil, e.block(call, "budget_exceeded", msg), nil
+	}
 
-		if !allowed {
-			msg := fmt.Sprintf("%s is not in your workspace allowlist. To authorize it, run: agentsecrets workspace allowlist add %s", targetDomain, targetDomain)
-			return logBlocked("domain_not_in_allowlist", msg)
+	// --- Await approval ---
+	if approval != nil && req.DryRun {
+		call.explain("approval", "hold", "would wait for human approval: "+approval.Message)
+	} else if approval != nil {
+		var reason, msg string
+		call.approvalID, reason, msg = e.awaitApproval(call.req, method, call.secretKeys, approval.Message)
+		if reason != "" {
+			call.explain("approval", "block", reason+": "+msg)
+			return nil, e.block(call, reason, msg), nil
 		}
+		call.explain("approval", "pass", "approved as request "+call.approvalID)
 	}
 
-	secretKeys = secretKeys[:0] // reset for normal accumulation
-	authStyles = authStyles[:0]
+	// --- Resolve secrets ---
+	for _, inj := range req.Injections {
+		cred, err := req.batch.resolve(e.ResolveSecret, inj.SecretKey)
+		if err != nil && req.DryRun {
+			call.explain("secrets", "block", inj.SecretKey+" is not in the keychain")
+			return nil, e.block(call, "secret_not_found", fmt.Sprintf("secret '%s' not found in keychain", inj.SecretKey)), nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("secret '%s' not found in keychain — use list_secrets to see available keys, or add it with 'agentsecrets secrets set %s=VALUE'", inj.SecretKey, inj.SecretKey)
+		}
+		call.secretValues = append(call.secretValues, cred)
+		call.explain("secrets", "pass", inj.SecretKey+" resolved from the keychain")
+	}
+	return call, nil, nil
+}
 
-	// --- Build outbound request ---
-	var bodyReader *bytes.Reader
-	if len(req.Body) > 0 {
-		bodyReader = bytes.NewReader(req.Body)
-	} else {
-		bodyReader = bytes.NewReader(nil)
+// block audits a refused call and returns the 403 the caller sees. A dry run
+// is not audited and gets its explanation instead.
+func (e *Engine) block(call *checkedCall, reason, msg string) *CallResult {
+	if call.req.DryRun {
+		result := explainResult(&Explanation{Steps: call.steps})
+		result.StatusCode = 403
+		result.Blocked = reason
+		return result
+	}
+	if e.Audit != nil {
+		_ = e.Audit.Log(AuditEvent{
+			Timestamp:       time.Now().UTC(),
+			SecretKeys:      call.secretKeys,
+			AgentID:         call.req.AgentID,
+			Method:          call.method,
+			TargetURL:       call.req.TargetURL,
+			Domain:          call.domain,
+			AuthStyles:      call.authStyles,
+			StatusCode:      403,
+			DurationMs:      0,
+			Status:          "BLOCKED",
+			Reason:          reason,
+			ApprovalID:      call.approvalID,
+			DomainRequestID: call.domainRequestID,
+			Redirects:       call.redirects,
+		})
 	}
 
-	outbound, err := http.NewRequest(method, req.TargetURL, bodyReader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to build request: %w", err)
+	bodyJSON, _ := json.Marshal(struct {
+		Error     string `json:"error"`
+		Domain    string `json:"domain"`
+		Message   string `json:"message"`
+		RequestID string `json:"request_id,omitempty"`
+	}{reason, call.domain, msg, call.domainRequestID})
+	headers := make(map[string][]string)
+	headers["Content-Type"] = []string{"application/json"}
+	return &CallResult{
+		StatusCode: 403,
+		Headers:    headers,
+		Body:       bodyJSON,
+		Blocked:    reason,
 	}
+}
 
-	// Copy any extra headers
-	for k, v := range req.Headers {
-		outbound.Header.Set(k, v)
+// blockForError turns a connection error the engine refused to proceed past
+// into a 403; ok is false for ordinary network errors.
+func (e *Engine) blockForError(call *checkedCall, err error) (result *CallResult, ok bool) {
+	var ssrf *SSRFError
+	if errors.As(err, &ssrf) {
+		return e.block(call, "ssrf_blocked", ssrf.Error()), true
+	}
+	var pin *PinError
+	if errors.As(err, &pin) {
+		return e.block(call, "tls_pin_mismatch", pin.Error()), true
 	}
+	return nil, false
+}
 
-	// --- Resolve secrets and inject ---
-	secretValues := make([]string, 0, len(req.Injections))
+// checkHopPolicy evaluates the policy for a redirect hop, as the call's agent
+// with the secrets the hop would carry. A hop that needs approval is refused:
+// the approval given covers the original request only.
+func (e *Engine) checkHopPolicy(call *checkedCall, next *url.URL, method string, body []byte) (reason, msg string) {
+	if e.Policy == nil {
+		return "", ""
+	}
+	host := strings.ToLower(next.Hostname())
+	var keys []string
+	if sameOrigin(call.url, next) {
+		keys = call.secretKeys
+	}
+	decision := e.Policy.Evaluate(PolicyRequest{
+		AgentID:     call.req.AgentID,
+		AgentToken:  call.req.AgentToken,
+		Method:      method,
+		TargetURL:   next.String(),
+		SecretKeys:  keys,
+		CaptureKeys: captureKeys(call.req.Captures),
+		BodySize:    int64(len(body)),
+		Time:        time.Now(),
+		NewDomain:   host != call.domain && !e.domainSeen(host),
+	})
+	if !decision.Allowed {
+		return decision.Reason, decision.Message
+	}
+	if decision.RequireApproval {
+		return "approval_required", "the redirect target needs approval: " + decision.Message
+	}
+	return "", ""
+}
 
-	for _, inj := range req.Injections {
-		cred, err := e.ResolveSecret(inj.SecretKey)
+// Execute runs the full proxy pipeline: resolve secrets → inject → forward → audit.
+// A request with a Cursor is answered from the stored response instead.
+func (e *Engine) Execute(req CallRequest) (*CallResult, error) {
+	if req.Cursor != "" {
+		return e.continueResponse(req)
+	}
+	call, blocked, err := e.check(req)
+	if call == nil {
+		return blocked, err
+	}
+	req = call.req
+
+	// --- Cache ---
+	// After every check, so a cached response only reaches a caller who may
+	// make the call
+	cc := e.cacheFor(call)
+	if cc != nil && cc.lookup {
+		if entry := e.Cache.get(cc.name, cc.state, time.Now()); entry != nil {
+			res := e.cached(call, entry, time.Now())
+			if req.Select != "" || req.MaxBytes > 0 {
+				e.shape(res, req)
+			}
+			return res, nil
+		}
+	}
+
+	// --- Forward, following redirects ---
+	// The client never follows redirects itself: each hop is checked against
+	// the allowlist, and credentials are only injected on same-origin hops.
+	client := e.Client
+	if client.CheckRedirect == nil {
+		c := *client
+		c.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+		client = &c
+	}
+
+	hopURL, hopMethod, hopBody, keepBody := call.url, call.method, req.Body, true
+	var result *ForwardResult
+	var elapsed time.Duration
+	var recorded *harExchange // the last hop, recorded once captured
+	for hops := 0; ; hops++ {
+		outbound, err := buildOutbound(hopMethod, hopURL.String(), req.Headers, hopBody)
+		if err != nil {
+			return nil, err
+		}
+		if sameOrigin(call.url, hopURL) {
+			for i, inj := range req.Injections {
+				if !keepBody && (inj.Style == "body" || inj.Style == "form") {
+					continue
+				}
+				if err := Inject(outbound, call.secretValues[i], inj); err != nil {
+					if req.DryRun {
+						call.explain("inject", "block", fmt.Sprintf("%s (%s): %v", inj.SecretKey, inj.Style, err))
+						return e.block(call, "injection_failed", err.Error()), nil
+					}
+					return nil, fmt.Errorf("injection failed for %s (%s): %w", inj.SecretKey, inj.Style, err)
+				}
+			}
+		}
+		if req.DryRun {
+			return e.dryRun(call, outbound)
+		}
+		// Lets a replaying transport find the injected values
+		outbound = outbound.WithContext(withCallSecrets(outbound.Context(), call))
+		recorded, err = e.recorder.begin(outbound)
 		if err != nil {
-			return nil, fmt.Errorf("secret '%s' not found in keychain — use list_secrets to see available keys, or add it with 'agentsecrets secrets set %s=VALUE'", inj.SecretKey, inj.SecretKey)
+			return nil, err
 		}
 
-		if err := Inject(outbound, cred, inj); err != nil {
-			return nil, fmt.Errorf("injection failed for %s (%s): %w", inj.SecretKey, inj.Style, err)
+		hopClient := client
+		if e.Upstream != nil {
+			c := *client
+			c.Timeout = e.Upstream.timeoutFor(strings.ToLower(hopURL.Hostname()))
+			hopClient = &c
+		}
+		result, err = Forward(hopClient, outbound)
+		if err != nil {
+			if blocked, ok := e.blockForError(call, err); ok {
+				return blocked, nil
+			}
+			return nil, err
 		}
+		elapsed += result.Duration
+		recorded.finish(result)
 
-		secretKeys = append(secretKeys, inj.SecretKey)
-		authStyles = append(authStyles, inj.Style)
-		secretValues = append(secretValues, cred)
-	}
+		next, ok := redirectLocation(result, hopURL)
+		if !ok {
+			break
+		}
+		e.recorder.write(recorded, callSecrets(call))
+		call.redirects = append(call.redirects, redactValues(next.String(), call.secretValues))
+		if hops == MaxRedirects {
+			return e.block(call, "too_many_redirects", fmt.Sprintf("stopped after %d redirects", MaxRedirects)), nil
+		}
+		if !sameOrigin(call.url, next) {
+			var leaks bool
+			if next, leaks = scrubCrossOrigin(next, call); leaks {
+				return e.block(call, "redirect_credential_in_url", fmt.Sprintf("%s redirected to another origin with a credential in the URL", call.domain)), nil
+			}
+		}
+		nextDomain := strings.ToLower(next.Hostname())
+		reason, msg, err := e.checkAllowlist(nextDomain, req.batch)
+		if err != nil {
+			return nil, err
+		}
+		if reason != "" {
+			return e.block(call, "redirect_"+reason, fmt.Sprintf("%s redirected to %s, which is not allowed: %s", call.domain, nextDomain, msg)), nil
+		}
 
-	// --- Forward ---
-	result, err := Forward(e.Client, outbound)
-	if err != nil {
-		return nil, err
+		hopMethod, keepBody = redirectMethod(result.StatusCode, hopMethod)
+		if !keepBody {
+			hopBody = nil
+		}
+		if reason, msg := e.checkHopPolicy(call, next, hopMethod, hopBody); reason != "" {
+			return e.block(call, "redirect_"+reason, fmt.Sprintf("%s redirected to %s: %s", call.domain, redactValues(next.String(), call.secretValues), msg)), nil
+		}
+		hopURL = next
 	}
+	result.Duration = elapsed
+	e.markDomainSeen(call.domain)
 
 	// --- Redact ---
 	redacted := false
@@ -224,12 +620,12 @@ func (e *Engine) Execute(req CallRequest) (*CallResult, error) {
 		if len(result.Headers["Content-Type"]) > 0 {
 			contentType = result.Headers["Content-Type"][0]
 		}
-		
+
 		if contentType != "" && !strings.Contains(contentType, "application/json") && !strings.Contains(contentType, "text/") {
 			fmt.Fprintf(os.Stderr, "Warning: redacting unexpected content type: %s\n", contentType)
 		}
 
-		for _, val := range secretValues {
+		for _, val := range call.secretValues {
 			if val == "" {
 				continue
 			}
@@ -244,33 +640,202 @@ func (e *Engine) Execute(req CallRequest) (*CallResult, error) {
 		}
 	}
 
+	// --- Capture ---
+	// Only successful responses carry tokens worth keeping; error bodies pass through untouched.
+	var captured []string
+	var captureErr error
+	recordSecrets := callSecrets(call)
+	if len(req.Captures) > 0 && result.StatusCode >= 200 && result.StatusCode < 300 {
+		store := e.StoreSecret
+		if recorded != nil && store != nil {
+			// The recording must not hold captured values either
+			store = func(key, value string) error {
+				recordSecrets = append(recordSecrets, capturedSecret(key, value))
+				return e.StoreSecret(key, value)
+			}
+		}
+		result.Body, captured, captureErr = applyCaptures(result.Body, req.Captures, store)
+		if len(captured) > 0 {
+			result.Headers["Content-Length"] = []string{fmt.Sprintf("%d", len(result.Body))}
+		}
+	}
+	e.recorder.write(recorded, recordSecrets)
+
+	// --- Usage ---
+	var usage *Usage
+	if e.Budget.IsProvider(call.domain) && result.StatusCode < 300 {
+		usage = parseUsage(result.Body, e.Budget.prices())
+	}
+
 	// --- Audit ---
 	if e.Audit != nil {
+		reason := "-"
+		if captureErr != nil {
+			reason = "capture_failed"
+		} else if redacted {
+			reason = "credential_echo"
+		}
 		_ = e.Audit.Log(AuditEvent{
-			Timestamp:  time.Now().UTC(),
-			SecretKeys: secretKeys,
-			AgentID:    req.AgentID,
-			Method:     method,
-			TargetURL:  req.TargetURL,
-			Domain:     targetDomain,
-			AuthStyles: authStyles,
-			StatusCode: result.StatusCode,
-			DurationMs: result.Duration.Milliseconds(),
-			Status:     "OK",
-			Reason:     func() string { if redacted { return "credential_echo" }; return "-" }(),
-			Redacted:   redacted,
+			Timestamp:    time.Now().UTC(),
+			SecretKeys:   call.secretKeys,
+			AgentID:      req.AgentID,
+			Method:       call.method,
+			TargetURL:    req.TargetURL,
+			Domain:       call.domain,
+			AuthStyles:   call.authStyles,
+			StatusCode:   result.StatusCode,
+			DurationMs:   result.Duration.Milliseconds(),
+			Status:       "OK",
+			Reason:       reason,
+			Redacted:     redacted,
+			CapturedKeys: captured,
+			ApprovalID:   call.approvalID,
+			Redirects:    call.redirects,
+			Usage:        usage,
 		})
 	}
 
+	if captureErr != nil {
+		return nil, fmt.Errorf("response capture failed: %w", captureErr)
+	}
+	if cc != nil {
+		e.store(cc, result, redacted, time.Now())
+	}
+
 	// --- Build response ---
 	headers := make(map[string][]string)
 	for k, v := range result.Headers {
 		headers[k] = v
 	}
 
-	return &CallResult{
+	res := &CallResult{
 		StatusCode: result.StatusCode,
 		Headers:    headers,
 		Body:       result.Body,
-	}, nil
+		Captured:   captured,
+		Redacted:   redacted,
+	}
+
+	// --- Shape ---
+	// After redaction, so a secret cannot survive in a slice or projection
+	if req.Select != "" || req.MaxBytes > 0 {
+		e.shape(res, req)
+	}
+	return res, nil
+}
+
+// awaitApproval holds the request until a human decides. It returns the
+// approval ID, or a block reason and message when the request may not proceed.
+func (e *Engine) awaitApproval(req CallRequest, method string, secretKeys []string, why string) (string, string, string) {
+	if e.Approvals == nil {
+		return "", "approval_unavailable", "this request requires human approval but the approval queue is unavailable"
+	}
+
+	pending := &PendingApproval{
+		ProjectID:  e.ProjectID,
+		AgentID:    req.AgentID,
+		Method:     method,
+		TargetURL:  req.TargetURL,
+		SecretKeys: secretKeys,
+		Reason:     why,
+	}
+	if err := e.Approvals.Submit(pending); err != nil {
+		return "", "approval_unavailable", err.Error()
+	}
+
+	notice := fmt.Sprintf("Approval required [%s]: %s %s (%s) — run: agentsecrets proxy approve %s",
+		pending.ID, method, req.TargetURL, strings.Join(secretKeys, ", "), pending.ID)
+	fmt.Fprintln(os.Stderr, notice)
+	if req.Progress != nil {
+		req.Progress(notice)
+	}
+
+	timeout := e.ApprovalTimeout
+	if timeout == 0 {
+		timeout = DefaultApprovalTimeout
+	}
+	status, err := e.Approvals.Wait(pending, timeout, func(waited time.Duration) {
+		if req.Progress != nil {
+			req.Progress(fmt.Sprintf("Waiting for approval [%s] (%s of %s)", pending.ID, waited.Round(time.Second), timeout))
+		}
+	})
+	switch {
+	case err != nil:
+		return pending.ID, "approval_unavailable", err.Error()
+	case status == ApprovalApproved:
+		return pending.ID, "", ""
+	case status == ApprovalDenied:
+		return pending.ID, "approval_denied", fmt.Sprintf("approval request %s was denied", pending.ID)
+	default:
+		return pending.ID, "approval_timeout", fmt.Sprintf("approval request %s was not answered within %s", pending.ID, timeout)
+	}
+}
+
+// buildOutbound creates a request for one hop, before any credential is injected.
+func buildOutbound(method, target string, headers map[string][]string, body []byte) (*http.Request, error) {
+	outbound, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %w", err)
+	}
+
+	// Copy any extra headers
+	for k, vals := range headers {
+		for _, v := range vals {
+			outbound.Header.Add(k, v)
+		}
+	}
+	return outbound, nil
+}
+
+// redactValues replaces any secret value in s, e.g. a query credential an
+// upstream echoed into a Location header.
+func redactValues(s string, values []string) string {
+	for _, v := range values {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, RedactedPlaceholder)
+		}
+	}
+	return s
+}
+
+// openDomainRequest records an allowlist request for a blocked domain and
+// returns its ID, or "" if no request could be recorded.
+func (e *Engine) openDomainRequest(req CallRequest, method, domain string, secretKeys []string) string {
+	if e.DomainRequests == nil {
+		return ""
+	}
+	r, err := e.DomainRequests.Open(&DomainRequest{
+		WorkspaceID: e.WorkspaceID,
+		ProjectID:   e.ProjectID,
+		Domain:      domain,
+		AgentID:     req.AgentID,
+		Method:      method,
+		TargetURL:   req.TargetURL,
+		SecretKeys:  secretKeys,
+	})
+	if err != nil {
+		return ""
+	}
+	return r.ID
+}
+
+// domainSeen reports whether a call to domain has succeeded before.
+func (e *Engine) domainSeen(domain string) bool {
+	e.seenMu.Lock()
+	defer e.seenMu.Unlock()
+	if e.seenDomains == nil {
+		if e.Audit != nil {
+			e.seenDomains = e.Audit.SeenDomains()
+		} else {
+			e.seenDomains = make(map[string]bool)
+		}
+	}
+	return e.seenDomains[domain]
+}
+
+func (e *Engine) markDomainSeen(domain string) {
+	e.domainSeen(domain) // make sure the set is loaded
+	e.seenMu.Lock()
+	e.seenDomains[domain] = true
+	e.seenMu.Unlock()
 }
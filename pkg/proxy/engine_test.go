@@ -210,7 +210,7 @@ func TestEngineExecuteExtraHeaders(t *testing.T) {
 	result, err := engine.Execute(CallRequest{
 		TargetURL: upstream.URL,
 		Method:    "POST",
-		Headers:   map[string]string{"Content-Type": "application/json"},
+		Headers:   map[string][]string{"Content-Type": {"application/json"}},
 		Body:      []byte(`{"data": true}`),
 		Injections: []Injection{
 			{Style: "bearer", SecretKey: "KEY"},
@@ -335,3 +335,147 @@ func TestEngineExecuteRedactBody(t *testing.T) {
 }
 
 // alright one lasy lol! we need to update the docs to explain the zero-trust in depth, the env command, both to the bots and the workflow content in the init command.. 
+
+func TestEngineExecuteCapture(t *testing.T) {
+	sessionToken := "sess_LIVE_TOKEN_abc123"
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write([]byte(fmt.Sprintf(`{"access_token": "%s", "expires_in": 3600}`, sessionToken)))
+	}))
+	defer upstream.Close()
+
+	stored := map[string]string{}
+	engine := &Engine{
+		ProjectID:     "test-project",
+		SkipAllowlist: true,
+		Client:        upstream.Client(),
+		ResolveSecret: mockResolver(map[string]string{"CLIENT_SECRET": "cs_123"}),
+		StoreSecret: func(key, value string) error {
+			stored[key] = value
+			return nil
+		},
+	}
+
+	result, err := engine.Execute(CallRequest{
+		TargetURL: upstream.URL + "/oauth/token",
+		Method:    "POST",
+		Injections: []Injection{
+			{Style: "form", Target: "client_secret", SecretKey: "CLIENT_SECRET"},
+		},
+		Captures: []Capture{
+			{Path: "$.access_token", SecretKey: "VENDOR_SESSION"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+
+	if stored["VENDOR_SESSION"] != sessionToken {
+		t.Errorf("stored VENDOR_SESSION = %q, want %q", stored["VENDOR_SESSION"], sessionToken)
+	}
+	if strings.Contains(string(result.Body), sessionToken) {
+		t.Fatal("SECURITY: captured value was found in response body!")
+	}
+	if !strings.Contains(string(result.Body), "[CAPTURED_BY_AGENTSECRETS:VENDOR_SESSION]") {
+		t.Errorf("Body = %s, expected capture placeholder", string(result.Body))
+	}
+	if len(result.Captured) != 1 || result.Captured[0] != "VENDOR_SESSION" {
+		t.Errorf("Captured = %v, want [VENDOR_SESSION]", result.Captured)
+	}
+}
+
+func TestEngineExecuteCaptureRefusesExistingKey(t *testing.T) {
+	var calls int
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "attacker_chosen_value"}`))
+	}))
+	defer upstream.Close()
+
+	stored := map[string]string{}
+	engine := &Engine{
+		ProjectID:     "test-project",
+		SkipAllowlist: true,
+		Client:        upstream.Client(),
+		ResolveSecret: mockResolver(map[string]string{"KEY": "client_key_1", "STRIPE_KEY": "sk_live_real"}),
+		StoreSecret: func(key, value string) error {
+			stored[key] = value
+			return nil
+		},
+	}
+	capture := func(key string) *CallResult {
+		t.Helper()
+		result, err := engine.Execute(CallRequest{
+			TargetURL:  upstream.URL,
+			Method:     "POST",
+			Injections: []Injection{{Style: "bearer", SecretKey: "KEY"}},
+			Captures:   []Capture{{Path: "$.access_token", SecretKey: key}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	// Without origin information, any existing key is refused
+	if result := capture("STRIPE_KEY"); result.Blocked != "capture_key_exists" {
+		t.Errorf("Blocked = %q, want capture_key_exists", result.Blocked)
+	}
+
+	// A key a capture stored may be replaced; others may not
+	engine.CapturedSecret = func(key string) (bool, bool) {
+		return key != "NEW_SESSION", key == "VENDOR_SESSION"
+	}
+	if result := capture("STRIPE_KEY"); result.Blocked != "capture_key_exists" {
+		t.Errorf("Blocked = %q, want capture_key_exists", result.Blocked)
+	}
+	if calls != 0 || len(stored) != 0 {
+		t.Fatalf("refused capture reached the upstream (%d calls) or stored %v", calls, stored)
+	}
+	for _, key := range []string{"VENDOR_SESSION", "NEW_SESSION"} {
+		if result := capture(key); result.Blocked != "" || stored[key] != "attacker_chosen_value" {
+			t.Errorf("capture into %s: blocked %q, stored %v", key, result.Blocked, stored)
+		}
+	}
+}
+
+func TestEngineExecuteCaptureMissingPath(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(200)
+		w.Write([]byte(`{"token_type": "bearer"}`))
+	}))
+	defer upstream.Close()
+
+	stored := map[string]string{}
+	engine := &Engine{
+		ProjectID:     "test-project",
+		SkipAllowlist: true,
+		Client:        upstream.Client(),
+		ResolveSecret: mockResolver(map[string]string{"KEY": "val"}),
+		StoreSecret: func(key, value string) error {
+			stored[key] = value
+			return nil
+		},
+	}
+
+	_, err := engine.Execute(CallRequest{
+		TargetURL:  upstream.URL,
+		Method:     "POST",
+		Injections: []Injection{{Style: "bearer", SecretKey: "KEY"}},
+		Captures: []Capture{
+			{Path: "$.token_type", SecretKey: "VENDOR_TOKEN_TYPE"},
+			{Path: "$.access_token", SecretKey: "VENDOR_SESSION"},
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error for missing capture path")
+	}
+	// The capture that did resolve is not left behind either
+	if len(stored) != 0 {
+		t.Errorf("stored %v, want nothing when any capture fails", stored)
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+func (c *checkedCall) explain(stage, outcome, detail string) {
+	c.steps = append(c.steps, ExplainStep{Stage: stage, Outcome: outcome, Detail: detail})
+}
+
+// dryRun ends a dry run at the point the request would be sent.
+func (e *Engine) dryRun(call *checkedCall, outbound *http.Request) (*CallResult, error) {
+	for _, inj := range call.req.Injections {
+		call.explain("inject", "pass", fmt.Sprintf("%s → %s", inj.SecretKey, describeInjection(inj)))
+	}
+
+	var body []byte
+	if outbound.Body != nil {
+		var err error
+		if body, err = io.ReadAll(outbound.Body); err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+	preview := &OutboundPreview{
+		Method:  outbound.Method,
+		URL:     call.mask(outbound.URL.String()),
+		Headers: make(map[string][]string),
+		Body:    call.mask(string(body)),
+	}
+	for k, vals := range outbound.Header {
+		for _, v := range vals {
+			preview.Headers[k] = append(preview.Headers[k], call.mask(v))
+		}
+	}
+
+	return explainResult(&Explanation{WouldSend: true, Steps: call.steps, Request: preview}), nil
+}
+
+// mask replaces each injected value with the name of its secret: the value as
+// injected whatever its length, and its other common encodings.
+func (c *checkedCall) mask(s string) string {
+	for i, value := range c.secretValues {
+		inj := c.req.Injections[i]
+		for _, v := range append(injectedForms(inj, value), SecretVariants(value)...) {
+			s = strings.ReplaceAll(s, v, "<"+inj.SecretKey+">")
+		}
+	}
+	return s
+}
+
+func explainResult(x *Explanation) *CallResult {
+	body, _ := json.MarshalIndent(x, "", "  ")
+	return &CallResult{
+		StatusCode: 200,
+		Headers:    map[string][]string{"Content-Type": {"application/json"}},
+		Body:       body,
+		Explain:    x,
+	}
+}
+
+func describeInjection(inj Injection) string {
+	switch inj.Style {
+	case "bearer":
+		return "Authorization: Bearer"
+	case "basic":
+		return "Authorization: Basic"
+	case "header":
+		return "header " + inj.Target
+	case "query":
+		return "query parameter " + inj.Target
+	case "body":
+		return "JSON body at " + inj.Target
+	case "form":
+		return "form field " + inj.Target
+	}
+	return inj.Style
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestEngineDryRun(t *testing.T) {
+	hit := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hit = true
+	}))
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	engine.ResolveSecret = mockResolver(map[string]string{"API_KEY": "sk_test_123", "ORG_CREDS": "org:s3cret"})
+	engine.Policy = &Policy{
+		Agents: map[string]*AgentPolicy{"bot": {Rules: []PolicyRule{
+			{Methods: []string{"GET"}},
+			{Methods: []string{"POST"}},
+		}}},
+		Approvals: []ApprovalRule{{Methods: []string{"POST"}}},
+	}
+
+	result, err := engine.Execute(CallRequest{
+		TargetURL: upstream.URL + "/v1/orders",
+		Method:    "POST",
+		AgentID:   "bot",
+		Body:      []byte(`{"amount":1}`),
+		Injections: []Injection{
+			{Style: "basic", SecretKey: "ORG_CREDS"},
+			{Style: "query", Target: "key", SecretKey: "API_KEY"},
+		},
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if hit {
+		t.Error("a dry run must not reach the upstream")
+	}
+
+	x := result.Explain
+	if x == nil || !x.WouldSend || result.StatusCode != 200 {
+		t.Fatalf("result = %d %+v", result.StatusCode, x)
+	}
+	var stages []string
+	for _, s := range x.Steps {
+		stages = append(stages, s.Stage+":"+s.Outcome)
+	}
+	if got := strings.Join(stages, " "); got != "allowlist:skip policy:pass approval:hold secrets:pass secrets:pass inject:pass inject:pass" {
+		t.Errorf("steps = %s", got)
+	}
+	if !strings.Contains(x.Steps[1].Detail, "rule 2") {
+		t.Errorf("policy step = %q, want the rule that allowed it", x.Steps[1].Detail)
+	}
+
+	r := x.Request
+	if r.Headers["Authorization"][0] != "Basic <ORG_CREDS>" || !strings.HasSuffix(r.URL, "/v1/orders?key=<API_KEY>") || r.Body != `{"amount":1}` {
+		t.Errorf("preview = %+v", r)
+	}
+	if strings.Contains(string(result.Body), "sk_test_123") || strings.Contains(x.String(), "sk_test_123") {
+		t.Error("dry run output leaked a secret value")
+	}
+
+	if data, _ := os.ReadFile(logPath); len(data) != 0 {
+		t.Errorf("dry runs must not be audited, got %s", data)
+	}
+}
+
+func TestEngineDryRunBlocked(t *testing.T) {
+	engine, logPath := redirectEngine(t)
+	engine.Policy = &Policy{Agents: map[string]*AgentPolicy{
+		"bot": {Rules: []PolicyRule{{Domains: []string{"api.github.com"}}}},
+	}}
+
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  "https://api.stripe.com/v1/charges",
+		AgentID:    "bot",
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+		DryRun:     true,
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	x := result.Explain
+	if result.StatusCode != 403 || result.Blocked != "policy_domain_denied" || x == nil || x.WouldSend || x.Request != nil {
+		t.Fatalf("result = %d %s %+v", result.StatusCode, result.Blocked, x)
+	}
+	if last := x.Steps[len(x.Steps)-1]; last.Stage != "policy" || last.Outcome != "block" {
+		t.Errorf("last step = %+v", last)
+	}
+
+	// A missing secret is explained rather than failing the call
+	engine.Policy = nil
+	result, err = engine.Execute(CallRequest{
+		TargetURL:  "https://api.stripe.com/v1/charges",
+		Injections: []Injection{{Style: "bearer", SecretKey: "MISSING_KEY"}},
+		DryRun:     true,
+	})
+	if err != nil || result.Blocked != "secret_not_found" {
+		t.Errorf("missing secret: %+v, %v", result, err)
+	}
+
+	if data, _ := os.ReadFile(logPath); len(data) != 0 {
+		t.Errorf("dry runs must not be audited, got %s", data)
+	}
+}
+
+func TestEngineDryRunMasksShortSecrets(t *testing.T) {
+	engine, _ := redirectEngine(t)
+	engine.ResolveSecret = mockResolver(map[string]string{"TOKEN": "k9z", "QUERY_KEY": "a&", "BODY_KEY": `x"`, "HEADER_KEY": "h1"})
+
+	result, err := engine.Execute(CallRequest{
+		TargetURL: "https://api.example.com/v1/items",
+		Method:    "POST",
+		Injections: []Injection{
+			{Style: "bearer", SecretKey: "TOKEN"},
+			{Style: "query", Target: "k", SecretKey: "QUERY_KEY"},
+			{Style: "body", Target: "auth.k", SecretKey: "BODY_KEY"},
+			{Style: "header", Target: "X-Key", SecretKey: "HEADER_KEY"},
+		},
+		DryRun: true,
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	r := result.Explain.Request
+	if got := r.Headers["Authorization"][0]; got != "Bearer <TOKEN>" {
+		t.Errorf("Authorization = %q", got)
+	}
+	if got := r.Headers["X-Key"][0]; got != "<HEADER_KEY>" {
+		t.Errorf("X-Key = %q", got)
+	}
+	if !strings.HasSuffix(r.URL, "/v1/items?k=<QUERY_KEY>") {
+		t.Errorf("url = %q", r.URL)
+	}
+	if r.Body != `{"auth":{"k":"<BODY_KEY>"}}` {
+		t.Errorf("body = %q", r.Body)
+	}
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// gRPC status codes used by the proxy (see google.golang.org/grpc/codes).
+const (
+	grpcInvalidArgument  = 3
+	grpcPermissionDenied = 7
+	grpcUnavailable      = 14
+)
+
+// IsGRPC reports whether r is a gRPC call.
+func IsGRPC(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// GRPCCall is one RPC relayed upstream over HTTP/2 with credentials in its
+// metadata. The caller streams Response.Body to the client and then calls Finish.
+type GRPCCall struct {
+	Response *http.Response
+
+	engine   *Engine
+	call     *checkedCall
+	started  time.Time
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// StartGRPC runs the checks of Execute for one RPC — so the allowlist and the
+// policy's paths apply per method, as /package.Service/Method — then starts
+// it upstream. body is streamed as the client sends it. If the call is blocked
+// it returns a CallResult describing why.
+func (e *Engine) StartGRPC(ctx context.Context, req CallRequest, body io.Reader) (*GRPCCall, *CallResult, error) {
+	for _, inj := range req.Injections {
+		if inj.Style != "bearer" && inj.Style != "basic" && inj.Style != "header" {
+			return nil, nil, fmt.Errorf("injection style %q is not supported for gRPC; use bearer, basic or header metadata", inj.Style)
+		}
+	}
+	req.Method = "POST"
+	call, blocked, err := e.check(req)
+	if call == nil {
+		return nil, blocked, err
+	}
+	if call.url.Scheme != "https" {
+		return nil, nil, fmt.Errorf("gRPC upstreams must use https, got %s", call.url.Scheme)
+	}
+
+	g := &GRPCCall{engine: e, call: call, started: time.Now()}
+	outbound, err := http.NewRequestWithContext(ctx, "POST", call.url.String(), &countingReader{r: body, n: &g.sent})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build request: %w", err)
+	}
+	for k, vals := range call.req.Headers {
+		for _, v := range vals {
+			outbound.Header.Add(k, v)
+		}
+	}
+	outbound.Header.Set("Te", "trailers")
+	for i, inj := range req.Injections {
+		if err := Inject(outbound, call.secretValues[i], inj); err != nil {
+			return nil, nil, fmt.Errorf("injection failed for %s (%s): %w", inj.SecretKey, inj.Style, err)
+		}
+	}
+
+	// No client timeout: streams last as long as the caller keeps ctx alive
+	transport := http.DefaultTransport
+	if e.Client != nil && e.Client.Transport != nil {
+		transport = e.Client.Transport
+	}
+	resp, err := transport.RoundTrip(outbound)
+	if err != nil {
+		if blocked, ok := e.blockForError(call, err); ok {
+			return nil, blocked, nil
+		}
+		return nil, nil, fmt.Errorf("failed to reach upstream: %w", err)
+	}
+	e.markDomainSeen(call.domain)
+
+	redactHeader(resp.Header, call.secretValues)
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{&countingReader{r: resp.Body, n: &g.received}, resp.Body}
+	g.Response = resp
+	return g, nil, nil
+}
+
+// Trailer returns the upstream trailers, redacted. It is complete once
+// Response.Body has been read to the end.
+func (g *GRPCCall) Trailer() http.Header {
+	redactHeader(g.Response.Trailer, g.call.secretValues)
+	return g.Response.Trailer
+}
+
+// Finish closes the upstream stream and audits the RPC.
+func (g *GRPCCall) Finish() {
+	g.Response.Body.Close()
+
+	e := g.engine
+	if e.Audit == nil {
+		return
+	}
+	status := g.Response.Trailer.Get("Grpc-Status")
+	if status == "" {
+		status = g.Response.Header.Get("Grpc-Status") // trailers-only response
+	}
+	reason := "-"
+	if status != "" && status != "0" {
+		reason = "grpc_status_" + status
+	}
+	_ = e.Audit.Log(AuditEvent{
+		Timestamp:     time.Now().UTC(),
+		SecretKeys:    g.call.secretKeys,
+		AgentID:       g.call.req.AgentID,
+		Method:        "GRPC",
+		TargetURL:     g.call.req.TargetURL,
+		Domain:        g.call.domain,
+		AuthStyles:    g.call.authStyles,
+		StatusCode:    g.Response.StatusCode,
+		DurationMs:    time.Since(g.started).Milliseconds(),
+		Status:        "OK",
+		Reason:        reason,
+		ApprovalID:    g.call.approvalID,
+		BytesSent:     g.sent.Load(),
+		BytesReceived: g.received.Load(),
+		GRPCStatus:    status,
+	})
+}
+
+// redactHeader replaces secret values in header values, e.g. a credential an
+// upstream echoed into grpc-message.
+func redactHeader(h http.Header, secrets []string) {
+	for k, vals := range h {
+		for i, v := range vals {
+			vals[i] = redactValues(v, secrets)
+		}
+		h[k] = vals
+	}
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// grpcProxyServer serves the proxy mux over cleartext HTTP/2 and returns a
+// client that speaks it.
+func grpcProxyServer(t *testing.T, engine *Engine) (*httptest.Server, *http.Client) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(NewServer(0, engine).mux)
+	srv.Config.Protocols = new(http.Protocols)
+	srv.Config.Protocols.SetHTTP1(true)
+	srv.Config.Protocols.SetUnencryptedHTTP2(true)
+	srv.Start()
+
+	tr := &http.Transport{Protocols: new(http.Protocols)}
+	tr.Protocols.SetUnencryptedHTTP2(true)
+	return srv, &http.Client{Transport: tr}
+}
+
+func TestServerProxiesGRPC(t *testing.T) {
+	// The upstream echoes each line of the request stream as it arrives, then
+	// ends the RPC with trailers
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != "/pubsub.v1.Publisher/Publish" {
+			http.Error(w, "unexpected "+r.Proto+" "+r.URL.Path, 400)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		if r.Header.Get("Authorization") != "Bearer ya29.token" {
+			w.Header().Set("Grpc-Status", "16")
+			w.Header().Set("Grpc-Message", "bad token "+r.Header.Get("Authorization"))
+			return
+		}
+		w.WriteHeader(200)
+		sc := bufio.NewScanner(r.Body)
+		for sc.Scan() {
+			io.WriteString(w, "ack:"+sc.Text()+"\n")
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "published with ya29.token")
+	}))
+	upstream.EnableHTTP2 = true
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	engine.Client = upstream.Client()
+	engine.ResolveSecret = mockResolver(map[string]string{"GCP_TOKEN": "ya29.token"})
+	proxySrv, client := grpcProxyServer(t, engine)
+	defer proxySrv.Close()
+
+	pr, pw := io.Pipe()
+	req, _ := http.NewRequest("POST", proxySrv.URL+"/pubsub.v1.Publisher/Publish", pr)
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("X-AS-Target-URL", upstream.URL)
+	req.Header.Set("X-AS-Inject-Bearer", "GCP_TOKEN")
+	go io.WriteString(pw, "one\n")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+
+	// Each message comes back before the client has finished sending
+	if line, err := br.ReadString('\n'); err != nil || line != "ack:one\n" {
+		t.Fatalf("first message = %q, %v", line, err)
+	}
+	io.WriteString(pw, "two\n")
+	if line, err := br.ReadString('\n'); err != nil || line != "ack:two\n" {
+		t.Fatalf("second message = %q, %v", line, err)
+	}
+	pw.Close()
+	if rest, _ := io.ReadAll(br); len(rest) != 0 {
+		t.Errorf("unexpected trailing data %q", rest)
+	}
+
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("grpc-status trailer = %q, want 0", got)
+	}
+	if got := resp.Trailer.Get("Grpc-Message"); strings.Contains(got, "ya29") {
+		t.Errorf("grpc-message leaked the token: %q", got)
+	}
+
+	event := lastAuditEvent(t, logPath)
+	if event.Method != "GRPC" || event.GRPCStatus != "0" || event.TargetURL != upstream.URL+"/pubsub.v1.Publisher/Publish" {
+		t.Fatalf("audit event = %+v", event)
+	}
+	if event.BytesSent != 8 || event.BytesReceived != 16 {
+		t.Errorf("bytes sent=%d received=%d, want 8 and 16", event.BytesSent, event.BytesReceived)
+	}
+}
+
+func TestServerGRPCBlocked(t *testing.T) {
+	engine, logPath := redirectEngine(t)
+	engine.Policy = &Policy{Agents: map[string]*AgentPolicy{
+		"reader": {Rules: []PolicyRule{{Paths: []string{"/pubsub.v1.Subscriber/*"}}}},
+	}}
+	engine.ResolveSecret = mockResolver(map[string]string{"GCP_TOKEN": "ya29.token"})
+	proxySrv, client := grpcProxyServer(t, engine)
+	defer proxySrv.Close()
+
+	req, _ := http.NewRequest("POST", proxySrv.URL+"/pubsub.v1.Publisher/Publish", strings.NewReader(""))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("X-AS-Target-URL", "https://pubsub.googleapis.com")
+	req.Header.Set("X-AS-Inject-Bearer", "GCP_TOKEN")
+	req.Header.Set("X-AS-Agent-ID", "reader")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != 200 || resp.Header.Get("Grpc-Status") != "7" {
+		t.Fatalf("status = %d grpc-status = %q, want a trailers-only PERMISSION_DENIED", resp.StatusCode, resp.Header.Get("Grpc-Status"))
+	}
+	if event := lastAuditEvent(t, logPath); event.Status != "BLOCKED" {
+		t.Errorf("audit event = %+v", event)
+	}
+}
+
+func TestGRPCEncodeMessage(t *testing.T) {
+	if got := grpcEncodeMessage("100% done\nok"); got != "100%25 done%0Aok" {
+		t.Errorf("grpcEncodeMessage() = %q", got)
+	}
+}
@@ -0,0 +1,460 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// harMarker stands in for a secret value in recordings, e.g. {{AS:STRIPE_KEY}}.
+const harMarker = "{{AS:%s}}"
+
+// Record makes the engine write the upstream exchanges of its calls to HAR
+// files in dir, one per host. A call's last response is written once it has
+// been redacted and captured, with injected and captured values replaced by
+// {{AS:KEY}} markers. Existing recordings in dir are appended to.
+func (e *Engine) Record(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("create recording directory: %w", err)
+	}
+	e.recorder = &harRecorder{dir: dir, logs: make(map[string]*harLog)}
+	return nil
+}
+
+// Replay makes the engine answer from the HAR files in dir instead of the
+// network. Every check still runs; only the upstream is replaced. A request
+// with no recorded response fails.
+//
+// Replay needs no keyring: a secret the keyring does not have is injected as
+// its own marker, which matches the recording, and if the workspace allowlist
+// cannot be read, the hosts that have recordings are allowed.
+func (e *Engine) Replay(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.har"))
+	if err != nil {
+		return err
+	}
+	r := &harReplayer{served: make(map[*harEntry]bool)}
+	for _, path := range paths {
+		log, err := readHAR(path)
+		if err != nil {
+			return err
+		}
+		r.entries = append(r.entries, log.Entries...)
+	}
+	if len(r.entries) == 0 {
+		return fmt.Errorf("no recordings found in %s", dir)
+	}
+	e.Client.Transport = r
+
+	resolve := e.ResolveSecret
+	if e.CapturedSecret == nil {
+		// Whether a capture would overwrite a key still goes by the real secrets
+		e.CapturedSecret = func(key string) (bool, bool) {
+			if resolve == nil {
+				return false, false
+			}
+			_, err := resolve(key)
+			return err == nil, false
+		}
+	}
+	e.ResolveSecret = func(key string) (string, error) {
+		if resolve != nil {
+			if value, err := resolve(key); err == nil {
+				return value, nil
+			}
+		}
+		return fmt.Sprintf(harMarker, key), nil
+	}
+	e.replayHosts = r.hosts()
+	return nil
+}
+
+// callSecretsKey carries a call's secrets on its outbound requests, so the
+// replayer can swap them for markers.
+type callSecretsKey struct{}
+
+type callSecret struct {
+	key   string
+	value string
+	inj   Injection // how the value was put on the wire
+}
+
+func callSecrets(call *checkedCall) []callSecret {
+	secrets := make([]callSecret, len(call.secretValues))
+	for i, v := range call.secretValues {
+		secrets[i] = callSecret{key: call.req.Injections[i].SecretKey, value: v, inj: call.req.Injections[i]}
+	}
+	return secrets
+}
+
+func withCallSecrets(ctx context.Context, call *checkedCall) context.Context {
+	return context.WithValue(ctx, callSecretsKey{}, callSecrets(call))
+}
+
+// capturedSecret is a value a capture stored, as found in a JSON body.
+func capturedSecret(key, value string) callSecret {
+	return callSecret{key: key, value: value, inj: Injection{Style: "body"}}
+}
+
+// toMarkers replaces each secret value with its marker: the value as it was
+// injected whatever its length, and its other common encodings.
+func toMarkers(s string, secrets []callSecret) string {
+	for _, sec := range secrets {
+		marker := fmt.Sprintf(harMarker, sec.key)
+		for _, v := range append(injectedForms(sec.inj, sec.value), SecretVariants(sec.value)...) {
+			s = strings.ReplaceAll(s, v, marker)
+		}
+	}
+	return s
+}
+
+// fromMarkers puts the secret values back, so a replayed response that echoed
+// a credential is redacted just as the live one was.
+func fromMarkers(s string, secrets []callSecret) string {
+	for _, sec := range secrets {
+		s = strings.ReplaceAll(s, fmt.Sprintf(harMarker, sec.key), sec.value)
+	}
+	return s
+}
+
+// HAR 1.2, as far as the recorder fills it in.
+type harFile struct {
+	Log *harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string      `json:"version"`
+	Creator harCreator  `json:"creator"`
+	Entries []*harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"` // "base64" for binary bodies
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+func readHAR(path string) (*harLog, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read recording: %w", err)
+	}
+	var f harFile
+	if err := json.Unmarshal(data, &f); err != nil || f.Log == nil {
+		return nil, fmt.Errorf("parse recording %s: not a HAR file", path)
+	}
+	return f.Log, nil
+}
+
+// harHeaders lists h sorted by name, so recordings diff cleanly.
+func harHeaders(h http.Header, secrets []callSecret) []harNameValue {
+	out := []harNameValue{}
+	for name, vals := range h {
+		for _, v := range vals {
+			out = append(out, harNameValue{Name: name, Value: toMarkers(v, secrets)})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// harRecorder records the upstream exchanges of engine calls.
+type harRecorder struct {
+	dir string
+
+	mu   sync.Mutex
+	logs map[string]*harLog // by file path
+}
+
+// harExchange is one upstream exchange, held until the call is done with it.
+type harExchange struct {
+	req     *http.Request
+	reqBody []byte
+	started time.Time
+
+	status   int
+	header   http.Header
+	respBody []byte // as received, before redaction and capture
+	elapsed  time.Duration
+}
+
+// begin snapshots an outbound request before it is sent. It returns nil when
+// the engine is not recording.
+func (r *harRecorder) begin(req *http.Request) (*harExchange, error) {
+	if r == nil {
+		return nil, nil
+	}
+	x := &harExchange{req: req, started: time.Now()}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		x.reqBody = body
+	}
+	return x, nil
+}
+
+// finish keeps the response as received; redaction and capture later replace
+// result.Body, not the bytes kept here.
+func (x *harExchange) finish(result *ForwardResult) {
+	if x == nil {
+		return
+	}
+	x.status = result.StatusCode
+	x.header = result.Headers.Clone()
+	x.respBody = result.Body
+	x.elapsed = result.Duration
+}
+
+// write records x with every value in secrets replaced by its marker.
+func (r *harRecorder) write(x *harExchange, secrets []callSecret) {
+	if r == nil || x == nil {
+		return
+	}
+	elapsed := float64(x.elapsed.Milliseconds())
+	maskedURL := toMarkers(x.req.URL.String(), secrets)
+	entry := &harEntry{
+		StartedDateTime: x.started.UTC(),
+		Time:            elapsed,
+		Request: harRequest{
+			Method:      x.req.Method,
+			URL:         maskedURL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(x.req.Header, secrets),
+			QueryString: []harNameValue{},
+			HeadersSize: -1,
+			BodySize:    len(x.reqBody),
+		},
+		Response: harResponse{
+			Status:      x.status,
+			StatusText:  http.StatusText(x.status),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(x.header, secrets),
+			Content:     harContent{Size: len(x.respBody), MimeType: x.header.Get("Content-Type")},
+			RedirectURL: toMarkers(x.header.Get("Location"), secrets),
+			HeadersSize: -1,
+			BodySize:    len(x.respBody),
+		},
+		Timings: harTimings{Wait: elapsed},
+	}
+	if u, err := url.Parse(maskedURL); err == nil {
+		for name, vals := range u.Query() {
+			for _, v := range vals {
+				entry.Request.QueryString = append(entry.Request.QueryString, harNameValue{Name: name, Value: v})
+			}
+		}
+	}
+	if len(x.reqBody) > 0 {
+		entry.Request.PostData = &harPostData{MimeType: x.req.Header.Get("Content-Type"), Text: toMarkers(string(x.reqBody), secrets)}
+	}
+	if utf8.Valid(x.respBody) {
+		entry.Response.Content.Text = toMarkers(string(x.respBody), secrets)
+	} else {
+		entry.Response.Content.Text = base64.StdEncoding.EncodeToString(x.respBody)
+		entry.Response.Content.Encoding = "base64"
+	}
+
+	if err := r.add(x.req.URL.Hostname(), entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record %s %s: %v\n", x.req.Method, maskedURL, err)
+	}
+}
+
+func (r *harRecorder) add(host string, entry *harEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := filepath.Join(r.dir, host+".har")
+	log, ok := r.logs[path]
+	if !ok {
+		var err error
+		if log, err = readHAR(path); errors.Is(err, os.ErrNotExist) {
+			log = &harLog{Version: "1.2", Creator: harCreator{Name: "agentsecrets", Version: "1.0.0"}}
+		} else if err != nil {
+			return err
+		}
+		r.logs[path] = log
+	}
+	log.Entries = append(log.Entries, entry)
+	return writeJSONAtomic(r.dir, path, harFile{Log: log})
+}
+
+// harReplayer answers engine calls from recordings. Identical requests get
+// their recorded responses in order, then the last one again.
+type harReplayer struct {
+	mu      sync.Mutex
+	entries []*harEntry
+	served  map[*harEntry]bool
+}
+
+func (r *harReplayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	secrets, ok := req.Context().Value(callSecretsKey{}).([]callSecret)
+	if !ok {
+		return nil, fmt.Errorf("replay: only proxied API calls can be replayed")
+	}
+
+	var body []byte
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	maskedURL := toMarkers(req.URL.String(), secrets)
+	maskedBody := toMarkers(string(body), secrets)
+
+	entry := r.match(req.Method, maskedURL, maskedBody)
+	if entry == nil {
+		return nil, fmt.Errorf("replay: no recorded response for %s %s", req.Method, maskedURL)
+	}
+
+	respBody := []byte(fromMarkers(entry.Response.Content.Text, secrets))
+	if entry.Response.Content.Encoding == "base64" {
+		var err error
+		if respBody, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+			return nil, fmt.Errorf("replay: bad recorded body for %s %s: %w", req.Method, maskedURL, err)
+		}
+	}
+	header := make(http.Header)
+	for _, h := range entry.Response.Headers {
+		header.Add(h.Name, fromMarkers(h.Value, secrets))
+	}
+	header.Del("Content-Encoding") // recorded bodies are stored decoded
+	header.Set("Content-Length", fmt.Sprintf("%d", len(respBody)))
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", entry.Response.Status, entry.Response.StatusText),
+		StatusCode:    entry.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+// hosts lists the hosts that have recordings.
+func (r *harReplayer) hosts() []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, e := range r.entries {
+		u, err := url.Parse(e.Request.URL)
+		if err != nil || seen[u.Hostname()] {
+			continue
+		}
+		seen[u.Hostname()] = true
+		hosts = append(hosts, u.Hostname())
+	}
+	return hosts
+}
+
+// match finds the entry for a request: same method and URL, preferring the
+// same body, and the first not yet served.
+func (r *harReplayer) match(method, url, body string) *harEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var sameURL, sameBody []*harEntry
+	for _, e := range r.entries {
+		if e.Request.Method != method || e.Request.URL != url {
+			continue
+		}
+		sameURL = append(sameURL, e)
+		recorded := ""
+		if e.Request.PostData != nil {
+			recorded = e.Request.PostData.Text
+		}
+		if recorded == body {
+			sameBody = append(sameBody, e)
+		}
+	}
+	candidates := sameBody
+	if len(candidates) == 0 {
+		candidates = sameURL
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	for _, e := range candidates {
+		if !r.served[e] {
+			r.served[e] = true
+			return e
+		}
+	}
+	return candidates[len(candidates)-1]
+}
@@ -0,0 +1,187 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	var calls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Echo", r.Header.Get("Authorization"))
+		fmt.Fprintf(w, `{"call":%d,"auth":%q}`, n, r.Header.Get("Authorization"))
+	}))
+	dir := t.TempDir()
+	req := CallRequest{
+		TargetURL:  upstream.URL + "/v1/charges?limit=1",
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}, {Style: "query", Target: "key", SecretKey: "API_KEY"}},
+	}
+
+	engine, _ := redirectEngine(t)
+	if err := engine.Record(dir); err != nil {
+		t.Fatal(err)
+	}
+	var live []*CallResult
+	for i := 0; i < 2; i++ {
+		result, err := engine.Execute(req)
+		if err != nil {
+			t.Fatalf("Execute() error: %v", err)
+		}
+		live = append(live, result)
+	}
+	upstream.Close()
+
+	// Recordings are safe to commit: markers instead of values
+	host := strings.Split(strings.TrimPrefix(upstream.URL, "http://"), ":")[0]
+	data, err := os.ReadFile(filepath.Join(dir, host+".har"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "sk_test_123") {
+		t.Fatalf("recording contains the secret:\n%s", data)
+	}
+	for _, want := range []string{`"version": "1.2"`, `Bearer {{AS:API_KEY}}`, `key={{AS:API_KEY}}`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("recording is missing %q", want)
+		}
+	}
+
+	// Replay answers offline, in recorded order, and redacts as live did
+	engine, _ = redirectEngine(t)
+	if err := engine.Replay(dir); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		result, err := engine.Execute(req)
+		if err != nil {
+			t.Fatalf("replay Execute() error: %v", err)
+		}
+		want := live[min(i, 1)]
+		if result.StatusCode != want.StatusCode || string(result.Body) != string(want.Body) || !result.Redacted {
+			t.Errorf("replay %d = %d %s, want %d %s", i, result.StatusCode, result.Body, want.StatusCode, want.Body)
+		}
+		if got, want := http.Header(result.Headers).Get("X-Echo"), http.Header(want.Headers).Get("X-Echo"); got != want {
+			t.Errorf("replay %d X-Echo = %q, want %q", i, got, want)
+		}
+	}
+
+	// A call that was never recorded fails instead of reaching the network
+	req.TargetURL = upstream.URL + "/v1/refunds"
+	if _, err := engine.Execute(req); err == nil || !strings.Contains(err.Error(), "no recorded response for GET") {
+		t.Errorf("unrecorded call error = %v", err)
+	}
+}
+
+func TestReplayMatchesBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		r.Body.Read(body)
+		w.Write([]byte("got " + string(body)))
+	}))
+	dir := t.TempDir()
+	bearer := []Injection{{Style: "bearer", SecretKey: "API_KEY"}}
+
+	engine, _ := redirectEngine(t)
+	if err := engine.Record(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"n":1}`, `{"n":2}`} {
+		if _, err := engine.Execute(CallRequest{Method: "POST", TargetURL: upstream.URL, Body: []byte(body), Injections: bearer}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upstream.Close()
+
+	engine, _ = redirectEngine(t)
+	if err := engine.Replay(dir); err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.Execute(CallRequest{Method: "POST", TargetURL: upstream.URL, Body: []byte(`{"n":2}`), Injections: bearer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Body) != `got {"n":2}` {
+		t.Errorf("body = %q, want the response recorded for the same request body", result.Body)
+	}
+}
+
+func TestReplayNeedsRecordings(t *testing.T) {
+	engine, _ := redirectEngine(t)
+	if err := engine.Replay(t.TempDir()); err == nil {
+		t.Error("expected an error for a directory without recordings")
+	}
+}
+
+func TestRecordMasksCapturesAndShortSecrets(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"tok_live_9f8e7d","pin":%q}`, r.URL.Query().Get("pin"))
+	}))
+	defer upstream.Close()
+	dir := t.TempDir()
+	req := CallRequest{
+		TargetURL:  upstream.URL + "/oauth/token",
+		Injections: []Injection{{Style: "query", Target: "pin", SecretKey: "PIN"}},
+		Captures:   []Capture{{Path: "$.access_token", SecretKey: "SESSION"}},
+	}
+
+	engine, _ := redirectEngine(t)
+	engine.ResolveSecret = mockResolver(map[string]string{"PIN": "a&b"})
+	stored := map[string]string{}
+	engine.StoreSecret = func(key, value string) error {
+		stored[key] = value
+		return nil
+	}
+	if err := engine.Record(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Execute(req); err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if stored["SESSION"] != "tok_live_9f8e7d" {
+		t.Fatalf("captured %q", stored["SESSION"])
+	}
+
+	host := strings.Split(strings.TrimPrefix(upstream.URL, "http://"), ":")[0]
+	data, err := os.ReadFile(filepath.Join(dir, host+".har"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{"tok_live_9f8e7d", "a&b", "a%26b"} {
+		if strings.Contains(string(data), leak) {
+			t.Errorf("recording contains %q:\n%s", leak, data)
+		}
+	}
+	for _, want := range []string{`pin={{AS:PIN}}`, `\"access_token\":\"{{AS:SESSION}}\"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("recording is missing %s:\n%s", want, data)
+		}
+	}
+
+	// Replay runs without the keyring: PIN is not there, so its marker is sent,
+	// and the capture stores the recorded marker
+	engine, _ = redirectEngine(t)
+	engine.ResolveSecret = mockResolver(map[string]string{})
+	engine.StoreSecret = func(key, value string) error {
+		stored[key] = value
+		return nil
+	}
+	if err := engine.Replay(dir); err != nil {
+		t.Fatal(err)
+	}
+	result, err := engine.Execute(req)
+	if err != nil {
+		t.Fatalf("replay Execute() error: %v", err)
+	}
+	if result.StatusCode != 200 || stored["SESSION"] != "{{AS:SESSION}}" {
+		t.Errorf("replay = %d, captured %q", result.StatusCode, stored["SESSION"])
+	}
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pathSegment is one step of a parsed JSON path: either an object key or an array index.
+type pathSegment struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// parseJSONPath parses a simple JSON path into segments.
+//
+// Supported forms:
+//
+//	$.access_token
+//	$.data.session.token
+//	$.items[0].id
+//	$["x-api-token"]
+//	data.token          (leading "$." is optional)
+func parseJSONPath(path string) ([]pathSegment, error) {
+	p := strings.TrimSpace(path)
+	p = strings.TrimPrefix(p, "$")
+	if p == "" {
+		return nil, fmt.Errorf("JSON path %q selects the whole document", path)
+	}
+
+	var segments []pathSegment
+	for i := 0; i < len(p); {
+		switch p[i] {
+		case '.':
+			i++
+			start := i
+			for i < len(p) && p[i] != '.' && p[i] != '[' {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", path)
+			}
+			segments = append(segments, pathSegment{Key: p[start:i]})
+		case '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("invalid JSON path %q: missing ]", path)
+			}
+			inner := p[i+1 : i+end]
+			i += end + 1
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				segments = append(segments, pathSegment{Key: inner[1 : len(inner)-1]})
+				continue
+			}
+			idx, err := strconv.Atoi(inner)
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: bad index %q", path, inner)
+			}
+			segments = append(segments, pathSegment{Index: idx, IsIndex: true})
+		default:
+			// Bare leading key without "$." e.g. "data.token"
+			if len(segments) > 0 {
+				return nil, fmt.Errorf("invalid JSON path %q", path)
+			}
+			p = "." + p[i:]
+			i = 0
+		}
+	}
+	return segments, nil
+}
+
+// lookupJSONPath walks a decoded JSON document and returns the value at path.
+func lookupJSONPath(doc interface{}, segments []pathSegment) (interface{}, bool) {
+	current := doc
+	for _, seg := range segments {
+		if seg.IsIndex {
+			arr, ok := current.([]interface{})
+			if !ok || seg.Index >= len(arr) {
+				return nil, false
+			}
+			current = arr[seg.Index]
+			continue
+		}
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = obj[seg.Key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
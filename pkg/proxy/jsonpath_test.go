@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestLookupJSONPath(t *testing.T) {
+	var doc interface{}
+	_ = json.Unmarshal([]byte(`{"data": {"items": [{"id": "a"}, {"id": "b"}], "x-token": "t"}}`), &doc)
+
+	tests := []struct {
+		path    string
+		want    interface{}
+		wantOK  bool
+		wantErr bool
+	}{
+		{path: "$.data.items[1].id", want: "b", wantOK: true},
+		{path: "data.items[0].id", want: "a", wantOK: true},
+		{path: `$.data["x-token"]`, want: "t", wantOK: true},
+		{path: "$.data.missing", wantOK: false},
+		{path: "$.data.items[5]", wantOK: false},
+		{path: "$", wantErr: true},
+		{path: "$.data[abc]", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			segments, err := parseJSONPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJSONPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got, ok := lookupJSONPath(doc, segments)
+			if ok != tt.wantOK {
+				t.Fatalf("lookupJSONPath(%q) ok = %v, want %v", tt.path, ok, tt.wantOK)
+			}
+			if ok && got != tt.want {
+				t.Errorf("lookupJSONPath(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
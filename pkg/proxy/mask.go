@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// RedactedPlaceholder replaces secret values in anything shown to an agent.
+const RedactedPlaceholder = "[REDACTED_BY_AGENTSECRETS]"
+
+// minMaskLength is the shortest value MaskingWriter will mask. Shorter values
+// ("1", "true", "dev") would match ordinary output everywhere.
+const minMaskLength = 4
+
+// SecretVariants returns the encodings a secret is commonly printed in:
+// the raw value, base64 (standard, unpadded and URL-safe), URL-encoded,
+// JSON-escaped and hex.
+func SecretVariants(value string) []string {
+	if value == "" {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var variants []string
+	add := func(v string) {
+		if len(v) >= minMaskLength && !seen[v] {
+			seen[v] = true
+			variants = append(variants, v)
+		}
+	}
+
+	add(value)
+	add(base64.StdEncoding.EncodeToString([]byte(value)))
+	add(base64.RawStdEncoding.EncodeToString([]byte(value)))
+	add(base64.URLEncoding.EncodeToString([]byte(value)))
+	add(url.QueryEscape(value))
+	if escaped, err := json.Marshal(value); err == nil {
+		add(string(escaped[1 : len(escaped)-1]))
+	}
+	add(hex.EncodeToString([]byte(value)))
+	return variants
+}
+
+// injectedForms returns how inj puts value on the wire: the exact encoding
+// the injector applies, then the raw value. Unlike SecretVariants it has no
+// minimum length, since it is used on requests known to carry the value.
+func injectedForms(inj Injection, value string) []string {
+	if value == "" {
+		return nil
+	}
+	var forms []string
+	switch inj.Style {
+	case "basic":
+		forms = append(forms, base64.StdEncoding.EncodeToString([]byte(value)))
+	case "query", "form":
+		forms = append(forms, url.QueryEscape(value))
+	case "body":
+		if escaped, err := json.Marshal(value); err == nil {
+			forms = append(forms, string(escaped[1:len(escaped)-1]))
+		}
+	}
+	return append(forms, value)
+}
+
+type maskPattern struct {
+	value []byte
+	key   string
+}
+
+// MaskingWriter streams output to an underlying writer with every secret value
+// (and its common encodings) replaced by RedactedPlaceholder.
+//
+// A value may be split across two writes, so the writer holds back the shortest
+// tail that could still turn into a match and emits everything else immediately.
+type MaskingWriter struct {
+	w        io.Writer
+	patterns []maskPattern
+	unmasked []string
+	buf      []byte
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewMaskingWriter creates a writer that masks the given secrets (key name → value).
+func NewMaskingWriter(w io.Writer, secrets map[string]string) *MaskingWriter {
+	m := &MaskingWriter{w: w, counts: make(map[string]int)}
+	for key, value := range secrets {
+		if value != "" && len(value) < minMaskLength {
+			m.unmasked = append(m.unmasked, key)
+		}
+		for _, v := range SecretVariants(value) {
+			m.patterns = append(m.patterns, maskPattern{value: []byte(v), key: key})
+		}
+	}
+	sort.Strings(m.unmasked)
+	// Longest first, so a value wins over any shorter value it contains.
+	sort.Slice(m.patterns, func(i, j int) bool {
+		return len(m.patterns[i].value) > len(m.patterns[j].value)
+	})
+	return m
+}
+
+// Write masks p and forwards the result. It always reports len(p) on success.
+func (m *MaskingWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.patterns) == 0 {
+		return m.w.Write(p)
+	}
+
+	m.buf = append(m.buf, p...)
+	var out bytes.Buffer
+	for {
+		idx, pat := m.nextMatch()
+		if pat == nil {
+			break
+		}
+		out.Write(m.buf[:idx])
+		out.WriteString(RedactedPlaceholder)
+		m.counts[pat.key]++
+		m.buf = m.buf[idx+len(pat.value):]
+	}
+
+	hold := m.pendingPrefix()
+	out.Write(m.buf[:len(m.buf)-hold])
+	m.buf = append([]byte(nil), m.buf[len(m.buf)-hold:]...)
+
+	if out.Len() > 0 {
+		if _, err := m.w.Write(out.Bytes()); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes any held-back bytes. Call it once the stream has ended.
+func (m *MaskingWriter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.buf) == 0 {
+		return nil
+	}
+	_, err := m.w.Write(m.buf)
+	m.buf = nil
+	return err
+}
+
+// Counts returns how many times each key name was masked.
+func (m *MaskingWriter) Counts() map[string]int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	counts := make(map[string]int, len(m.counts))
+	for k, v := range m.counts {
+		counts[k] = v
+	}
+	return counts
+}
+
+// Unmasked returns the key names whose values are too short to mask. Those
+// values reach the output as they are.
+func (m *MaskingWriter) Unmasked() []string {
+	return append([]string(nil), m.unmasked...)
+}
+
+// nextMatch finds the earliest complete match in buf.
+func (m *MaskingWriter) nextMatch() (int, *maskPattern) {
+	best := -1
+	var bestPat *maskPattern
+	for i := range m.patterns {
+		idx := bytes.Index(m.buf, m.patterns[i].value)
+		if idx != -1 && (best == -1 || idx < best) {
+			best, bestPat = idx, &m.patterns[i]
+		}
+	}
+	return best, bestPat
+}
+
+// pendingPrefix returns the length of the longest suffix of buf that is a
+// proper prefix of some pattern, i.e. bytes that might still become a match.
+func (m *MaskingWriter) pendingPrefix() int {
+	longest := 0
+	for _, pat := range m.patterns {
+		max := len(pat.value) - 1
+		if max > len(m.buf) {
+			max = len(m.buf)
+		}
+		for n := max; n > longest; n-- {
+			if bytes.Equal(m.buf[len(m.buf)-n:], pat.value[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
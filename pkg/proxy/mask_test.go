@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestMaskingWriterMasksSplitWrites(t *testing.T) {
+	secret := "sk_live_MASK_ME_123"
+	var out bytes.Buffer
+	m := NewMaskingWriter(&out, map[string]string{"STRIPE_KEY": secret})
+
+	// Split the value across writes to exercise the held-back tail.
+	input := "STRIPE_KEY=" + secret + "\n"
+	for i := 0; i < len(input); i += 5 {
+		end := i + 5
+		if end > len(input) {
+			end = len(input)
+		}
+		if _, err := m.Write([]byte(input[i:end])); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatalf("Flush() error: %v", err)
+	}
+
+	got := out.String()
+	if strings.Contains(got, secret) {
+		t.Fatal("SECURITY: secret VALUE was written through the masking writer!")
+	}
+	if got != "STRIPE_KEY="+RedactedPlaceholder+"\n" {
+		t.Errorf("output = %q", got)
+	}
+	if m.Counts()["STRIPE_KEY"] != 1 {
+		t.Errorf("Counts() = %v, want STRIPE_KEY:1", m.Counts())
+	}
+}
+
+func TestMaskingWriterMasksEncodings(t *testing.T) {
+	secret := "p@ss word/123"
+	var out bytes.Buffer
+	m := NewMaskingWriter(&out, map[string]string{"DB_PASSWORD": secret})
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(secret))
+	m.Write([]byte("b64=" + encoded + " url=p%40ss+word%2F123\n"))
+	m.Flush()
+
+	got := out.String()
+	if strings.Contains(got, encoded) || strings.Contains(got, "p%40ss+word%2F123") {
+		t.Errorf("encoded secret leaked: %q", got)
+	}
+}
+
+func TestMaskingWriterPassesThroughUnrelatedOutput(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMaskingWriter(&out, map[string]string{"KEY": "abcdef123"})
+
+	// "abc" could still become the secret, so only it is held back.
+	m.Write([]byte("hello abc"))
+	if out.String() != "hello " {
+		t.Errorf("output before flush = %q, want %q", out.String(), "hello ")
+	}
+	m.Flush()
+	if out.String() != "hello abc" {
+		t.Errorf("output after flush = %q, want %q", out.String(), "hello abc")
+	}
+}
+
+func TestMaskingWriterReportsUnmaskedKeys(t *testing.T) {
+	var out bytes.Buffer
+	m := NewMaskingWriter(&out, map[string]string{"PIN": "123", "DEBUG": "1", "EMPTY": "", "KEY": "abcdef123"})
+	if got := strings.Join(m.Unmasked(), ","); got != "DEBUG,PIN" {
+		t.Errorf("Unmasked() = %q, want DEBUG,PIN", got)
+	}
+
+	// They really are left alone
+	m.Write([]byte("pin=123"))
+	m.Flush()
+	if out.String() != "pin=123" {
+		t.Errorf("output = %q", out.String())
+	}
+}
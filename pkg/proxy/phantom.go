@@ -0,0 +1,550 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// phantomPrefixRegex matches vendor prefixes such as "sk_live_", "ghp_" or "xoxb-"
+// that CLIs use to recognise a token's type. They are kept as-is in phantom tokens.
+var phantomPrefixRegex = regexp.MustCompile(`^(?:[A-Za-z]{1,8}[_-])+`)
+
+const (
+	phantomDigits = "0123456789"
+	phantomLower  = "abcdefghijklmnopqrstuvwxyz"
+	phantomUpper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+)
+
+// phantomMinEntropyBits is the least information the randomized part of a
+// value must carry. Below it, the value or its token could turn up by chance
+// in the text the proxy swaps them in.
+const phantomMinEntropyBits = 24
+
+// ErrPhantomWeak is returned for values too short or too uniform to swap safely.
+var ErrPhantomWeak = errors.New("value is too short or too uniform for a phantom token")
+
+// PhantomToken returns a random placeholder with the same shape as value:
+// the vendor prefix and punctuation are kept, and every letter or digit is
+// replaced by a random character of the same class. A value with no ASCII
+// letter or digit outside its prefix has nothing to replace and is an error;
+// a value shorter than minMaskLength or with little entropy is ErrPhantomWeak.
+func PhantomToken(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+
+	prefix := phantomPrefixRegex.FindString(value)
+	if len(prefix) == len(value) {
+		prefix = ""
+	}
+	if !strings.ContainsAny(value[len(prefix):], phantomDigits+phantomLower+phantomUpper) {
+		return "", fmt.Errorf("value has no ASCII letters or digits to randomize")
+	}
+	if len(value) < minMaskLength || entropyBits(value[len(prefix):]) < phantomMinEntropyBits {
+		return "", ErrPhantomWeak
+	}
+
+	for {
+		var sb strings.Builder
+		sb.WriteString(prefix)
+		for _, r := range value[len(prefix):] {
+			var charset string
+			switch {
+			case r >= '0' && r <= '9':
+				charset = phantomDigits
+			case r >= 'a' && r <= 'z':
+				charset = phantomLower
+			case r >= 'A' && r <= 'Z':
+				charset = phantomUpper
+			default:
+				sb.WriteRune(r)
+				continue
+			}
+			n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charset))))
+			if err != nil {
+				return "", fmt.Errorf("generate phantom token: %w", err)
+			}
+			sb.WriteByte(charset[n.Int64()])
+		}
+
+		token := sb.String()
+		if token != value {
+			return token, nil
+		}
+	}
+}
+
+// entropyBits estimates the information in s from its character frequencies.
+func entropyBits(s string) float64 {
+	counts := make(map[rune]int)
+	n := 0
+	for _, r := range s {
+		counts[r]++
+		n++
+	}
+	bits := 0.0
+	for _, c := range counts {
+		p := float64(c) / float64(n)
+		bits -= float64(c) * math.Log2(p)
+	}
+	return bits
+}
+
+// phantomSecret pairs a secret's key name with its real value.
+type phantomSecret struct {
+	Key   string
+	Value string
+}
+
+// PhantomProxy is an ephemeral forward proxy used by `agentsecrets env --phantom`.
+//
+// The child process only ever sees phantom tokens. Requests it sends through this
+// proxy to allowlisted domains have phantom tokens swapped for real values on the
+// way out, and real values swapped back to phantom tokens on the way in. Traffic to
+// any other host is tunnelled untouched, so real values never leave for it.
+type PhantomProxy struct {
+	Engine  *Engine
+	AgentID string
+
+	tokens   map[string]phantomSecret // phantom token → real secret
+	ca       *certAuthority
+	listener net.Listener
+	server   *http.Server
+}
+
+// NewPhantomProxy creates a phantom proxy for the given secrets.
+// It returns the proxy and the phantom token to expose for each key name.
+// A value too weak for a token (ErrPhantomWeak) is exposed as it is, with a
+// warning, since swapping it would rewrite unrelated text.
+func NewPhantomProxy(engine *Engine, secrets map[string]string) (*PhantomProxy, map[string]string, error) {
+	ca, err := newCertAuthority()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &PhantomProxy{
+		Engine:  engine,
+		AgentID: "env",
+		tokens:  make(map[string]phantomSecret),
+		ca:      ca,
+	}
+
+	placeholders := make(map[string]string, len(secrets))
+	for key, value := range secrets {
+		token, err := PhantomToken(value)
+		if errors.Is(err, ErrPhantomWeak) {
+			fmt.Fprintf(os.Stderr, "Warning: %s is too short or too uniform for a phantom token; the child gets its real value\n", key)
+			placeholders[key] = value
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot make a phantom token for %s: %w", key, err)
+		}
+		placeholders[key] = token
+		if token != "" {
+			p.tokens[token] = phantomSecret{Key: key, Value: value}
+		}
+	}
+
+	return p, placeholders, nil
+}
+
+// CACertPEM returns the PEM-encoded certificate of the proxy's ephemeral CA.
+// Child processes must trust it to talk TLS to allowlisted hosts through the proxy.
+func (p *PhantomProxy) CACertPEM() []byte {
+	return p.ca.certPEM
+}
+
+// Start listens on a random loopback port and serves in the background.
+// It returns the proxy URL to use for HTTP_PROXY / HTTPS_PROXY.
+func (p *PhantomProxy) Start() (string, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("start phantom proxy: %w", err)
+	}
+	p.listener = ln
+	p.server = &http.Server{Handler: p}
+	go p.server.Serve(ln)
+	return "http://" + ln.Addr().String(), nil
+}
+
+// Close stops the proxy.
+func (p *PhantomProxy) Close() error {
+	if p.server != nil {
+		return p.server.Close()
+	}
+	return nil
+}
+
+// ServeHTTP handles both CONNECT tunnels (HTTPS) and absolute-URL requests (plain HTTP).
+func (p *PhantomProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.handleConnect(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() {
+		writeError(w, 400, "phantom proxy only accepts proxy requests")
+		return
+	}
+
+	resp := p.roundTrip(r, p.allowed(r.URL.Hostname()))
+	defer resp.Body.Close()
+	for k, vals := range resp.Header {
+		for _, v := range vals {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// allowed reports whether real values may be sent to host.
+func (p *PhantomProxy) allowed(host string) bool {
+	reason, _, err := p.Engine.CheckAllowlist(host)
+	return err == nil && reason == ""
+}
+
+func (p *PhantomProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		writeError(w, 500, "connection hijacking not supported")
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer clientConn.Close()
+
+	host := r.URL.Hostname()
+	if host == "" {
+		host, _, _ = net.SplitHostPort(r.Host)
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	if !p.allowed(host) {
+		p.tunnel(clientConn, r.Host)
+		return
+	}
+
+	leaf, err := p.ca.leafFor(host)
+	if err != nil {
+		return
+	}
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		Certificates: []tls.Certificate{*leaf},
+		NextProtos:   []string{"http/1.1"},
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		return
+	}
+	defer tlsConn.Close()
+
+	reader := bufio.NewReader(tlsConn)
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		req.URL.Scheme = "https"
+		req.URL.Host = r.Host
+
+		resp := p.roundTrip(req, true)
+		err = resp.Write(tlsConn)
+		resp.Body.Close()
+		if err != nil || req.Close || resp.Close {
+			return
+		}
+	}
+}
+
+// tunnel relays bytes between the client and target without inspecting them.
+func (p *PhantomProxy) tunnel(clientConn net.Conn, target string) {
+	upstream, err := net.DialTimeout("tcp", target, DefaultTimeout)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(upstream, clientConn)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+// roundTrip forwards one request upstream. When swap is true, phantom tokens of
+// the keys the policy grants for this request are replaced by real values, and
+// real values in the response are replaced by phantom tokens. Requests that use
+// a real value count against LLM budgets like engine calls do.
+func (p *PhantomProxy) roundTrip(r *http.Request, swap bool) *http.Response {
+	auditURL := r.URL.String()
+	domain := strings.ToLower(r.URL.Hostname())
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return phantomErrorResponse(r, 400, "failed to read request body")
+	}
+
+	outbound, err := http.NewRequest(r.Method, r.URL.String(), nil)
+	if err != nil {
+		return phantomErrorResponse(r, 400, "invalid request")
+	}
+	outbound.Header = r.Header.Clone()
+	outbound.Header.Del("Proxy-Connection")
+	outbound.Header.Del("Proxy-Authorization")
+	// Let the transport negotiate compression so bodies can be inspected.
+	outbound.Header.Del("Accept-Encoding")
+
+	used := make(map[string]bool)
+	if swap {
+		tokens := p.boundTokens(r, int64(len(body)))
+		for k, vals := range outbound.Header {
+			for i, v := range vals {
+				vals[i] = swapHeaderValue(k, v, tokens, used)
+			}
+		}
+		outbound.URL.Path = swapOut(outbound.URL.Path, tokens, used)
+		outbound.URL.RawPath = swapOut(outbound.URL.RawPath, tokens, used)
+		outbound.URL.RawQuery = swapOut(outbound.URL.RawQuery, tokens, used)
+		body = []byte(swapOut(string(body), tokens, used))
+	}
+	outbound.Body = io.NopCloser(bytes.NewReader(body))
+	outbound.ContentLength = int64(len(body))
+
+	keys := make([]string, 0, len(used))
+	for k := range used {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	call := &checkedCall{
+		req:        CallRequest{Method: r.Method, TargetURL: auditURL, AgentID: p.AgentID},
+		method:     r.Method,
+		domain:     domain,
+		secretKeys: keys,
+		authStyles: []string{"phantom"},
+	}
+	if len(used) > 0 {
+		if msg, err := p.Engine.checkBudget(call); err != nil {
+			return phantomErrorResponse(r, 500, err.Error())
+		} else if msg != "" {
+			return phantomBlockedResponse(r, p.Engine.block(call, "budget_exceeded", msg))
+		}
+	}
+
+	start := time.Now()
+	resp, err := p.transport().RoundTrip(outbound)
+	if err != nil {
+		return phantomErrorResponse(r, 502, fmt.Sprintf("failed to reach upstream: %v", err))
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return phantomErrorResponse(r, 502, "failed to read upstream response")
+	}
+
+	var usage *Usage
+	if len(used) > 0 && p.Engine.Budget.IsProvider(domain) && resp.StatusCode < 300 {
+		usage = parseUsage(respBody, p.Engine.Budget.prices())
+	}
+
+	redacted := false
+	if swap {
+		for token, secret := range p.tokens {
+			if swapped, ok := replaceWhole(string(respBody), secret.Value, token); ok {
+				respBody = []byte(swapped)
+				redacted = true
+			}
+			for _, vals := range resp.Header {
+				for i, v := range vals {
+					vals[i], _ = replaceWhole(v, secret.Value, token)
+				}
+			}
+		}
+	}
+
+	if len(used) > 0 && p.Engine.Audit != nil {
+		_ = p.Engine.Audit.Log(AuditEvent{
+			Timestamp:  time.Now().UTC(),
+			SecretKeys: call.secretKeys,
+			AgentID:    p.AgentID,
+			Method:     r.Method,
+			TargetURL:  auditURL,
+			Domain:     domain,
+			AuthStyles: call.authStyles,
+			StatusCode: resp.StatusCode,
+			DurationMs: time.Since(start).Milliseconds(),
+			Status:     "OK",
+			Reason:     "-",
+			Redacted:   redacted,
+			Usage:      usage,
+		})
+	}
+
+	header := resp.Header.Clone()
+	header.Del("Content-Length")
+	header.Del("Transfer-Encoding")
+	return &http.Response{
+		StatusCode:    resp.StatusCode,
+		Status:        resp.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       r,
+	}
+}
+
+// boundTokens returns the phantom tokens whose keys may be sent with r: all of
+// them without a policy, otherwise those the policy allows this agent to use
+// for r without an approval, which the child could not wait for.
+func (p *PhantomProxy) boundTokens(r *http.Request, bodySize int64) map[string]phantomSecret {
+	if p.Engine.Policy == nil {
+		return p.tokens
+	}
+	bound := make(map[string]phantomSecret)
+	for token, secret := range p.tokens {
+		decision := p.Engine.Policy.Evaluate(PolicyRequest{
+			AgentID:    p.AgentID,
+			Method:     r.Method,
+			TargetURL:  r.URL.String(),
+			SecretKeys: []string{secret.Key},
+			BodySize:   bodySize,
+		})
+		if decision.Allowed && !decision.RequireApproval {
+			bound[token] = secret
+		}
+	}
+	return bound
+}
+
+// swapOut replaces every phantom token in s with its real value.
+func swapOut(s string, tokens map[string]phantomSecret, used map[string]bool) string {
+	if s == "" {
+		return s
+	}
+	for token, secret := range tokens {
+		if swapped, ok := replaceWhole(s, token, secret.Value); ok {
+			s = swapped
+			used[secret.Key] = true
+		}
+	}
+	return s
+}
+
+// swapHeaderValue is swapOut plus handling for Basic auth, where the token is base64-encoded.
+func swapHeaderValue(name, value string, tokens map[string]phantomSecret, used map[string]bool) string {
+	if strings.EqualFold(name, "Authorization") && strings.HasPrefix(value, "Basic ") {
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "Basic ")); err == nil {
+			swapped := swapOut(string(decoded), tokens, used)
+			if swapped != string(decoded) {
+				return "Basic " + base64.StdEncoding.EncodeToString([]byte(swapped))
+			}
+		}
+	}
+	return swapOut(value, tokens, used)
+}
+
+// replaceWhole replaces old in s only where it is not part of a longer word,
+// so a value never matches inside unrelated text. It reports whether it
+// replaced anything.
+func replaceWhole(s, old, new string) (string, bool) {
+	if old == "" {
+		return s, false
+	}
+	var sb strings.Builder
+	written, from := 0, 0
+	for {
+		i := strings.Index(s[from:], old)
+		if i < 0 {
+			break
+		}
+		i += from
+		end := i + len(old)
+		before, _ := utf8.DecodeLastRuneInString(s[:i])
+		after, _ := utf8.DecodeRuneInString(s[end:])
+		if i > 0 && isWordRune(before) || end < len(s) && isWordRune(after) {
+			from = i + 1
+			continue
+		}
+		sb.WriteString(s[written:i])
+		sb.WriteString(new)
+		written, from = end, end
+	}
+	if written == 0 {
+		return s, false
+	}
+	sb.WriteString(s[written:])
+	return sb.String(), true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (p *PhantomProxy) transport() http.RoundTripper {
+	if p.Engine.Client != nil && p.Engine.Client.Transport != nil {
+		return p.Engine.Client.Transport
+	}
+	return http.DefaultTransport
+}
+
+// phantomBlockedResponse hands a call the engine blocked back to the child.
+func phantomBlockedResponse(r *http.Request, result *CallResult) *http.Response {
+	return &http.Response{
+		StatusCode:    result.StatusCode,
+		Status:        fmt.Sprintf("%d %s", result.StatusCode, http.StatusText(result.StatusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header(result.Headers),
+		Body:          io.NopCloser(bytes.NewReader(result.Body)),
+		ContentLength: int64(len(result.Body)),
+		Request:       r,
+	}
+}
+
+func phantomErrorResponse(r *http.Request, statusCode int, message string) *http.Response {
+	body := fmt.Sprintf(`{"error":%q}`, message)
+	return &http.Response{
+		StatusCode:    statusCode,
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       r,
+	}
+}
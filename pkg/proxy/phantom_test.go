@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPhantomTokenKeepsShape(t *testing.T) {
+	real := "sk_live_51HxYz09AbCdEf"
+	token, err := PhantomToken(real)
+	if err != nil {
+		t.Fatalf("PhantomToken() error: %v", err)
+	}
+	if token == real {
+		t.Fatal("phantom token must differ from the real value")
+	}
+	if len(token) != len(real) {
+		t.Errorf("len = %d, want %d", len(token), len(real))
+	}
+	if !strings.HasPrefix(token, "sk_live_") {
+		t.Errorf("token %q lost the vendor prefix", token)
+	}
+	for i := range real {
+		r, p := real[i], token[i]
+		sameClass := (r >= '0' && r <= '9') == (p >= '0' && p <= '9') &&
+			(r >= 'a' && r <= 'z') == (p >= 'a' && p <= 'z') &&
+			(r >= 'A' && r <= 'Z') == (p >= 'A' && p <= 'Z')
+		if !sameClass {
+			t.Errorf("char %d: %q and %q are not the same class", i, r, p)
+		}
+	}
+}
+
+func TestPhantomTokenWithoutRandomizableCharacters(t *testing.T) {
+	for _, value := range []string{"----", "пароль", "ab_-", "sk_live_!!"} {
+		if token, err := PhantomToken(value); err == nil {
+			t.Errorf("PhantomToken(%q) = %q, want an error", value, token)
+		}
+	}
+}
+
+func TestPhantomTokenRefusesWeakValues(t *testing.T) {
+	for _, value := range []string{"7", "abc", "aaaaaaaaaaaa", "123456", "sk_live_0000"} {
+		if token, err := PhantomToken(value); !errors.Is(err, ErrPhantomWeak) {
+			t.Errorf("PhantomToken(%q) = %q, %v, want ErrPhantomWeak", value, token, err)
+		}
+	}
+}
+
+func TestReplaceWhole(t *testing.T) {
+	for _, tc := range []struct {
+		s, want string
+		ok      bool
+	}{
+		{"Bearer ghp_Ab12", "Bearer REAL", true},
+		{"token=ghp_Ab12&x=1", "token=REAL&x=1", true},
+		{`{"a":"ghp_Ab12","b":"ghp_Ab12"}`, `{"a":"REAL","b":"REAL"}`, true},
+		{"xghp_Ab12 ghp_Ab12y", "xghp_Ab12 ghp_Ab12y", false},
+		{"xghp_Ab12 ghp_Ab12", "xghp_Ab12 REAL", true},
+		{"ghp_Ab12ghp_Ab12", "ghp_Ab12ghp_Ab12", false},
+	} {
+		if got, ok := replaceWhole(tc.s, "ghp_Ab12", "REAL"); got != tc.want || ok != tc.ok {
+			t.Errorf("replaceWhole(%q) = %q, %v, want %q, %v", tc.s, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestPhantomProxySwapsOnlyBoundKeys(t *testing.T) {
+	var gotStripe, gotGithub string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotStripe, gotGithub = r.Header.Get("X-Stripe"), r.Header.Get("X-Github")
+		fmt.Fprintf(w, "%s|%s", gotStripe, gotGithub)
+	}))
+	defer upstream.Close()
+
+	secrets := map[string]string{
+		"STRIPE_KEY":   "sk_test_9f8E7d6C5b4A",
+		"GITHUB_TOKEN": "ghp_Q1w2E3r4T5y6U7i8",
+		"PIN":          "1234",
+	}
+	engine := &Engine{
+		ProjectID:     "test-project",
+		SkipAllowlist: true,
+		Policy: &Policy{Agents: map[string]*AgentPolicy{
+			"env": {Rules: []PolicyRule{{Secrets: []string{"STRIPE_KEY"}, Domains: []string{"127.0.0.1"}}}},
+		}},
+	}
+	p, placeholders, err := NewPhantomProxy(engine, secrets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Too weak for a token: passed through as it is
+	if placeholders["PIN"] != "1234" {
+		t.Errorf("PIN placeholder = %q, want the value itself", placeholders["PIN"])
+	}
+	proxyURL, err := p.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	pu, _ := url.Parse(proxyURL)
+	child := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(pu)}}
+
+	req, _ := http.NewRequest("GET", upstream.URL, nil)
+	req.Header.Set("X-Stripe", placeholders["STRIPE_KEY"])
+	req.Header.Set("X-Github", placeholders["GITHUB_TOKEN"])
+	resp, err := child.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	// Only the Stripe key is granted here; the GitHub token stays a placeholder
+	if gotStripe != secrets["STRIPE_KEY"] || gotGithub != placeholders["GITHUB_TOKEN"] {
+		t.Errorf("upstream got %q and %q", gotStripe, gotGithub)
+	}
+	// The echoed real value is swapped back on the way in
+	if want := placeholders["STRIPE_KEY"] + "|" + placeholders["GITHUB_TOKEN"]; string(body) != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestPhantomProxySwapsForAllowedHost(t *testing.T) {
+	realKey := "ghp_REALTOKENabc123"
+
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer "+realKey {
+			t.Errorf("upstream Authorization = %q, want real value", got)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"echo":"` + realKey + `"}`))
+	}))
+	defer upstream.Close()
+
+	engine := &Engine{
+		ProjectID:     "test-project",
+		Client:        upstream.Client(),
+		SkipAllowlist: true,
+	}
+
+	p, placeholders, err := NewPhantomProxy(engine, map[string]string{"GITHUB_TOKEN": realKey})
+	if err != nil {
+		t.Fatalf("NewPhantomProxy() error: %v", err)
+	}
+	proxyURL, err := p.Start()
+	if err != nil {
+		t.Fatalf("Start() error: %v", err)
+	}
+	defer p.Close()
+
+	phantom := placeholders["GITHUB_TOKEN"]
+	if phantom == "" || phantom == realKey {
+		t.Fatalf("bad phantom token %q", phantom)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(p.CACertPEM())
+	pu, _ := url.Parse(proxyURL)
+	child := &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyURL(pu),
+		TLSClientConfig: &tls.Config{RootCAs: roots},
+	}}
+
+	req, _ := http.NewRequest("GET", upstream.URL+"/user", nil)
+	req.Header.Set("Authorization", "Bearer "+phantom)
+	resp, err := child.Do(req)
+	if err != nil {
+		t.Fatalf("request through phantom proxy failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if strings.Contains(string(body), realKey) {
+		t.Fatal("SECURITY: real value reached the child process")
+	}
+	if !strings.Contains(string(body), phantom) {
+		t.Errorf("body = %s, expected echoed value to be swapped back to the phantom token", body)
+	}
+}
@@ -0,0 +1,476 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/The-17/agentsecrets/pkg/proxyapi"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPolicyPath is the per-project policy file, relative to the project root.
+var DefaultPolicyPath = filepath.Join(".agentsecrets", "policy.yaml")
+
+// AgentTokenEnv is the environment variable the CLI and MCP server read an
+// agent's proxy token from. HTTP callers send it as X-AS-Agent-Token.
+const AgentTokenEnv = proxyapi.AgentTokenEnv
+
+// Policy grants each agent identity what it may do through the proxy.
+//
+//	default: deny            # agents not listed (and no "*" entry); "allow" if omitted
+//	agents:
+//	  billing-bot:
+//	    token_sha256: 9f86d0...   # optional: identity must be proven with X-AS-Agent-Token
+//	    rules:
+//	      - secrets: [STRIPE_*]
+//	        domains: [api.stripe.com]
+//	        methods: [GET, POST]
+//	        paths: [/v1/charges*, /v1/customers*]
+//	        max_body_bytes: 65536
+//	        days: [mon, tue, wed, thu, fri]
+//	        hours: ["09:00-18:00"]
+//	        timezone: Europe/Berlin
+//	  "*":
+//	    rules:
+//	      - methods: [GET]
+//	approvals:                   # allowed requests that a human must still confirm
+//	  - methods: [POST, DELETE]
+//	    domains: [api.stripe.com]
+//	  - new_domain: true
+//
+// A request is allowed when any one rule of its agent permits it. An empty
+// field in a rule places no restriction. Patterns use * as a wildcard.
+type Policy struct {
+	Default   string                  `yaml:"default,omitempty"`
+	Agents    map[string]*AgentPolicy `yaml:"agents,omitempty"`
+	Approvals []ApprovalRule          `yaml:"approvals,omitempty"`
+}
+
+// AgentPolicy is the grant for one agent identity.
+type AgentPolicy struct {
+	TokenSHA256 string       `yaml:"token_sha256,omitempty"` // hex SHA-256 of the agent's proxy token
+	Rules       []PolicyRule `yaml:"rules"`
+}
+
+// PolicyRule is one set of permissions. All of its conditions must hold.
+type PolicyRule struct {
+	Secrets      []string `yaml:"secrets,omitempty"`        // secret key names
+	Domains      []string `yaml:"domains,omitempty"`        // e.g. api.stripe.com, *.github.com
+	Methods      []string `yaml:"methods,omitempty"`        // e.g. GET, POST
+	Paths        []string `yaml:"paths,omitempty"`          // URL path patterns, * also matches /
+	MaxBodyBytes int64    `yaml:"max_body_bytes,omitempty"` // 0 means no limit
+	Days         []string `yaml:"days,omitempty"`           // mon … sun
+	Hours        []string `yaml:"hours,omitempty"`          // "HH:MM-HH:MM"; may wrap midnight
+	Timezone     string   `yaml:"timezone,omitempty"`       // IANA name; local time if empty
+}
+
+// ApprovalRule holds matching requests for a human decision. All set fields
+// must match; NewDomain matches the first call to a domain the proxy hasn't
+// successfully called before.
+type ApprovalRule struct {
+	Agents    []string `yaml:"agents,omitempty"`
+	Secrets   []string `yaml:"secrets,omitempty"`
+	Domains   []string `yaml:"domains,omitempty"`
+	Methods   []string `yaml:"methods,omitempty"`
+	Paths     []string `yaml:"paths,omitempty"`
+	NewDomain bool     `yaml:"new_domain,omitempty"`
+}
+
+// PolicyRequest is what a policy is evaluated against.
+type PolicyRequest struct {
+	AgentID     string
+	AgentToken  string // optional proxy token presented by the caller
+	Method      string
+	TargetURL   string
+	SecretKeys  []string
+	CaptureKeys []string // keys the response would be captured into; granted like SecretKeys
+	BodySize    int64
+	Time        time.Time
+	NewDomain   bool // first call to this domain
+}
+
+// PolicyDecision is the outcome of evaluating a request.
+type PolicyDecision struct {
+	Allowed bool
+	Agent   string // resolved identity; a matching token wins over the claimed AgentID
+	Reason  string // audit reason when denied, e.g. "policy_domain_denied"
+	Message string // human-readable explanation
+	Rule    int    // 1-based index of the agent's rule that allowed the request; 0 if none was needed
+
+	// RequireApproval is set on allowed requests matching an approval rule;
+	// Message then says which.
+	RequireApproval bool
+}
+
+// LoadPolicy reads a policy file. A missing file yields nil (no policy).
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read policy: %w", err)
+	}
+
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("parse policy %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Validate checks values that would otherwise only fail at request time.
+func (p *Policy) Validate() error {
+	switch p.Default {
+	case "", "allow", "deny":
+	default:
+		return fmt.Errorf("default must be allow or deny, got %q", p.Default)
+	}
+	for name, agent := range p.Agents {
+		if agent == nil {
+			return fmt.Errorf("agent %q has no rules", name)
+		}
+		if agent.TokenSHA256 != "" {
+			if b, err := hex.DecodeString(agent.TokenSHA256); err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("agent %q: token_sha256 must be a hex SHA-256 digest", name)
+			}
+		}
+		for i, rule := range agent.Rules {
+			if _, err := rule.location(); err != nil {
+				return fmt.Errorf("agent %q rule %d: %w", name, i+1, err)
+			}
+			for _, d := range rule.Days {
+				if _, ok := weekdays[strings.ToLower(d)]; !ok {
+					return fmt.Errorf("agent %q rule %d: unknown day %q", name, i+1, d)
+				}
+			}
+			for _, h := range rule.Hours {
+				if _, _, err := parseWindow(h); err != nil {
+					return fmt.Errorf("agent %q rule %d: %w", name, i+1, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// HashAgentToken returns the token_sha256 value for a proxy token.
+func HashAgentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Evaluate decides whether the request may proceed.
+func (p *Policy) Evaluate(req PolicyRequest) PolicyDecision {
+	agentID, reason, msg := p.identify(req.AgentID, req.AgentToken)
+	if reason != "" {
+		return deny(agentID, reason, msg)
+	}
+
+	u, err := url.Parse(req.TargetURL)
+	if err != nil {
+		return deny(agentID, "policy_invalid_url", err.Error())
+	}
+	// Paths are matched as sent, but upstreams resolve dot segments:
+	// /v1/charges/../admin would match /v1/charges* and reach /admin.
+	if hasDotSegment(u.Path) {
+		return deny(agentID, "policy_path_traversal", fmt.Sprintf("path %s has . or .. segments", u.EscapedPath()))
+	}
+
+	agent, ok := p.Agents[agentID]
+	if !ok {
+		agent, ok = p.Agents["*"]
+	}
+	if !ok {
+		if p.Default == "deny" {
+			return deny(agentID, "policy_unknown_agent", fmt.Sprintf("agent %q is not granted anything by the policy", agentID))
+		}
+		return p.allow(agentID, req, u)
+	}
+	if len(agent.Rules) == 0 {
+		return deny(agentID, "policy_no_rules", fmt.Sprintf("agent %q has no rules", agentID))
+	}
+
+	when := req.Time
+	if when.IsZero() {
+		when = time.Now()
+	}
+
+	// Report the rule that got furthest: its reason is the most specific.
+	best := -1
+	var decision PolicyDecision
+	for i, rule := range agent.Rules {
+		stage, reason, msg := rule.check(req, u, when)
+		if reason == "" {
+			decision := p.allow(agentID, req, u)
+			decision.Rule = i + 1
+			return decision
+		}
+		if stage > best {
+			best = stage
+			decision = deny(agentID, reason, msg)
+		}
+	}
+	return decision
+}
+
+// hasDotSegment reports whether the decoded path has a . or .. segment, with
+// either slash as separator.
+func hasDotSegment(p string) bool {
+	for _, seg := range strings.FieldsFunc(p, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if seg == "." || seg == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// identify resolves who is calling. A token proves an identity; a bare
+// AgentID can't claim a token-protected one.
+func (p *Policy) identify(agentID, token string) (agent, reason, msg string) {
+	if token != "" {
+		name, ok := p.agentForToken(token)
+		if !ok {
+			return agentID, "policy_invalid_agent_token", "the presented agent token does not match any agent in the policy"
+		}
+		return name, "", ""
+	}
+	if a, ok := p.Agents[agentID]; ok && a.TokenSHA256 != "" {
+		return agentID, "policy_agent_unauthenticated", fmt.Sprintf("agent %q must authenticate with its proxy token (X-AS-Agent-Token)", agentID)
+	}
+	return agentID, "", ""
+}
+
+func (p *Policy) agentForToken(token string) (string, bool) {
+	hash := []byte(HashAgentToken(token))
+	for name, agent := range p.Agents {
+		if agent != nil && agent.TokenSHA256 != "" &&
+			subtle.ConstantTimeCompare(hash, []byte(strings.ToLower(agent.TokenSHA256))) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// allow returns an allowed decision, flagged for approval if an approval rule matches.
+func (p *Policy) allow(agentID string, req PolicyRequest, u *url.URL) PolicyDecision {
+	for _, rule := range p.Approvals {
+		if rule.matches(agentID, req, u) {
+			return PolicyDecision{Allowed: true, Agent: agentID, RequireApproval: true, Message: rule.describe()}
+		}
+	}
+	return PolicyDecision{Allowed: true, Agent: agentID}
+}
+
+func (r *ApprovalRule) matches(agentID string, req PolicyRequest, u *url.URL) bool {
+	if len(r.Agents) > 0 && !matchAny(r.Agents, agentID, false) {
+		return false
+	}
+	for _, key := range req.SecretKeys {
+		if len(r.Secrets) > 0 && !matchAny(r.Secrets, key, false) {
+			return false
+		}
+	}
+	if len(r.Domains) > 0 && !matchAny(r.Domains, u.Hostname(), true) {
+		return false
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	if len(r.Methods) > 0 && !matchAny(r.Methods, method, true) {
+		return false
+	}
+	if len(r.Paths) > 0 && !matchAny(r.Paths, u.EscapedPath(), false) {
+		return false
+	}
+	if r.NewDomain && !req.NewDomain {
+		return false
+	}
+	return true
+}
+
+func (r *ApprovalRule) describe() string {
+	var parts []string
+	if r.NewDomain {
+		parts = append(parts, "first call to this domain")
+	}
+	if len(r.Methods) > 0 {
+		parts = append(parts, strings.Join(r.Methods, "/"))
+	}
+	if len(r.Domains) > 0 {
+		parts = append(parts, "to "+strings.Join(r.Domains, ", "))
+	}
+	if len(r.Paths) > 0 {
+		parts = append(parts, "on "+strings.Join(r.Paths, ", "))
+	}
+	if len(r.Secrets) > 0 {
+		parts = append(parts, "using "+strings.Join(r.Secrets, ", "))
+	}
+	if len(r.Agents) > 0 {
+		parts = append(parts, "by "+strings.Join(r.Agents, ", "))
+	}
+	if len(parts) == 0 {
+		return "every request requires approval"
+	}
+	return "requires approval: " + strings.Join(parts, " ")
+}
+
+func deny(agent, reason, msg string) PolicyDecision {
+	return PolicyDecision{Agent: agent, Reason: reason, Message: msg}
+}
+
+// check returns an empty reason when the rule permits the request. Otherwise
+// it returns how many checks passed before the failing one.
+func (r *PolicyRule) check(req PolicyRequest, u *url.URL, when time.Time) (int, string, string) {
+	if len(r.Secrets) > 0 {
+		for _, key := range req.SecretKeys {
+			if !matchAny(r.Secrets, key, false) {
+				return 0, "policy_secret_denied", fmt.Sprintf("secret %s is not granted to this agent", key)
+			}
+		}
+		for _, key := range req.CaptureKeys {
+			if !matchAny(r.Secrets, key, false) {
+				return 0, "policy_secret_denied", fmt.Sprintf("capturing into %s is not granted to this agent", key)
+			}
+		}
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if len(r.Domains) > 0 && !matchAny(r.Domains, host, true) {
+		return 1, "policy_domain_denied", fmt.Sprintf("domain %s is not granted to this agent", host)
+	}
+
+	method := strings.ToUpper(req.Method)
+	if method == "" {
+		method = "GET"
+	}
+	if len(r.Methods) > 0 && !matchAny(r.Methods, method, true) {
+		return 2, "policy_method_denied", fmt.Sprintf("method %s is not granted to this agent", method)
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if len(r.Paths) > 0 && !matchAny(r.Paths, path, false) {
+		return 3, "policy_path_denied", fmt.Sprintf("path %s is not granted to this agent", path)
+	}
+
+	if r.MaxBodyBytes > 0 && req.BodySize > r.MaxBodyBytes {
+		return 4, "policy_body_too_large", fmt.Sprintf("request body is %d bytes, limit is %d", req.BodySize, r.MaxBodyBytes)
+	}
+
+	if !r.inWindow(when) {
+		return 5, "policy_outside_time_window", "requests are not allowed at this time"
+	}
+	return 6, "", ""
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func (r *PolicyRule) location() (*time.Location, error) {
+	if r.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", r.Timezone)
+	}
+	return loc, nil
+}
+
+func (r *PolicyRule) inWindow(when time.Time) bool {
+	if len(r.Days) == 0 && len(r.Hours) == 0 {
+		return true
+	}
+	loc, err := r.location()
+	if err != nil {
+		return false
+	}
+	when = when.In(loc)
+
+	if len(r.Days) > 0 {
+		ok := false
+		for _, d := range r.Days {
+			if weekdays[strings.ToLower(d)] == when.Weekday() {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if len(r.Hours) == 0 {
+		return true
+	}
+	minute := when.Hour()*60 + when.Minute()
+	for _, h := range r.Hours {
+		start, end, err := parseWindow(h)
+		if err != nil {
+			continue
+		}
+		if start <= end && minute >= start && minute < end {
+			return true
+		}
+		if start > end && (minute >= start || minute < end) { // wraps midnight
+			return true
+		}
+	}
+	return false
+}
+
+// parseWindow parses "HH:MM-HH:MM" into minutes since midnight.
+func parseWindow(s string) (int, int, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("hours must be HH:MM-HH:MM, got %q", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("hours must be HH:MM-HH:MM, got %q", s)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return 0, 0, fmt.Errorf("hours must be HH:MM-HH:MM, got %q", s)
+	}
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// matchAny reports whether s matches any of the * patterns.
+func matchAny(patterns []string, s string, foldCase bool) bool {
+	for _, p := range patterns {
+		if foldCase {
+			p, s = strings.ToLower(p), strings.ToLower(s)
+		}
+		if globMatch(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func globMatch(pattern, s string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == s
+	}
+	re := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*") + "$"
+	ok, _ := regexp.MatchString(re, s)
+	return ok
+}
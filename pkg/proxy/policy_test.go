@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPolicy = `
+default: deny
+agents:
+  billing-bot:
+    rules:
+      - secrets: [STRIPE_*]
+        domains: [api.stripe.com]
+        methods: [GET, POST]
+        paths: [/v1/charges*]
+        max_body_bytes: 100
+        days: [mon, tue, wed, thu, fri]
+        hours: ["09:00-18:00"]
+        timezone: UTC
+  deploy-bot:
+    token_sha256: ` + "%s" + `
+    rules:
+      - domains: ["*.github.com"]
+`
+
+func loadTestPolicy(t *testing.T, token string) *Policy {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	content := strings.Replace(testPolicy, "%s", HashAgentToken(token), 1)
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadPolicy(path)
+	if err != nil {
+		t.Fatalf("LoadPolicy() error: %v", err)
+	}
+	return p
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	p := loadTestPolicy(t, "ast_secret")
+	weekday := time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC) // Wednesday 10:00
+
+	base := PolicyRequest{
+		AgentID:    "billing-bot",
+		Method:     "POST",
+		TargetURL:  "https://api.stripe.com/v1/charges/ch_1",
+		SecretKeys: []string{"STRIPE_KEY"},
+		BodySize:   50,
+		Time:       weekday,
+	}
+
+	tests := []struct {
+		name   string
+		modify func(r *PolicyRequest)
+		reason string
+	}{
+		{"allowed", func(r *PolicyRequest) {}, ""},
+		{"secret", func(r *PolicyRequest) { r.SecretKeys = []string{"GITHUB_TOKEN"} }, "policy_secret_denied"},
+		{"capture granted", func(r *PolicyRequest) { r.CaptureKeys = []string{"STRIPE_SESSION"} }, ""},
+		{"capture", func(r *PolicyRequest) { r.CaptureKeys = []string{"GITHUB_TOKEN"} }, "policy_secret_denied"},
+		{"domain", func(r *PolicyRequest) { r.TargetURL = "https://evil.example.com/v1/charges" }, "policy_domain_denied"},
+		{"method", func(r *PolicyRequest) { r.Method = "DELETE" }, "policy_method_denied"},
+		{"path", func(r *PolicyRequest) { r.TargetURL = "https://api.stripe.com/v1/refunds" }, "policy_path_denied"},
+		{"dot dot", func(r *PolicyRequest) { r.TargetURL = "https://api.stripe.com/v1/charges/../admin" }, "policy_path_traversal"},
+		{"encoded dot dot", func(r *PolicyRequest) { r.TargetURL = "https://api.stripe.com/v1/charges/%2e%2E/admin" }, "policy_path_traversal"},
+		{"encoded slash", func(r *PolicyRequest) { r.TargetURL = "https://api.stripe.com/v1/charges%2F..%2Fadmin" }, "policy_path_traversal"},
+		{"dot", func(r *PolicyRequest) { r.TargetURL = "https://api.stripe.com/v1/charges/./x" }, "policy_path_traversal"},
+		{"dots in a name", func(r *PolicyRequest) { r.TargetURL = "https://api.stripe.com/v1/charges/ch..1" }, ""},
+		{"body", func(r *PolicyRequest) { r.BodySize = 101 }, "policy_body_too_large"},
+		{"night", func(r *PolicyRequest) { r.Time = weekday.Add(10 * time.Hour) }, "policy_outside_time_window"},
+		{"weekend", func(r *PolicyRequest) { r.Time = weekday.AddDate(0, 0, 3) }, "policy_outside_time_window"},
+		{"unknown agent", func(r *PolicyRequest) { r.AgentID = "other" }, "policy_unknown_agent"},
+		{"claims token agent", func(r *PolicyRequest) { r.AgentID = "deploy-bot" }, "policy_agent_unauthenticated"},
+		{"bad token", func(r *PolicyRequest) { r.AgentToken = "wrong" }, "policy_invalid_agent_token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := base
+			tt.modify(&req)
+			d := p.Evaluate(req)
+			if tt.reason == "" && !d.Allowed {
+				t.Fatalf("denied with %s: %s", d.Reason, d.Message)
+			}
+			if tt.reason != "" && (d.Allowed || d.Reason != tt.reason) {
+				t.Fatalf("got allowed=%v reason=%q, want %q", d.Allowed, d.Reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestPolicyTokenProvesIdentity(t *testing.T) {
+	p := loadTestPolicy(t, "ast_secret")
+
+	// The token wins over whatever AgentID the caller claims
+	d := p.Evaluate(PolicyRequest{
+		AgentID:    "billing-bot",
+		AgentToken: "ast_secret",
+		Method:     "GET",
+		TargetURL:  "https://api.github.com/user",
+	})
+	if !d.Allowed || d.Agent != "deploy-bot" {
+		t.Fatalf("got allowed=%v agent=%q reason=%q, want deploy-bot allowed", d.Allowed, d.Agent, d.Reason)
+	}
+}
+
+func TestLoadPolicyMissingAndInvalid(t *testing.T) {
+	dir := t.TempDir()
+	p, err := LoadPolicy(filepath.Join(dir, "missing.yaml"))
+	if err != nil || p != nil {
+		t.Fatalf("missing file: got %v, %v; want nil, nil", p, err)
+	}
+
+	bad := filepath.Join(dir, "bad.yaml")
+	os.WriteFile(bad, []byte("agents:\n  a:\n    rules:\n      - hours: [\"9-5\"]\n"), 0600)
+	if _, err := LoadPolicy(bad); err == nil {
+		t.Fatal("expected an error for a malformed hours window")
+	}
+}
+
+func TestEngineExecutePolicyDenied(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	resolved := false
+	engine := &Engine{
+		ProjectID: "test-project",
+		Client:    upstream.Client(),
+		ResolveSecret: func(key string) (string, error) {
+			resolved = true
+			return "sk_test_123", nil
+		},
+		SkipAllowlist: true,
+		Policy: &Policy{Agents: map[string]*AgentPolicy{
+			"reader": {Rules: []PolicyRule{{Methods: []string{"GET"}}}},
+		}},
+	}
+
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  upstream.URL + "/v1/charges",
+		Method:     "POST",
+		Injections: []Injection{{Style: "bearer", SecretKey: "STRIPE_KEY"}},
+		AgentID:    "reader",
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 403 || !strings.Contains(string(result.Body), "policy_method_denied") {
+		t.Errorf("got %d %s, want 403 policy_method_denied", result.StatusCode, result.Body)
+	}
+	if resolved || called {
+		t.Error("a denied request must not resolve secrets or reach upstream")
+	}
+}
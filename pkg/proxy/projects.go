@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/The-17/agentsecrets/pkg/config"
+	"github.com/The-17/agentsecrets/pkg/proxyapi"
+)
+
+// ProjectHeader selects, by name or ID, the project a request runs in on a
+// server that serves several.
+const ProjectHeader = proxyapi.ProjectHeader
+
+// Project is one project served by a multi-project Server. Its engine, and
+// with it the workspace allowlist and keyring, is only loaded by the first
+// request that selects the project, and rebuilt by Reload.
+type Project struct {
+	Dir         string // project root, holding .agentsecrets/
+	ID          string
+	Name        string
+	WorkspaceID string
+
+	mu           sync.Mutex
+	engine       *Engine
+	policy       *Policy // read for agent-token routing before the engine is loaded
+	policyLoaded bool
+	allowlist    []string // as the engine was last loaded, to diff on reload
+
+	set      *ProjectSet
+	reloadMu sync.Mutex
+}
+
+// Engine returns the project's engine, creating it on first use.
+func (p *Project) Engine() (*Engine, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.engine == nil {
+		engine, err := p.load()
+		if err != nil {
+			return nil, err
+		}
+		p.engine, p.allowlist = engine, engine.currentAllowlist()
+	}
+	return p.engine, nil
+}
+
+// load builds the project's engine and runs the set's Setup on it.
+func (p *Project) load() (*Engine, error) {
+	engine, err := newProjectEngine(p.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project %s: %w", p.Name, err)
+	}
+	if p.set != nil && p.set.Setup != nil {
+		if err := p.set.Setup(engine); err != nil {
+			return nil, fmt.Errorf("failed to set up project %s: %w", p.Name, err)
+		}
+	}
+	return engine, nil
+}
+
+// Loaded reports whether the project's engine has been created.
+func (p *Project) Loaded() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.engine != nil
+}
+
+// knowsToken reports whether token proves an agent in the project's policy.
+func (p *Project) knowsToken(token string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	policy := p.policy
+	if p.engine != nil {
+		policy = p.engine.Policy
+	} else if !p.policyLoaded {
+		// A policy that fails to load routes nothing; the engine reports why
+		policy, _ = LoadPolicy(filepath.Join(p.Dir, DefaultPolicyPath))
+		p.policy, p.policyLoaded = policy, true
+	}
+	if policy == nil {
+		return false
+	}
+	_, ok := policy.agentForToken(token)
+	return ok
+}
+
+// ProjectSet is the projects a Server serves.
+type ProjectSet struct {
+	// Default serves requests that select no project; nil means they must.
+	Default *Project
+
+	// Setup, if set, runs on every engine a project loads, including the
+	// ones Reload builds; e.g. to record its traffic.
+	Setup func(*Engine) error
+
+	mu       sync.RWMutex
+	projects []*Project
+}
+
+// Add registers the project rooted at dir. Only its project.json is read
+// now. Adding a project twice returns the one already registered.
+func (s *ProjectSet) Add(dir string) (*Project, error) {
+	pc, err := config.LoadProjectConfigAt(dir)
+	if err != nil {
+		return nil, err
+	}
+	if pc.ProjectID == "" || pc.WorkspaceID == "" {
+		return nil, fmt.Errorf("%s is not an agentsecrets project — run 'agentsecrets project use' there first", dir)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.projects {
+		if p.ID == pc.ProjectID {
+			return p, nil
+		}
+	}
+	name := pc.ProjectName
+	if name == "" {
+		name = pc.ProjectID
+	}
+	p := &Project{Dir: abs, ID: pc.ProjectID, Name: name, WorkspaceID: pc.WorkspaceID, set: s}
+	s.projects = append(s.projects, p)
+	return p, nil
+}
+
+// List returns the registered projects in the order they were added.
+func (s *ProjectSet) List() []*Project {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return slices.Clone(s.projects)
+}
+
+// Lookup finds a project by ID, or by name when no ID matches.
+func (s *ProjectSet) Lookup(nameOrID string) (*Project, error) {
+	var byName []*Project
+	for _, p := range s.List() {
+		if p.ID == nameOrID {
+			return p, nil
+		}
+		if strings.EqualFold(p.Name, nameOrID) {
+			byName = append(byName, p)
+		}
+	}
+	switch len(byName) {
+	case 0:
+		return nil, fmt.Errorf("this proxy does not serve a project named %q", nameOrID)
+	case 1:
+		return byName[0], nil
+	}
+	return nil, fmt.Errorf("several projects are named %q; select one by its ID", nameOrID)
+}
+
+// selectProject picks the project for a request: the one named (by the route
+// or the X-AS-Project header), else the one whose policy knows the agent's
+// token, else the default. The status is the HTTP status to fail with.
+func (s *ProjectSet) selectProject(named, agentToken string) (*Project, int, error) {
+	if named != "" {
+		p, err := s.Lookup(named)
+		if err != nil {
+			return nil, http.StatusNotFound, err
+		}
+		return p, 0, nil
+	}
+
+	if agentToken != "" {
+		var matches []*Project
+		for _, p := range s.List() {
+			if p.knowsToken(agentToken) {
+				matches = append(matches, p)
+			}
+		}
+		switch len(matches) {
+		case 1:
+			return matches[0], 0, nil
+		case 0:
+			// Falls through to the default, whose policy rejects the token
+		default:
+			return nil, http.StatusBadRequest, fmt.Errorf("the agent token is valid in several projects; select one with the %s header", ProjectHeader)
+		}
+	}
+
+	if s.Default == nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("this proxy serves several projects; select one with the %s header or a /projects/<name>/ route", ProjectHeader)
+	}
+	return s.Default, 0, nil
+}
+
+// engineFor returns the engine that serves r. A server without projects
+// serves everything with its Engine.
+func (s *Server) engineFor(r *http.Request, agentToken string) (*Engine, int, error) {
+	if s.Projects == nil {
+		return s.Engine, 0, nil
+	}
+	p, status, err := s.Projects.selectProject(r.Header.Get(ProjectHeader), agentToken)
+	if err != nil {
+		return nil, status, err
+	}
+	engine, err := p.Engine()
+	if err != nil {
+		return nil, http.StatusInternalServerError, err
+	}
+	return engine, 0, nil
+}
+
+// handleProjectRoute serves /projects/<name>/<path> as <path> in the named
+// project, for clients that cannot set the X-AS-Project header.
+func (s *Server) handleProjectRoute(w http.ResponseWriter, r *http.Request) {
+	project := r.PathValue("project")
+	prefix := "/projects/" + project
+	if strings.HasPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/projects/") {
+		http.NotFound(w, r)
+		return
+	}
+	r.Header.Set(ProjectHeader, project)
+	http.StripPrefix(prefix, s.mux).ServeHTTP(w, r)
+}
@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProject(t *testing.T, id, name string) string {
+	t.Helper()
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, ".agentsecrets"), 0700)
+	data, _ := json.Marshal(map[string]string{"project_id": id, "project_name": name, "workspace_id": "ws-" + id})
+	if err := os.WriteFile(filepath.Join(dir, ".agentsecrets", "project.json"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestProjectSetAddAndLookup(t *testing.T) {
+	set := &ProjectSet{}
+	billing, err := set.Add(writeProject(t, "p-1", "billing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := set.Add(billing.Dir)
+	if again != billing || len(set.List()) != 1 {
+		t.Errorf("adding a project twice registered it twice")
+	}
+	if _, err := set.Add(t.TempDir()); err == nil {
+		t.Error("expected a directory without project.json to be rejected")
+	}
+	set.Add(writeProject(t, "p-2", "search"))
+	set.Add(writeProject(t, "p-3", "search"))
+
+	if p, err := set.Lookup("BILLING"); err != nil || p != billing {
+		t.Errorf("Lookup(name) = %v, %v", p, err)
+	}
+	if p, err := set.Lookup("p-3"); err != nil || p.ID != "p-3" {
+		t.Errorf("Lookup(id) = %v, %v", p, err)
+	}
+	if _, err := set.Lookup("search"); err == nil || !strings.Contains(err.Error(), "several") {
+		t.Errorf("expected an ambiguous name to fail, got %v", err)
+	}
+	if billing.Loaded() {
+		t.Error("Add must not load the engine")
+	}
+}
+
+func TestServerSelectsProject(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upper-cased so the echo is not redacted
+		w.Write([]byte(strings.ToUpper(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))))
+	}))
+	defer upstream.Close()
+
+	billingEngine, _ := redirectEngine(t)
+	billingEngine.ResolveSecret = mockResolver(map[string]string{"API_KEY": "sk_billing"})
+	searchEngine, _ := redirectEngine(t)
+	searchEngine.ResolveSecret = mockResolver(map[string]string{"API_KEY": "sk_search"})
+	searchEngine.Policy = &Policy{Agents: map[string]*AgentPolicy{
+		"indexer": {TokenSHA256: HashAgentToken("indexer-token"), Rules: []PolicyRule{{}}},
+	}}
+
+	set := &ProjectSet{projects: []*Project{
+		{ID: "p-1", Name: "billing", engine: billingEngine},
+		{ID: "p-2", Name: "search", engine: searchEngine},
+	}}
+	srv := httptest.NewServer(NewProjectServer(0, set).mux)
+	defer srv.Close()
+
+	call := func(path string, headers map[string]string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		req.Header.Set("X-AS-Target-URL", upstream.URL)
+		req.Header.Set("X-AS-Inject-Bearer", "API_KEY")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		status  int
+		want    string
+	}{
+		{"header by name", "/proxy", map[string]string{ProjectHeader: "billing"}, 200, "SK_BILLING"},
+		{"header by id", "/proxy", map[string]string{ProjectHeader: "p-2"}, 200, "SK_SEARCH"},
+		{"route", "/projects/search/proxy", nil, 200, "SK_SEARCH"},
+		{"route wins over header", "/projects/billing/proxy", map[string]string{ProjectHeader: "search"}, 200, "SK_BILLING"},
+		{"agent token", "/proxy", map[string]string{"X-AS-Agent-Token": "indexer-token"}, 200, "SK_SEARCH"},
+		{"unknown project", "/proxy", map[string]string{ProjectHeader: "payroll"}, 404, "does not serve"},
+		{"no selection", "/proxy", nil, 400, ProjectHeader},
+		{"nested route", "/projects/billing/projects/search/proxy", nil, 404, ""},
+	}
+	for _, tt := range tests {
+		status, body := call(tt.path, tt.headers)
+		if status != tt.status || !strings.Contains(body, tt.want) {
+			t.Errorf("%s: %d %q, want %d containing %q", tt.name, status, body, tt.status, tt.want)
+		}
+	}
+
+	// With a default, unselected requests go there
+	set.Default = set.projects[0]
+	if status, body := call("/proxy", nil); status != 200 || body != "SK_BILLING" {
+		t.Errorf("default project: %d %q", status, body)
+	}
+
+	resp, err := http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var health struct {
+		Project  string `json:"project"`
+		Projects []struct {
+			Name    string `json:"name"`
+			Loaded  bool   `json:"loaded"`
+			Default bool   `json:"default"`
+		} `json:"projects"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health)
+	if health.Project != "p-1" || len(health.Projects) != 2 || !health.Projects[0].Default || !health.Projects[1].Loaded {
+		t.Errorf("health = %+v", health)
+	}
+}
+
+func TestProjectKnowsTokenBeforeLoading(t *testing.T) {
+	dir := writeProject(t, "p-1", "search")
+	policy := "agents:\n  indexer:\n    token_sha256: " + HashAgentToken("indexer-token") + "\n    rules: []\n"
+	os.WriteFile(filepath.Join(dir, ".agentsecrets", "policy.yaml"), []byte(policy), 0600)
+
+	set := &ProjectSet{}
+	p, err := set.Add(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.knowsToken("indexer-token") || p.knowsToken("other-token") {
+		t.Error("token routing must read the policy file")
+	}
+	if p.Loaded() {
+		t.Error("token routing must not load the engine")
+	}
+}
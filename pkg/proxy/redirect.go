@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// MaxRedirects caps how many redirects the engine follows for one call.
+const MaxRedirects = 10
+
+// redirectLocation returns the URL a redirect response points to, resolved
+// against the URL that produced it. ok is false for any other response.
+func redirectLocation(result *ForwardResult, current *url.URL) (next *url.URL, ok bool) {
+	switch result.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, false
+	}
+	loc := result.Headers.Get("Location")
+	if loc == "" {
+		return nil, false
+	}
+	next, err := current.Parse(loc)
+	if err != nil || (next.Scheme != "http" && next.Scheme != "https") {
+		return nil, false
+	}
+	return next, true
+}
+
+// redirectMethod returns the method for the next hop and whether the request
+// body is replayed, following the same rules as net/http: 307 and 308 keep
+// both; 301, 302 and 303 drop the body and turn anything but GET or HEAD into GET.
+func redirectMethod(status int, method string) (string, bool) {
+	switch status {
+	case http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return method, true
+	default:
+		if method != http.MethodGet && method != http.MethodHead {
+			return http.MethodGet, false
+		}
+		return method, false
+	}
+}
+
+// sameOrigin reports whether a and b share scheme, host and port. Injected
+// credentials are only replayed to the origin the caller addressed.
+func sameOrigin(a, b *url.URL) bool {
+	return strings.EqualFold(a.Scheme, b.Scheme) && strings.EqualFold(a.Host, b.Host)
+}
+
+// scrubCrossOrigin removes the query parameters the call injected from the URL
+// of a hop to another origin, since a Location often echoes the request URL.
+// It reports whether a secret value is still in the URL, e.g. echoed into
+// another parameter, in which case the hop must not be made.
+func scrubCrossOrigin(next *url.URL, call *checkedCall) (*url.URL, bool) {
+	scrubbed := *next
+	q := scrubbed.Query()
+	removed := false
+	for _, inj := range call.req.Injections {
+		if inj.Style == "query" && q.Has(inj.Target) {
+			q.Del(inj.Target)
+			removed = true
+		}
+	}
+	if removed {
+		scrubbed.RawQuery = q.Encode()
+	}
+
+	s := scrubbed.String()
+	for i, v := range call.secretValues {
+		for _, form := range append(injectedForms(call.req.Injections[i], v), SecretVariants(v)...) {
+			if form != "" && strings.Contains(s, form) {
+				return &scrubbed, true
+			}
+		}
+	}
+	return &scrubbed, false
+}
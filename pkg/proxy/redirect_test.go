@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func redirectEngine(t *testing.T) (*Engine, string) {
+	t.Helper()
+	logPath := filepath.Join(t.TempDir(), "proxy.log")
+	audit, err := NewAuditLogger(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Close() })
+	return &Engine{
+		ProjectID:     "test-project",
+		Audit:         audit,
+		Client:        &http.Client{},
+		ResolveSecret: mockResolver(map[string]string{"API_KEY": "sk_test_123"}),
+		SkipAllowlist: true,
+	}, logPath
+}
+
+func lastAuditEvent(t *testing.T, logPath string) AuditEvent {
+	t.Helper()
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var event AuditEvent
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestEngineRedirectSameOriginKeepsCredentials(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Authorization") + " " + r.URL.Query().Get("key")))
+	}))
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	result, err := engine.Execute(CallRequest{
+		TargetURL: upstream.URL + "/old",
+		Injections: []Injection{
+			{Style: "bearer", SecretKey: "API_KEY"},
+			{Style: "query", Target: "key", SecretKey: "API_KEY"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 200 {
+		t.Fatalf("StatusCode = %d, want 200", result.StatusCode)
+	}
+	// Both injections reached the same-origin hop (and were redacted on the way back)
+	if got := string(result.Body); got != "Bearer [REDACTED_BY_AGENTSECRETS] [REDACTED_BY_AGENTSECRETS]" {
+		t.Errorf("body = %q", got)
+	}
+
+	event := lastAuditEvent(t, logPath)
+	if len(event.Redirects) != 1 || event.Redirects[0] != upstream.URL+"/new" {
+		t.Errorf("Redirects = %v, want [%s/new]", event.Redirects, upstream.URL)
+	}
+}
+
+func TestEngineRedirectCrossOriginDropsCredentials(t *testing.T) {
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		for _, v := range []string{r.Header.Get("Authorization"), r.Header.Get("X-Api-Key"), r.URL.RawQuery, string(body)} {
+			if strings.Contains(v, "sk_test_123") {
+				leaked = append(leaked, v)
+			}
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer other.Close()
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, other.URL+"/landing", http.StatusTemporaryRedirect)
+	}))
+	defer origin.Close()
+
+	engine, _ := redirectEngine(t)
+	result, err := engine.Execute(CallRequest{
+		TargetURL: origin.URL + "/start",
+		Method:    "POST",
+		Body:      []byte(`{"name":"x"}`),
+		Injections: []Injection{
+			{Style: "bearer", SecretKey: "API_KEY"},
+			{Style: "header", Target: "X-Api-Key", SecretKey: "API_KEY"},
+			{Style: "query", Target: "key", SecretKey: "API_KEY"},
+			{Style: "body", Target: "auth.key", SecretKey: "API_KEY"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 200 {
+		t.Fatalf("StatusCode = %d, want 200", result.StatusCode)
+	}
+	if len(leaked) > 0 {
+		t.Errorf("credentials carried to another origin: %v", leaked)
+	}
+}
+
+func TestEngineRedirectCrossOriginEchoedCredential(t *testing.T) {
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.String(), "sk_test_123") {
+			leaked = append(leaked, r.URL.String())
+		}
+		w.Write([]byte(r.URL.RawQuery))
+	}))
+	defer other.Close()
+
+	// Location echoes the request URL: into the same parameters, and into another one
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/nested" {
+			http.Redirect(w, r, other.URL+"/landing?next="+url.QueryEscape(r.URL.String()), http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, other.URL+"/landing?"+r.URL.RawQuery, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	engine, _ := redirectEngine(t)
+	call := func(path string) *CallResult {
+		t.Helper()
+		result, err := engine.Execute(CallRequest{
+			TargetURL:  origin.URL + path + "?page=2",
+			Injections: []Injection{{Style: "query", Target: "key", SecretKey: "API_KEY"}},
+		})
+		if err != nil {
+			t.Fatalf("Execute() error: %v", err)
+		}
+		return result
+	}
+
+	// The injected parameter is dropped from the hop; the rest is kept
+	if result := call("/echo"); result.StatusCode != 200 || string(result.Body) != "page=2" {
+		t.Errorf("echo: got %d %q, want 200 \"page=2\"", result.StatusCode, result.Body)
+	}
+	// A credential anywhere else in the URL stops the redirect
+	if result := call("/nested"); result.StatusCode != 403 || result.Blocked != "redirect_credential_in_url" {
+		t.Errorf("nested: got %d %s, want 403 redirect_credential_in_url", result.StatusCode, result.Body)
+	}
+	if len(leaked) > 0 {
+		t.Errorf("credential sent to another origin: %v", leaked)
+	}
+}
+
+func TestEngineRedirectIsCheckedAgainstPolicy(t *testing.T) {
+	var reached []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = append(reached, r.URL.Path)
+		if r.URL.Path == "/v1/charges" {
+			http.Redirect(w, r, "/admin", http.StatusFound)
+		}
+	}))
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	engine.Policy = &Policy{Agents: map[string]*AgentPolicy{
+		"bot": {Rules: []PolicyRule{{Paths: []string{"/v1/charges*"}}}},
+	}}
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  upstream.URL + "/v1/charges",
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+		AgentID:    "bot",
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 403 || result.Blocked != "redirect_policy_path_denied" {
+		t.Errorf("got %d %s, want 403 redirect_policy_path_denied", result.StatusCode, result.Body)
+	}
+	if len(reached) != 1 {
+		t.Errorf("upstream reached %v; the denied hop must not be requested", reached)
+	}
+	if event := lastAuditEvent(t, logPath); event.Reason != "redirect_policy_path_denied" {
+		t.Errorf("audit reason = %q", event.Reason)
+	}
+}
+
+func TestEngineRedirectLoopIsCapped(t *testing.T) {
+	requests := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Redirect(w, r, r.URL.Path, http.StatusFound)
+	}))
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  upstream.URL + "/loop",
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+	})
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 403 || !strings.Contains(string(result.Body), "too_many_redirects") {
+		t.Errorf("got %d %s, want 403 too_many_redirects", result.StatusCode, result.Body)
+	}
+	if requests != MaxRedirects+1 {
+		t.Errorf("upstream saw %d requests, want %d", requests, MaxRedirects+1)
+	}
+	if event := lastAuditEvent(t, logPath); event.Status != "BLOCKED" || len(event.Redirects) != MaxRedirects+1 {
+		t.Errorf("audit status=%s redirects=%d", event.Status, len(event.Redirects))
+	}
+}
+
+func TestRedirectMethod(t *testing.T) {
+	tests := []struct {
+		status     int
+		method     string
+		wantMethod string
+		wantBody   bool
+	}{
+		{301, "POST", "GET", false},
+		{302, "PUT", "GET", false},
+		{302, "GET", "GET", false},
+		{303, "PUT", "GET", false},
+		{303, "HEAD", "HEAD", false},
+		{307, "POST", "POST", true},
+		{308, "DELETE", "DELETE", true},
+	}
+	for _, tt := range tests {
+		method, body := redirectMethod(tt.status, tt.method)
+		if method != tt.wantMethod || body != tt.wantBody {
+			t.Errorf("redirectMethod(%d, %s) = %s, %v; want %s, %v", tt.status, tt.method, method, body, tt.wantMethod, tt.wantBody)
+		}
+	}
+}
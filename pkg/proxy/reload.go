@@ -0,0 +1,354 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/The-17/agentsecrets/pkg/keyring"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultWatchInterval is how often Watch looks for changed files.
+const DefaultWatchInterval = 2 * time.Second
+
+// newProjectEngine builds the engine of a project; tests replace it.
+var newProjectEngine = NewProjectEngine
+
+// ReloadReport is the outcome of reloading one project.
+type ReloadReport struct {
+	Project string   `json:"project"` // the project's name
+	Changes []string `json:"changes,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// Reload rebuilds the project's engine from its files and the keyring and
+// swaps it in. Requests already running finish on the engine they started
+// with. If the files fail to load, the project keeps the engine it has. A
+// project that is not loaded yet has nothing to reload and returns nil.
+//
+// The reload is audited with trigger (e.g. "sighup", "watch", "admin") as its
+// reason and a summary of what changed.
+func (p *Project) Reload(trigger string) *ReloadReport {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+
+	p.mu.Lock()
+	prev, allowlist := p.engine, p.allowlist
+	p.mu.Unlock()
+	if prev == nil {
+		return nil
+	}
+
+	report := &ReloadReport{Project: p.Name}
+	next, err := p.load()
+	if err != nil {
+		report.Error = err.Error()
+		prev.auditReload(trigger, "FAILED", []string{report.Error})
+		return report
+	}
+	nextAllowlist := next.currentAllowlist()
+	report.Changes = append(diffEngines(prev, next), diffList("allowlist", allowlist, nextAllowlist)...)
+	next.adopt(prev)
+
+	p.mu.Lock()
+	p.engine, p.allowlist = next, nextAllowlist
+	p.mu.Unlock()
+
+	prev.Client.CloseIdleConnections() // connections in use are left to finish
+	next.auditReload(trigger, "OK", report.Changes)
+	return report
+}
+
+// Reload reloads every loaded project, returning a report for each.
+func (s *ProjectSet) Reload(trigger string) []*ReloadReport {
+	var reports []*ReloadReport
+	for _, p := range s.List() {
+		if report := p.Reload(trigger); report != nil {
+			reports = append(reports, report)
+		}
+	}
+	return reports
+}
+
+// Watch reloads a loaded project whenever a file in its .agentsecrets/
+// directory, a global config file or the keyring file changes, looking
+// every interval until ctx is done. Each reload's report goes to report.
+func (s *ProjectSet) Watch(ctx context.Context, interval time.Duration, report func(*ReloadReport)) {
+	stamps := make(map[*Project]string)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for _, p := range s.List() {
+			if !p.Loaded() {
+				continue // it reads its files when it loads
+			}
+			stamp := watchStamp(p.Dir)
+			last, seen := stamps[p]
+			stamps[p] = stamp
+			if !seen || stamp == last {
+				continue
+			}
+			if r := p.Reload("watch"); r != nil && report != nil {
+				report(r)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// watchStamp fingerprints the files a project's engine is built from by
+// their size and modification time.
+func watchStamp(dir string) string {
+	paths, _ := filepath.Glob(filepath.Join(dir, ".agentsecrets", "*"))
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"upstream.yaml", "budget.yaml", "cache.yaml", "keyring.json"} {
+			paths = append(paths, filepath.Join(home, ".agentsecrets", name))
+		}
+	}
+	var b strings.Builder
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d\n", path, info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String()
+}
+
+// currentAllowlist reads the workspace allowlist; it is nil when the engine
+// does not check one.
+func (e *Engine) currentAllowlist() []string {
+	if e.SkipAllowlist {
+		return nil
+	}
+	allowlist, _ := keyring.GetWorkspaceAllowlist(e.WorkspaceID)
+	return allowlist
+}
+
+// adopt takes over what prev holds that a reload does not change: the open
+// audit log, and the response cache while its settings are the same.
+func (e *Engine) adopt(prev *Engine) {
+	if prev.Audit != nil {
+		if e.Audit != nil {
+			e.Audit.Close()
+		}
+		e.Audit = prev.Audit
+	}
+	if e.Cache != nil && prev.Cache != nil && sameYAML(e.Cache.Config, prev.Cache.Config) {
+		e.Cache = prev.Cache
+	}
+}
+
+func (e *Engine) auditReload(trigger, status string, changes []string) {
+	if e.Audit == nil {
+		return
+	}
+	_ = e.Audit.Log(AuditEvent{
+		Timestamp: time.Now().UTC(),
+		Method:    "RELOAD",
+		Status:    status,
+		Reason:    trigger,
+		Changes:   changes,
+	})
+}
+
+// diffEngines summarizes how next's configuration differs from prev's.
+func diffEngines(prev, next *Engine) []string {
+	var changes []string
+	if prev.WorkspaceID != next.WorkspaceID {
+		changes = append(changes, fmt.Sprintf("workspace: %s → %s", prev.WorkspaceID, next.WorkspaceID))
+	}
+	changes = append(changes, diffPolicy(prev.Policy, next.Policy)...)
+	changes = append(changes, diffConfig("upstream", prev.Upstream, next.Upstream)...)
+	changes = append(changes, diffConfig("budget", prev.Budget, next.Budget)...)
+	var prevCache, nextCache *CacheConfig
+	if prev.Cache != nil {
+		prevCache = prev.Cache.Config
+	}
+	if next.Cache != nil {
+		nextCache = next.Cache.Config
+	}
+	return append(changes, diffConfig("cache", prevCache, nextCache)...)
+}
+
+func diffPolicy(prev, next *Policy) []string {
+	switch {
+	case prev == nil && next == nil:
+		return nil
+	case prev == nil:
+		return []string{"policy: added"}
+	case next == nil:
+		return []string{"policy: removed"}
+	}
+
+	var changes []string
+	if prev.Default != next.Default {
+		changes = append(changes, fmt.Sprintf("policy: default %q → %q", prev.Default, next.Default))
+	}
+	var added, removed, changed []string
+	for name, agent := range next.Agents {
+		if old, ok := prev.Agents[name]; !ok {
+			added = append(added, name)
+		} else if !sameYAML(old, agent) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range prev.Agents {
+		if _, ok := next.Agents[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	for _, d := range []struct {
+		verb  string
+		names []string
+	}{{"added", added}, {"removed", removed}, {"changed", changed}} {
+		if len(d.names) > 0 {
+			slices.Sort(d.names)
+			changes = append(changes, fmt.Sprintf("policy: agents %s: %s", d.verb, strings.Join(d.names, ", ")))
+		}
+	}
+	if !sameYAML(prev.Approvals, next.Approvals) {
+		changes = append(changes, "policy: approval rules changed")
+	}
+	return changes
+}
+
+// diffConfig reports whether a config file was added, removed or changed.
+func diffConfig[T any](name string, prev, next *T) []string {
+	switch {
+	case prev == nil && next == nil:
+		return nil
+	case prev == nil:
+		return []string{name + ": added"}
+	case next == nil:
+		return []string{name + ": removed"}
+	case !sameYAML(prev, next):
+		return []string{name + ": changed"}
+	}
+	return nil
+}
+
+func diffList(name string, prev, next []string) []string {
+	var changes []string
+	var added, removed []string
+	for _, v := range next {
+		if !slices.Contains(prev, v) {
+			added = append(added, v)
+		}
+	}
+	for _, v := range prev {
+		if !slices.Contains(next, v) {
+			removed = append(removed, v)
+		}
+	}
+	if len(added) > 0 {
+		changes = append(changes, fmt.Sprintf("%s: added %s", name, strings.Join(added, ", ")))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, fmt.Sprintf("%s: removed %s", name, strings.Join(removed, ", ")))
+	}
+	return changes
+}
+
+func sameYAML(a, b any) bool {
+	x, errX := yaml.Marshal(a)
+	y, errY := yaml.Marshal(b)
+	return errX == nil && errY == nil && bytes.Equal(x, y)
+}
+
+// AdminTokenPath returns where `proxy start` keeps the admin token of the
+// proxy listening on port: ~/.agentsecrets/proxy-<port>.token, or
+// <socket>.token when it listens on a Unix socket.
+func AdminTokenPath(port int, socket string) (string, error) {
+	if socket != "" {
+		return socket + ".token", nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	dir := filepath.Join(home, ".agentsecrets")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("cannot create config directory: %w", err)
+	}
+	return filepath.Join(dir, fmt.Sprintf("proxy-%d.token", port)), nil
+}
+
+// NewAdminToken generates an admin token and writes it to path, readable
+// only by the current user.
+func NewAdminToken(path string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+		return "", fmt.Errorf("write admin token: %w", err)
+	}
+	return token, nil
+}
+
+// handleAdminReload serves POST /admin/reload: every loaded project, or the
+// one X-AS-Project names, reloads its files. The request must carry the
+// server's AdminToken as a bearer token; a server without one refuses.
+func (s *Server) handleAdminReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, 405, "/admin/reload only accepts POST")
+		return
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if s.AdminToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.AdminToken)) != 1 {
+		writeError(w, 401, "a valid admin token is required")
+		return
+	}
+	if s.Projects == nil {
+		writeError(w, 404, "this proxy has no project files to reload")
+		return
+	}
+
+	reports := []*ReloadReport{}
+	if named := r.Header.Get(ProjectHeader); named != "" {
+		p, err := s.Projects.Lookup(named)
+		if err != nil {
+			writeError(w, 404, err.Error())
+			return
+		}
+		if report := p.Reload("admin"); report != nil {
+			reports = append(reports, report)
+		}
+	} else {
+		reports = append(reports, s.Projects.Reload("admin")...)
+	}
+
+	status := 200
+	for _, report := range reports {
+		if report.Error != "" {
+			status = 500
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(struct {
+		Reloaded []*ReloadReport `json:"reloaded"`
+	}{reports})
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// reloadProject registers a project whose engines are built from its files
+// under a temporary home, without the keyring allowlist.
+func reloadProject(t *testing.T) (*Project, string) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	build := newProjectEngine
+	newProjectEngine = func(dir string) (*Engine, error) {
+		e, err := build(dir)
+		if e != nil {
+			e.SkipAllowlist = true
+		}
+		return e, err
+	}
+	t.Cleanup(func() { newProjectEngine = build })
+
+	dir := writeProject(t, "p-1", "billing")
+	set := &ProjectSet{}
+	p, err := set.Add(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, dir
+}
+
+func writePolicy(t *testing.T, dir string, agents ...string) {
+	t.Helper()
+	policy := "agents:\n"
+	for _, agent := range agents {
+		policy += "  " + agent + ":\n    rules:\n      - secrets: [API_KEY]\n"
+	}
+	if err := os.WriteFile(filepath.Join(dir, DefaultPolicyPath), []byte(policy), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProjectReload(t *testing.T) {
+	p, dir := reloadProject(t)
+	if report := p.Reload("admin"); report != nil {
+		t.Errorf("a project that is not loaded reloaded: %+v", report)
+	}
+
+	writePolicy(t, dir, "deployer")
+	before, err := p.Engine()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writePolicy(t, dir, "deployer", "indexer")
+	os.WriteFile(filepath.Join(dir, DefaultBudgetPath), []byte("budgets:\n  - daily: 5\n"), 0600)
+	report := p.Reload("sighup")
+	want := []string{"policy: agents added: indexer", "budget: added"}
+	if report == nil || report.Error != "" || !slices.Equal(report.Changes, want) {
+		t.Fatalf("report = %+v, want changes %q", report, want)
+	}
+	after, _ := p.Engine()
+	if after == before || after.Policy.Agents["indexer"] == nil {
+		t.Fatal("the reloaded engine was not swapped in")
+	}
+	if before.Policy.Agents["indexer"] != nil {
+		t.Error("the engine in use by running requests was changed")
+	}
+	if after.Audit != before.Audit {
+		t.Error("the reloaded engine opened a second audit log")
+	}
+
+	logPath, _ := DefaultLogPath()
+	event := lastAuditEvent(t, logPath)
+	if event.Method != "RELOAD" || event.Status != "OK" || event.Reason != "sighup" || event.ProjectID != "p-1" || !slices.Equal(event.Changes, want) {
+		t.Errorf("audit event = %+v", event)
+	}
+
+	// A broken file keeps the engine that works
+	os.WriteFile(filepath.Join(dir, DefaultPolicyPath), []byte("agents: [\n"), 0600)
+	report = p.Reload("watch")
+	if report == nil || report.Error == "" {
+		t.Fatalf("report = %+v, want an error", report)
+	}
+	if current, _ := p.Engine(); current != after {
+		t.Error("a failed reload replaced the engine")
+	}
+	if event := lastAuditEvent(t, logPath); event.Status != "FAILED" || len(event.Changes) != 1 {
+		t.Errorf("audit event = %+v", event)
+	}
+}
+
+func TestDiffEngines(t *testing.T) {
+	prev := &Engine{WorkspaceID: "w1", Policy: &Policy{Agents: map[string]*AgentPolicy{
+		"a": {Rules: []PolicyRule{{Secrets: []string{"X"}}}},
+		"b": {Rules: []PolicyRule{{}}},
+	}}}
+	next := &Engine{WorkspaceID: "w2", Upstream: &UpstreamConfig{}, Policy: &Policy{Default: "deny", Agents: map[string]*AgentPolicy{
+		"a": {Rules: []PolicyRule{{Secrets: []string{"Y"}}}},
+		"c": {Rules: []PolicyRule{{}}},
+	}}}
+	got := diffEngines(prev, next)
+	want := []string{
+		"workspace: w1 → w2",
+		`policy: default "" → "deny"`,
+		"policy: agents added: c",
+		"policy: agents removed: b",
+		"policy: agents changed: a",
+		"upstream: added",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("diffEngines = %q\nwant %q", got, want)
+	}
+	if got := diffList("allowlist", []string{"a.com", "b.com"}, []string{"b.com", "c.com"}); !slices.Equal(got, []string{"allowlist: added c.com", "allowlist: removed a.com"}) {
+		t.Errorf("diffList = %q", got)
+	}
+}
+
+func TestAdminReload(t *testing.T) {
+	p, dir := reloadProject(t)
+	writePolicy(t, dir, "deployer")
+	if _, err := p.Engine(); err != nil {
+		t.Fatal(err)
+	}
+	srv := NewProjectServer(0, p.set)
+	srv.AdminToken = "admin-token"
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+
+	reload := func(token string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", ts.URL+"/admin/reload", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body struct {
+			Reloaded []ReloadReport `json:"reloaded"`
+			Error    string         `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		if len(body.Reloaded) == 1 {
+			return resp.StatusCode, strings.Join(body.Reloaded[0].Changes, "; ")
+		}
+		return resp.StatusCode, body.Error
+	}
+
+	if status, _ := reload(""); status != 401 {
+		t.Errorf("no token: %d", status)
+	}
+	if status, _ := reload("wrong-token"); status != 401 {
+		t.Errorf("wrong token: %d", status)
+	}
+	writePolicy(t, dir)
+	if status, changes := reload("admin-token"); status != 200 || changes != "policy: agents removed: deployer" {
+		t.Errorf("reload: %d %q", status, changes)
+	}
+
+	srv.AdminToken = ""
+	if status, _ := reload("admin-token"); status != 401 {
+		t.Errorf("a server without an admin token allowed a reload: %d", status)
+	}
+}
+
+func TestWatchReloadsChangedFiles(t *testing.T) {
+	p, dir := reloadProject(t)
+	writePolicy(t, dir, "deployer")
+	if _, err := p.Engine(); err != nil {
+		t.Fatal(err)
+	}
+
+	reports := make(chan *ReloadReport, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.set.Watch(ctx, 10*time.Millisecond, func(r *ReloadReport) { reports <- r })
+
+	time.Sleep(50 * time.Millisecond) // let Watch see the files as they are
+	writePolicy(t, dir, "deployer", "indexer")
+	select {
+	case r := <-reports:
+		if len(r.Changes) != 1 || r.Changes[0] != "policy: agents added: indexer" {
+			t.Errorf("report = %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the changed policy was not reloaded")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 )
 
@@ -13,8 +15,17 @@ import (
 // CallRequests, executes them through the engine, and returns responses.
 type Server struct {
 	Port   int
-	Engine *Engine
-	mux    *http.ServeMux
+	Engine *Engine // serves every request when Projects is nil
+
+	// Projects, when set, serves several projects from one server; each
+	// request runs in the engine of the project it selects.
+	Projects *ProjectSet
+
+	// AdminToken is the bearer token POST /admin/reload requires; the
+	// endpoint refuses every request when it is empty.
+	AdminToken string
+
+	mux *http.ServeMux
 }
 
 // NewServer creates a proxy server bound to the given port and engine.
@@ -24,25 +35,217 @@ func NewServer(port int, engine *Engine) *Server {
 		Engine: engine,
 		mux:    http.NewServeMux(),
 	}
+	s.routes()
+	return s
+}
+
+// NewProjectServer creates a proxy server for several projects.
+func NewProjectServer(port int, projects *ProjectSet) *Server {
+	s := &Server{
+		Port:     port,
+		Projects: projects,
+		mux:      http.NewServeMux(),
+	}
+	s.routes()
+	return s
+}
+
+func (s *Server) routes() {
 	s.mux.HandleFunc("/proxy", s.handleProxy)
 	s.mux.HandleFunc("/health", s.handleHealth)
-	return s
+	s.mux.HandleFunc("/v1/call", s.handleCall)
+	s.mux.HandleFunc("/v1/batch", s.handleBatch)
+	s.mux.HandleFunc("/v1/openapi.json", s.handleOpenAPI)
+	s.mux.HandleFunc("/admin/reload", s.handleAdminReload)
+	s.mux.HandleFunc("/projects/{project}/", s.handleProjectRoute)
+	s.mux.HandleFunc("/", s.handleGRPC)
+}
+
+// Handler returns the server's routes, for serving them on a listener of the
+// caller's choosing.
+func (s *Server) Handler() http.Handler {
+	return s.mux
 }
 
 // Start begins listening and serving. This blocks until the server is stopped.
+// Besides HTTP/1.1 it accepts cleartext HTTP/2 (prior knowledge), which gRPC
+// clients use.
 func (s *Server) Start() error {
-	addr := fmt.Sprintf("localhost:%d", s.Port)
-	return http.ListenAndServe(addr, s.mux)
+	return s.httpServer().ListenAndServe()
+}
+
+// StartUnix serves on a Unix domain socket at path instead of a TCP port.
+// Only the current user can connect: the socket is created with mode 0600.
+func (s *Server) StartUnix(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	if err := os.Chmod(path, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	return s.httpServer().Serve(l)
+}
+
+func (s *Server) httpServer() *http.Server {
+	srv := &http.Server{
+		Addr:      fmt.Sprintf("localhost:%d", s.Port),
+		Handler:   s.mux,
+		Protocols: new(http.Protocols),
+	}
+	srv.Protocols.SetHTTP1(true)
+	srv.Protocols.SetUnencryptedHTTP2(true)
+	return srv
 }
 
-// handleHealth is a simple health check endpoint.
+// handleHealth is a simple health check endpoint. A multi-project server
+// lists its projects and whether each has been loaded yet.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	type projectHealth struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		WorkspaceID string `json:"workspace_id"`
+		Loaded      bool   `json:"loaded"`
+		Default     bool   `json:"default,omitempty"`
+	}
+	health := struct {
+		Status   string          `json:"status"`
+		Project  string          `json:"project,omitempty"`
+		Projects []projectHealth `json:"projects,omitempty"`
+	}{Status: "ok"}
+
+	if s.Projects == nil {
+		health.Project = s.Engine.ProjectID
+	} else {
+		if s.Projects.Default != nil {
+			health.Project = s.Projects.Default.ID
+		}
+		for _, p := range s.Projects.List() {
+			health.Projects = append(health.Projects, projectHealth{
+				ID:          p.ID,
+				Name:        p.Name,
+				WorkspaceID: p.WorkspaceID,
+				Loaded:      p.Loaded(),
+				Default:     p == s.Projects.Default,
+			})
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(map[string]string{
-		"status":  "ok",
-		"project": s.Engine.ProjectID,
-	})
+	json.NewEncoder(w).Encode(health)
+}
+
+// handleCall serves POST /v1/call: an APICallRequest in, an APICallResponse
+// out. Anything the upstream answered, and calls the engine blocked, are 200s
+// whose envelope carries the status; malformed requests are 400s and
+// unreachable upstreams 502s, with a JSON error.
+func (s *Server) handleCall(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, 405, "/v1/call only accepts POST")
+		return
+	}
+
+	var body APICallRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeError(w, 400, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	req, err := callRequest(&body)
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+	engine, status, err := s.engineFor(r, req.AgentToken)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	result, err := engine.Execute(req)
+	if err != nil {
+		writeError(w, 502, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(NewAPICallResponse(result))
+}
+
+// handleBatch serves POST /v1/batch: several APICallRequests run concurrently
+// through Engine.ExecuteBatch. A call that is malformed or fails gets an error
+// in its slot; the others still run.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, 405, "/v1/batch only accepts POST")
+		return
+	}
+
+	var body APIBatchRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeError(w, 400, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if len(body.Calls) == 0 || len(body.Calls) > MaxBatchCalls {
+		writeError(w, 400, fmt.Sprintf("a batch needs between 1 and %d calls", MaxBatchCalls))
+		return
+	}
+
+	// Routed by the agent token only when every call carries the same one
+	token := body.Calls[0].AgentToken
+	for _, c := range body.Calls {
+		if c.AgentToken != token {
+			token = ""
+		}
+	}
+	engine, status, err := s.engineFor(r, token)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	out := APIBatchResponse{Results: make([]APIBatchResult, len(body.Calls))}
+	var reqs []CallRequest
+	var slots []int
+	for i, c := range body.Calls {
+		req, err := callRequest(&c)
+		if err != nil {
+			out.Results[i].Error = err.Error()
+			continue
+		}
+		reqs = append(reqs, req)
+		slots = append(slots, i)
+	}
+
+	for j, res := range engine.ExecuteBatch(reqs, body.Concurrency) {
+		if res.Err != nil {
+			out.Results[slots[j]].Error = res.Err.Error()
+		} else {
+			out.Results[slots[j]].Response = NewAPICallResponse(res.Result)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleOpenAPI serves the OpenAPI document for the JSON API.
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(OpenAPISpec)
 }
 
 // handleProxy processes incoming proxy requests.
@@ -60,7 +263,15 @@ func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
 //
 // Optional headers:
 //   - X-AS-Method: HTTP method (default: GET)
-//   - X-AS-Agent-ID: Agent identifier for audit logging
+//   - X-AS-Agent-ID: Agent identifier for audit logging and policy
+//   - X-AS-Agent-Token: Proxy token proving the agent identity (see Policy)
+//   - X-AS-Capture: $.json.path=SECRET_KEY  → store response value in keychain (repeatable)
+//   - X-AS-Dry-Run: true: run every check and build the request, but return an
+//     explanation instead of sending it
+//   - X-AS-Project: project name or ID, on a proxy serving several projects
+//
+// A request with Upgrade: websocket is proxied as a WebSocket: the handshake
+// carries the injections, then frames are relayed both ways.
 func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	targetURL := r.Header.Get("X-AS-Target-URL")
 	if targetURL == "" {
@@ -74,6 +285,7 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	agentID := r.Header.Get("X-AS-Agent-ID")
+	agentToken := r.Header.Get("X-AS-Agent-Token")
 
 	// Parse injection headers
 	injections := parseInjections(r.Header)
@@ -82,6 +294,29 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	captures, err := parseCaptures(r.Header.Values("X-AS-Capture"))
+	if err != nil {
+		writeError(w, 400, err.Error())
+		return
+	}
+
+	engine, status, err := s.engineFor(r, agentToken)
+	if err != nil {
+		writeError(w, status, err.Error())
+		return
+	}
+
+	if IsWebSocketUpgrade(r.Header) {
+		s.handleWebSocket(w, r, engine, CallRequest{
+			TargetURL:  targetURL,
+			Headers:    forwardHeaders(r.Header),
+			Injections: injections,
+			AgentID:    agentID,
+			AgentToken: agentToken,
+		})
+		return
+	}
+
 	// Read request body
 	var body []byte
 	if r.Body != nil {
@@ -93,22 +328,17 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Build extra headers (everything that's not X-AS-*)
-	headers := make(map[string]string)
-	for k, v := range r.Header {
-		if !strings.HasPrefix(k, "X-As-") && !strings.HasPrefix(k, "X-AS-") {
-			headers[k] = v[0]
-		}
-	}
-
 	// Execute through engine
-	result, err := s.Engine.Execute(CallRequest{
+	result, err := engine.Execute(CallRequest{
 		TargetURL:  targetURL,
 		Method:     method,
-		Headers:    headers,
+		Headers:    forwardHeaders(r.Header),
 		Body:       body,
 		Injections: injections,
+		Captures:   captures,
 		AgentID:    agentID,
+		AgentToken: agentToken,
+		DryRun:     r.Header.Get("X-AS-Dry-Run") == "true",
 	})
 
 	if err != nil {
@@ -117,6 +347,9 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Forward upstream response
+	if len(result.Captured) > 0 {
+		w.Header().Set("X-AS-Captured", strings.Join(result.Captured, ","))
+	}
 	for k, vals := range result.Headers {
 		for _, v := range vals {
 			w.Header().Add(k, v)
@@ -126,6 +359,65 @@ func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	w.Write(result.Body)
 }
 
+// forwardHeaders returns the extra headers to send upstream (everything that's not X-AS-*).
+func forwardHeaders(h http.Header) map[string][]string {
+	headers := make(map[string][]string)
+	for k, v := range h {
+		if !strings.HasPrefix(k, "X-As-") && !strings.HasPrefix(k, "X-AS-") {
+			headers[k] = v
+		}
+	}
+	return headers
+}
+
+// handleWebSocket completes the client's upgrade once the upstream has
+// accepted its own, then relays frames until either side closes.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request, engine *Engine, req CallRequest) {
+	session, result, err := engine.DialWebSocket(req)
+	if err != nil {
+		writeError(w, 502, err.Error())
+		return
+	}
+	if result != nil {
+		for k, vals := range result.Headers {
+			for _, v := range vals {
+				w.Header().Add(k, v)
+			}
+		}
+		w.WriteHeader(result.StatusCode)
+		w.Write(result.Body)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		session.Close()
+		writeError(w, 500, "WebSocket upgrade is not supported by this connection")
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		session.Close()
+		writeError(w, 500, "WebSocket upgrade failed")
+		return
+	}
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	session.Header.Write(rw)
+	rw.WriteString("\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		session.Close()
+		return
+	}
+
+	_ = session.Relay(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{rw.Reader, conn, conn})
+}
+
 // parseInjections extracts all X-AS-Inject-* headers and converts them to Injections.
 func parseInjections(headers http.Header) []Injection {
 	var injections []Injection
@@ -163,6 +455,22 @@ func parseInjections(headers http.Header) []Injection {
 	return injections
 }
 
+// parseCaptures converts X-AS-Capture values ("$.path=SECRET_KEY") into Captures.
+func parseCaptures(values []string) ([]Capture, error) {
+	var captures []Capture
+	for _, v := range values {
+		idx := strings.LastIndex(v, "=")
+		if idx <= 0 || idx == len(v)-1 {
+			return nil, fmt.Errorf("X-AS-Capture must be in $.json.path=SECRET_KEY format, got %q", v)
+		}
+		captures = append(captures, Capture{
+			Path:      strings.TrimSpace(v[:idx]),
+			SecretKey: strings.TrimSpace(v[idx+1:]),
+		})
+	}
+	return captures, nil
+}
+
 // writeError writes a JSON error response.
 func writeError(w http.ResponseWriter, statusCode int, message string) {
 	w.Header().Set("Content-Type", "application/json")
@@ -171,3 +479,110 @@ func writeError(w http.ResponseWriter, statusCode int, message string) {
 		"error": message,
 	})
 }
+
+// handleGRPC relays a gRPC call. The client dials the proxy in plaintext and
+// sends as metadata:
+//   - x-as-target-url: the upstream origin, e.g. https://pubsub.googleapis.com
+//   - x-as-inject-bearer / x-as-inject-basic / x-as-inject-header-<name>: SECRET_KEY
+//   - optionally x-as-agent-id, x-as-agent-token and x-as-project
+//
+// The RPC path (/package.Service/Method) is appended to the target. Requests
+// and responses stream in both directions; trailers are passed through.
+func (s *Server) handleGRPC(w http.ResponseWriter, r *http.Request) {
+	if !IsGRPC(r) {
+		http.NotFound(w, r)
+		return
+	}
+
+	base := r.Header.Get("X-AS-Target-URL")
+	if base == "" {
+		writeGRPCError(w, grpcInvalidArgument, "x-as-target-url metadata is required")
+		return
+	}
+	injections := parseInjections(r.Header)
+	if len(injections) == 0 {
+		writeGRPCError(w, grpcInvalidArgument, "at least one x-as-inject-* metadata entry is required")
+		return
+	}
+	engine, status, err := s.engineFor(r, r.Header.Get("X-AS-Agent-Token"))
+	if err != nil {
+		code := grpcInvalidArgument
+		if status == http.StatusInternalServerError {
+			code = grpcUnavailable
+		}
+		writeGRPCError(w, code, err.Error())
+		return
+	}
+
+	call, result, err := engine.StartGRPC(r.Context(), CallRequest{
+		TargetURL:  strings.TrimSuffix(base, "/") + r.URL.Path,
+		Headers:    forwardHeaders(r.Header),
+		Injections: injections,
+		AgentID:    r.Header.Get("X-AS-Agent-ID"),
+		AgentToken: r.Header.Get("X-AS-Agent-Token"),
+	}, r.Body)
+	if err != nil {
+		writeGRPCError(w, grpcUnavailable, err.Error())
+		return
+	}
+	if result != nil {
+		var blocked struct {
+			Error   string `json:"error"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(result.Body, &blocked)
+		writeGRPCError(w, grpcPermissionDenied, fmt.Sprintf("%s: %s", blocked.Error, blocked.Message))
+		return
+	}
+	defer call.Finish()
+
+	for k, vals := range call.Response.Header {
+		w.Header()[k] = vals
+	}
+	w.WriteHeader(call.Response.StatusCode)
+
+	// Flush every chunk so streaming RPCs see each message as it arrives
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := call.Response.Body.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return
+			}
+			_ = rc.Flush()
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	for k, vals := range call.Trailer() {
+		for _, v := range vals {
+			w.Header().Add(http.TrailerPrefix+k, v)
+		}
+	}
+}
+
+// writeGRPCError sends a trailers-only gRPC response carrying status and message.
+func writeGRPCError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", fmt.Sprintf("%d", status))
+	w.Header().Set("Grpc-Message", grpcEncodeMessage(message))
+	w.WriteHeader(200)
+}
+
+// grpcEncodeMessage percent-encodes a grpc-message value as the gRPC HTTP/2
+// protocol requires.
+func grpcEncodeMessage(msg string) string {
+	var sb strings.Builder
+	for i := 0; i < len(msg); i++ {
+		c := msg[i]
+		if c >= 0x20 && c <= 0x7e && c != '%' {
+			sb.WriteByte(c)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		}
+	}
+	return sb.String()
+}
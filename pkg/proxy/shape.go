@@ -0,0 +1,336 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ResponseTTL is how long a truncated response can be continued with a cursor.
+const ResponseTTL = 15 * time.Minute
+
+// shape applies the caller's select and max_bytes to an already redacted
+// result, keeping the rest for a cursor when it has to cut the body short.
+func (e *Engine) shape(result *CallResult, req CallRequest) {
+	body := result.Body
+	s := &Shaping{Select: req.Select}
+	if req.Select != "" {
+		selected, err := selectJSON(body, req.Select)
+		if err != nil {
+			s.SelectError = err.Error()
+		} else {
+			body = selected
+		}
+	}
+
+	result.Body = sliceBody(body, 0, req.MaxBytes)
+	s.Length, s.Total = len(result.Body), len(body)
+	if s.Length < s.Total && e.Responses != nil {
+		id, err := e.Responses.save(&storedResponse{
+			ProjectID:   e.ProjectID,
+			AgentID:     req.AgentID,
+			StatusCode:  result.StatusCode,
+			ContentType: firstHeader(result.Headers, "Content-Type"),
+			Select:      s.Select,
+			MaxBytes:    req.MaxBytes,
+			Body:        body,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to keep the truncated response: %v\n", err)
+		} else {
+			s.NextCursor = formatCursor(id, s.Length)
+		}
+	}
+	result.Headers["Content-Length"] = []string{strconv.Itoa(len(result.Body))}
+	result.Shaping = s
+}
+
+// continueResponse serves the slice of a stored response a cursor points at.
+// Nothing is sent upstream. Only the agent whose call stored the response can
+// continue it, so a cursor is no way around that agent's policy.
+func (e *Engine) continueResponse(req CallRequest) (*CallResult, error) {
+	id, offset, err := parseCursor(req.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if e.Responses == nil {
+		return nil, fmt.Errorf("response cursors are unavailable")
+	}
+	stored, err := e.Responses.load(id)
+	if err != nil {
+		return nil, err
+	}
+	agent, ok := e.cursorAgent(req)
+	if stored.ProjectID != e.ProjectID || !ok || stored.AgentID != agent || offset > len(stored.Body) {
+		return nil, fmt.Errorf("invalid cursor %q", req.Cursor)
+	}
+
+	maxBytes := req.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = stored.MaxBytes
+	}
+	body := sliceBody(stored.Body, offset, maxBytes)
+	s := &Shaping{Select: stored.Select, Offset: offset, Length: len(body), Total: len(stored.Body)}
+	if end := offset + len(body); end < len(stored.Body) {
+		s.NextCursor = formatCursor(id, end)
+	}
+	headers := map[string][]string{"Content-Length": {strconv.Itoa(len(body))}}
+	if stored.ContentType != "" {
+		headers["Content-Type"] = []string{stored.ContentType}
+	}
+	return &CallResult{StatusCode: stored.StatusCode, Headers: headers, Body: body, Shaping: s}, nil
+}
+
+// cursorAgent is who is continuing a response: the identity the policy
+// proves, as the call that stored it was audited under, or the claimed
+// AgentID when there is no policy. ok is false when the policy refuses the
+// caller's identity.
+func (e *Engine) cursorAgent(req CallRequest) (agent string, ok bool) {
+	if e.Policy == nil {
+		return req.AgentID, true
+	}
+	agent, reason, _ := e.Policy.identify(req.AgentID, req.AgentToken)
+	return agent, reason == ""
+}
+
+func firstHeader(h map[string][]string, name string) string {
+	if len(h[name]) > 0 {
+		return h[name][0]
+	}
+	return ""
+}
+
+// sliceBody returns at most max bytes of body from offset, all of it when max
+// is not positive. The cut never splits a UTF-8 character and falls after a
+// line break when there is one in the second half of the slice.
+func sliceBody(body []byte, offset, max int) []byte {
+	rest := body[offset:]
+	if max <= 0 || len(rest) <= max {
+		return rest
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(rest[cut]) {
+		cut--
+	}
+	if i := bytes.LastIndexByte(rest[:cut], '\n'); i >= cut/2 {
+		cut = i + 1
+	}
+	if cut == 0 {
+		cut = max
+	}
+	return rest[:cut]
+}
+
+// selectJSON projects a JSON body. expr is a JSON path as in captures, where
+// [*] maps the rest of the path over an array's elements and a trailing
+// {a,b.c} keeps only those fields:
+//
+//	$.data[*].id
+//	$.data[*].{id,customer.email}
+//	$.items[0].{name,price}
+func selectJSON(body []byte, expr string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("the response is not JSON")
+	}
+
+	path := strings.TrimSpace(expr)
+	var fields []string
+	if i := strings.LastIndexByte(path, '{'); i >= 0 && strings.HasSuffix(path, "}") {
+		for _, f := range strings.Split(path[i+1:len(path)-1], ",") {
+			if f = strings.TrimSpace(f); f == "" {
+				return nil, fmt.Errorf("empty field in %q", expr)
+			}
+			fields = append(fields, f)
+		}
+		path = strings.TrimSuffix(path[:i], ".")
+	}
+
+	var steps [][]pathSegment
+	for _, part := range strings.Split(path, "[*]") {
+		var segments []pathSegment
+		if part != "" && part != "$" {
+			var err error
+			if segments, err = parseJSONPath(part); err != nil {
+				return nil, err
+			}
+		}
+		steps = append(steps, segments)
+	}
+	fieldPaths := make([][]pathSegment, len(fields))
+	for i, f := range fields {
+		var err error
+		if fieldPaths[i], err = parseJSONPath(f); err != nil {
+			return nil, err
+		}
+	}
+
+	value, ok := selectValue(doc, steps, fields, fieldPaths)
+	if !ok {
+		return nil, fmt.Errorf("it matched nothing")
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(value); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// selectValue walks steps, mapping each step after the first over an array.
+// Elements the rest of the path does not match are left out.
+func selectValue(v interface{}, steps [][]pathSegment, fields []string, fieldPaths [][]pathSegment) (interface{}, bool) {
+	v, ok := lookupJSONPath(v, steps[0])
+	if !ok {
+		return nil, false
+	}
+	if len(steps) > 1 {
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		out := make([]interface{}, 0, len(arr))
+		for _, el := range arr {
+			if sel, ok := selectValue(el, steps[1:], fields, fieldPaths); ok {
+				out = append(out, sel)
+			}
+		}
+		return out, true
+	}
+	if len(fields) == 0 {
+		return v, true
+	}
+	if arr, ok := v.([]interface{}); ok {
+		out := make([]interface{}, len(arr))
+		for i, el := range arr {
+			out[i] = pickFields(el, fields, fieldPaths)
+		}
+		return out, true
+	}
+	return pickFields(v, fields, fieldPaths), true
+}
+
+func pickFields(v interface{}, fields []string, fieldPaths [][]pathSegment) map[string]interface{} {
+	out := make(map[string]interface{}, len(fields))
+	for i, f := range fields {
+		if val, ok := lookupJSONPath(v, fieldPaths[i]); ok {
+			out[f] = val
+		}
+	}
+	return out
+}
+
+// ResponseStore keeps truncated response bodies, already redacted, one file
+// each, so a cursor issued by one process can be continued by another. Files
+// expire after ResponseTTL.
+type ResponseStore struct {
+	Dir string
+}
+
+type storedResponse struct {
+	ProjectID   string    `json:"project_id"`
+	AgentID     string    `json:"agent_id"`
+	CreatedAt   time.Time `json:"created_at"`
+	StatusCode  int       `json:"status_code"`
+	ContentType string    `json:"content_type,omitempty"`
+	Select      string    `json:"select,omitempty"`
+	MaxBytes    int       `json:"max_bytes"`
+	Body        []byte    `json:"body"`
+}
+
+// DefaultResponseDir returns ~/.agentsecrets/responses
+func DefaultResponseDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".agentsecrets", "responses"), nil
+}
+
+// NewResponseStore opens the store in dir, or the default directory if dir is empty.
+func NewResponseStore(dir string) (*ResponseStore, error) {
+	if dir == "" {
+		var err error
+		if dir, err = DefaultResponseDir(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("cannot create responses directory: %w", err)
+	}
+	return &ResponseStore{Dir: dir}, nil
+}
+
+func (s *ResponseStore) path(id string) string {
+	return filepath.Join(s.Dir, id+".json")
+}
+
+// save stores r under a new unguessable ID, first removing expired responses.
+func (s *ResponseStore) save(r *storedResponse) (string, error) {
+	s.prune()
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("generate response id: %w", err)
+	}
+	id := hex.EncodeToString(buf)
+	r.CreatedAt = time.Now().UTC()
+	return id, writeJSONAtomic(s.Dir, s.path(id), r)
+}
+
+func (s *ResponseStore) load(id string) (*storedResponse, error) {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("cursor expired or unknown — make the call again")
+		}
+		return nil, err
+	}
+	var r storedResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("parse stored response %s: %w", id, err)
+	}
+	if time.Since(r.CreatedAt) > ResponseTTL {
+		os.Remove(s.path(id))
+		return nil, fmt.Errorf("cursor expired or unknown — make the call again")
+	}
+	return &r, nil
+}
+
+func (s *ResponseStore) prune() {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err == nil && strings.HasSuffix(e.Name(), ".json") && time.Since(info.ModTime()) > ResponseTTL {
+			os.Remove(filepath.Join(s.Dir, e.Name()))
+		}
+	}
+}
+
+// A cursor is a stored response ID and the offset of the next slice.
+func formatCursor(id string, offset int) string {
+	return fmt.Sprintf("%s:%d", id, offset)
+}
+
+func parseCursor(cursor string) (string, int, error) {
+	id, off, ok := strings.Cut(cursor, ":")
+	offset, err := strconv.Atoi(off)
+	if !ok || !validHexID(id) || err != nil || offset < 0 {
+		return "", 0, fmt.Errorf("invalid cursor %q", cursor)
+	}
+	return id, offset, nil
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSelectJSON(t *testing.T) {
+	body := []byte(`{"data":[{"id":1,"email":"a@x.com","customer":{"name":"Ann"}},{"id":2,"email":"b@x.com","customer":{"name":"Bob"}},{"note":"no id"}],"has_more":true}`)
+
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"$.has_more", `true`},
+		{"$.data[*].id", "[\n  1,\n  2\n]"},
+		{"$.data[1].customer.name", `"Bob"`},
+		{"$.data[*].{id,customer.name}", "[\n  {\n    \"customer.name\": \"Ann\",\n    \"id\": 1\n  },\n  {\n    \"customer.name\": \"Bob\",\n    \"id\": 2\n  },\n  {}\n]"},
+		{"$.data{id}", "[\n  {\n    \"id\": 1\n  },\n  {\n    \"id\": 2\n  },\n  {}\n]"},
+	}
+	for _, tt := range tests {
+		got, err := selectJSON(body, tt.expr)
+		if err != nil {
+			t.Errorf("selectJSON(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if string(got) != tt.want {
+			t.Errorf("selectJSON(%q) = %s, want %s", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"$.missing", "$.has_more[*].id", "$.data[*].{id,}", "$.data[x]"} {
+		if _, err := selectJSON(body, expr); err == nil {
+			t.Errorf("selectJSON(%q) should fail", expr)
+		}
+	}
+	if _, err := selectJSON([]byte("<html>"), "$.a"); err == nil {
+		t.Error("selectJSON on HTML should fail")
+	}
+}
+
+func TestSliceBody(t *testing.T) {
+	body := []byte("line one\nline two\nünïcode")
+	if got := sliceBody(body, 0, 0); string(got) != string(body) {
+		t.Errorf("no limit = %q", got)
+	}
+	if got := sliceBody(body, 0, 12); string(got) != "line one\n" {
+		t.Errorf("cut at line break = %q", got)
+	}
+	if got := sliceBody(body, 18, 2); string(got) != "ü" {
+		t.Errorf("cut inside a character = %q", got)
+	}
+	if got := sliceBody(body, 18, 1); len(got) != 1 {
+		t.Errorf("a limit smaller than a character still makes progress, got %q", got)
+	}
+}
+
+func TestExecuteShapesAfterRedaction(t *testing.T) {
+	// The secret straddles where max_bytes would cut
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"items":[{"id":1,"token":"sk_test_123"},{"id":2},{"id":3}]}`))
+	}))
+	defer upstream.Close()
+
+	engine, _ := redirectEngine(t)
+	engine.Responses = &ResponseStore{Dir: t.TempDir()}
+	req := CallRequest{
+		TargetURL:  upstream.URL,
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+		MaxBytes:   30,
+		AgentID:    "agent-a",
+	}
+	result, err := engine.Execute(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := result.Shaping
+	if s == nil || s.Offset != 0 || s.Length != len(result.Body) || s.NextCursor == "" {
+		t.Fatalf("Shaping = %+v", s)
+	}
+	if !result.Redacted || strings.Contains(string(result.Body), "sk_test") {
+		t.Errorf("first slice leaks the secret: %q", result.Body)
+	}
+
+	// Following cursors reassembles the redacted body, without calling upstream
+	upstream.Close()
+	full := string(result.Body)
+	for cursor := s.NextCursor; cursor != ""; {
+		next, err := engine.Execute(CallRequest{Cursor: cursor, AgentID: "agent-a"})
+		if err != nil {
+			t.Fatalf("cursor %s: %v", cursor, err)
+		}
+		if next.Shaping.Offset != len(full) || next.StatusCode != 200 {
+			t.Fatalf("cursor %s: Shaping = %+v", cursor, next.Shaping)
+		}
+		full += string(next.Body)
+		cursor = next.Shaping.NextCursor
+	}
+	if want := `{"items":[{"id":1,"token":"[REDACTED_BY_AGENTSECRETS]"},{"id":2},{"id":3}]}`; full != want {
+		t.Errorf("reassembled body = %s, want %s", full, want)
+	}
+
+	for _, bad := range []string{"nothex:1", s.NextCursor + "0000", "00ff:1"} {
+		if _, err := engine.Execute(CallRequest{Cursor: bad, AgentID: "agent-a"}); err == nil {
+			t.Errorf("cursor %q should fail", bad)
+		}
+	}
+
+	// A cursor is bound to the agent whose call stored the response
+	if _, err := engine.Execute(CallRequest{Cursor: s.NextCursor, AgentID: "agent-b"}); err == nil {
+		t.Error("another agent's cursor should fail")
+	}
+}
+
+func TestExecuteSelectFallsBack(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rate limited", http.StatusTooManyRequests)
+	}))
+	defer upstream.Close()
+
+	engine, _ := redirectEngine(t)
+	result, err := engine.Execute(CallRequest{
+		TargetURL:  upstream.URL,
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+		Select:     "$.data[*].id",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Body) != "rate limited\n" || result.Shaping.SelectError == "" {
+		t.Errorf("body = %q, Shaping = %+v", result.Body, result.Shaping)
+	}
+	if !strings.Contains(result.Shaping.Notice(), "was not applied") {
+		t.Errorf("Notice() = %q", result.Shaping.Notice())
+	}
+}
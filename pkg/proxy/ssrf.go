@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SSRFError is returned when an upstream host resolves to an address the
+// engine refuses to send credentials to.
+type SSRFError struct {
+	Host string
+	IP   string
+}
+
+func (e *SSRFError) Error() string {
+	return fmt.Sprintf("%s resolves to %s, a private, loopback or link-local address. If this is intended, add it to internal_domains in %s", e.Host, e.IP, DefaultUpstreamPath)
+}
+
+// blockedNets are special-purpose ranges not covered by the net.IP predicates.
+var blockedNets = []*net.IPNet{
+	mustCIDR("0.0.0.0/8"),     // "this network"
+	mustCIDR("100.64.0.0/10"), // carrier-grade NAT, includes some cloud metadata endpoints
+	mustCIDR("192.0.0.0/24"),  // IETF protocol assignments
+	mustCIDR("198.18.0.0/15"), // benchmarking
+	mustCIDR("64:ff9b::/96"),  // NAT64, maps onto any IPv4 address
+	mustCIDR("fec0::/10"),     // deprecated site-local
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// blockedIP reports whether ip is loopback, private, link-local (which covers
+// the 169.254.169.254 metadata endpoint) or otherwise not a public address.
+func blockedIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, n := range blockedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialGuard resolves each upstream host once, refuses blocked addresses and
+// dials the addresses it checked, so a DNS answer that changes between the
+// check and the connection (DNS rebinding) cannot redirect the request.
+type dialGuard struct {
+	upstream *UpstreamConfig
+	secret   SecretResolver // for proxy credentials
+	dialer   *net.Dialer
+	resolver *net.Resolver
+}
+
+// newGuardedTransport returns http.DefaultTransport with every dial going
+// through a dialGuard, and the guard. Requests must pass through the guard's
+// route first, which picks their egress proxy.
+func newGuardedTransport(upstream *UpstreamConfig, secret SecretResolver) (*http.Transport, *dialGuard) {
+	guard := &dialGuard{
+		upstream: upstream,
+		secret:   secret,
+		dialer:   &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+		resolver: net.DefaultResolver,
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = routedProxy
+	t.DialContext = guard.DialContext
+	return t, guard
+}
+
+// resolve returns the addresses of host, or an SSRFError if any of them is
+// blocked and host is not marked internal.
+func (g *dialGuard) resolve(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = []net.IPAddr{{IP: ip}}
+	} else {
+		var err error
+		if addrs, err = g.resolver.LookupIPAddr(ctx, host); err != nil {
+			return nil, err
+		}
+	}
+	if !g.upstream.IsInternal(host) {
+		for _, a := range addrs {
+			if blockedIP(a.IP) {
+				return nil, &SSRFError{Host: host, IP: a.IP.String()}
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// DialContext connects to one of the checked addresses of addr. Only the
+// connection to the proxy its request was routed through skips the check.
+func (g *dialGuard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if proxyURL, ok := ctx.Value(egressProxyKey{}).(*url.URL); ok && addr == proxyAddr(proxyURL) {
+		return g.dialer.DialContext(ctx, network, addr)
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := g.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for _, a := range addrs {
+		conn, err := g.dialer.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// proxyAddr returns host:port for a proxy URL, filling in the scheme's default port.
+func proxyAddr(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	port := "80"
+	switch u.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
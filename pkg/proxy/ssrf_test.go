@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBlockedIP(t *testing.T) {
+	tests := []struct {
+		ip      string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true}, // cloud metadata
+		{"100.100.100.200", true}, // metadata on some clouds, inside CGNAT space
+		{"fd00:ec2::254", true},   // IPv6 metadata, unique local
+		{"fe80::1", true},
+		{"0.0.0.0", true},
+		{"64:ff9b::a9fe:a9fe", true}, // NAT64 form of 169.254.169.254
+		{"fec0::1", true},            // site-local
+		{"8.8.8.8", false},
+		{"2606:4700:4700::1111", false},
+	}
+	for _, tt := range tests {
+		if got := blockedIP(net.ParseIP(tt.ip)); got != tt.blocked {
+			t.Errorf("blockedIP(%s) = %v, want %v", tt.ip, got, tt.blocked)
+		}
+	}
+}
+
+func TestEngineBlocksPrivateDestination(t *testing.T) {
+	called := false
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+	defer upstream.Close()
+
+	engine := &Engine{
+		ProjectID:     "test-project",
+		Client:        &http.Client{Transport: newUpstreamTransport(nil, nil)},
+		ResolveSecret: mockResolver(map[string]string{"API_KEY": "sk_test_123"}),
+		SkipAllowlist: true,
+	}
+	req := CallRequest{
+		TargetURL:  upstream.URL + "/latest/meta-data",
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+	}
+
+	result, err := engine.Execute(req)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 403 || !strings.Contains(string(result.Body), "ssrf_blocked") {
+		t.Errorf("got %d %s, want 403 ssrf_blocked", result.StatusCode, result.Body)
+	}
+	if called {
+		t.Error("a blocked destination must not be reached")
+	}
+
+	// Marking the host internal lets the same call through
+	upstreamCfg := &UpstreamConfig{InternalDomains: []string{"127.0.0.1"}}
+	engine.Client = &http.Client{Transport: newUpstreamTransport(upstreamCfg, nil)}
+	result, err = engine.Execute(req)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 200 || !called {
+		t.Errorf("internal host: got %d, called=%v; want 200 and reached", result.StatusCode, called)
+	}
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// PinError is returned when an upstream's certificate chain matches none of
+// the SPKI pins configured for its domain.
+type PinError struct {
+	Host string
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("the certificate presented by %s matches none of its pins in %s", e.Host, DefaultUpstreamPath)
+}
+
+// upstreamTransport sends each request through the transport for its domain.
+// Domains with a TLS rule get their own http.Transport, built on first use and
+// kept for the engine's lifetime so connections and TLS sessions are reused.
+type upstreamTransport struct {
+	base     *http.Transport
+	guard    *dialGuard
+	upstream *UpstreamConfig
+	resolve  SecretResolver
+
+	mu     sync.Mutex
+	byRule map[int]*http.Transport
+}
+
+// newUpstreamTransport returns the engine's transport: dial-time address
+// checks for every request, plus the TLS rules of upstream.
+func newUpstreamTransport(upstream *UpstreamConfig, resolve SecretResolver) http.RoundTripper {
+	base, guard := newGuardedTransport(upstream, resolve)
+	return &upstreamTransport{
+		base:     base,
+		guard:    guard,
+		upstream: upstream,
+		resolve:  resolve,
+		byRule:   make(map[int]*http.Transport),
+	}
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, err := t.guard.route(req)
+	if err != nil {
+		return nil, err
+	}
+	i := t.upstream.tlsRule(strings.ToLower(req.URL.Hostname()))
+	if i < 0 {
+		return t.base.RoundTrip(req)
+	}
+	tr, err := t.ruleTransport(i)
+	if err != nil {
+		return nil, err
+	}
+	return tr.RoundTrip(req)
+}
+
+func (t *upstreamTransport) ruleTransport(i int) (*http.Transport, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tr, ok := t.byRule[i]; ok {
+		return tr, nil
+	}
+	cfg, err := buildTLSConfig(t.upstream.TLS[i], t.resolve)
+	if err != nil {
+		return nil, err
+	}
+	tr := t.base.Clone()
+	tr.TLSClientConfig = cfg
+	t.byRule[i] = tr
+	return tr, nil
+}
+
+// buildTLSConfig turns a rule into a tls.Config. Certificate material is read
+// from the keyring here and lives only inside the returned config.
+func buildTLSConfig(rule TLSRule, resolve SecretResolver) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if rule.ClientCert != "" {
+		if resolve == nil {
+			return nil, fmt.Errorf("mTLS for %s needs the keyring", strings.Join(rule.Domains, ", "))
+		}
+		certPEM, err := resolve(rule.ClientCert)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate secret '%s' not found in keychain", rule.ClientCert)
+		}
+		keyPEM, err := resolve(rule.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client key secret '%s' not found in keychain", rule.ClientKey)
+		}
+		cert, err := tls.X509KeyPair([]byte(certPEM), []byte(keyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate %s/%s: %w", rule.ClientCert, rule.ClientKey, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	if rule.CA != "" || rule.CAFile != "" {
+		pool := x509.NewCertPool()
+		if rule.CA != "" {
+			if resolve == nil {
+				return nil, fmt.Errorf("CA secret for %s needs the keyring", strings.Join(rule.Domains, ", "))
+			}
+			caPEM, err := resolve(rule.CA)
+			if err != nil {
+				return nil, fmt.Errorf("CA secret '%s' not found in keychain", rule.CA)
+			}
+			if !pool.AppendCertsFromPEM([]byte(caPEM)) {
+				return nil, fmt.Errorf("CA secret '%s' contains no PEM certificates", rule.CA)
+			}
+		}
+		if rule.CAFile != "" {
+			caPEM, err := os.ReadFile(rule.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("read CA file: %w", err)
+			}
+			if !pool.AppendCertsFromPEM(caPEM) {
+				return nil, fmt.Errorf("CA file %s contains no PEM certificates", rule.CAFile)
+			}
+		}
+		cfg.RootCAs = pool
+	}
+
+	if len(rule.Pins) > 0 {
+		pins := make([][]byte, 0, len(rule.Pins))
+		for _, p := range rule.Pins {
+			hash, err := parsePin(p)
+			if err != nil {
+				return nil, err
+			}
+			pins = append(pins, hash)
+		}
+		// Runs after normal chain verification, so a pin narrows trust but never widens it
+		cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, chain := range cs.VerifiedChains {
+				for _, cert := range chain {
+					sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+					for _, pin := range pins {
+						if bytes.Equal(sum[:], pin) {
+							return nil
+						}
+					}
+				}
+			}
+			return &PinError{Host: cs.ServerName}
+		}
+	}
+
+	return cfg, nil
+}
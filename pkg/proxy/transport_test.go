@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testClientCert returns a self-signed client certificate and key as PEM.
+func testClientCert(t *testing.T) (certPEM, keyPEM string, cert *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "agentsecrets-test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ = x509.ParseCertificate(der)
+	certPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	return certPEM, keyPEM, cert
+}
+
+func mtlsServer(t *testing.T, clientCert *x509.Certificate) *httptest.Server {
+	t.Helper()
+	pool := x509.NewCertPool()
+	pool.AddCert(clientCert)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	upstream.StartTLS()
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+func spkiPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestEngineMutualTLS(t *testing.T) {
+	certPEM, keyPEM, clientCert := testClientCert(t)
+	upstream := mtlsServer(t, clientCert)
+	serverCA := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw}))
+
+	resolve := mockResolver(map[string]string{
+		"API_KEY":     "sk_test_123",
+		"CLIENT_CERT": certPEM,
+		"CLIENT_KEY":  keyPEM,
+		"SERVER_CA":   serverCA,
+	})
+	rule := TLSRule{
+		Domains:    []string{"127.0.0.1"},
+		ClientCert: "CLIENT_CERT",
+		ClientKey:  "CLIENT_KEY",
+		CA:         "SERVER_CA",
+		Pins:       []string{spkiPin(upstream.Certificate())},
+	}
+	newEngine := func(rule TLSRule) *Engine {
+		cfg := &UpstreamConfig{InternalDomains: []string{"127.0.0.1"}, TLS: []TLSRule{rule}}
+		return &Engine{
+			ProjectID:     "test-project",
+			Client:        &http.Client{Transport: newUpstreamTransport(cfg, resolve)},
+			ResolveSecret: resolve,
+			SkipAllowlist: true,
+		}
+	}
+	req := CallRequest{
+		TargetURL:  upstream.URL + "/v1/accounts",
+		Injections: []Injection{{Style: "bearer", SecretKey: "API_KEY"}},
+	}
+
+	engine := newEngine(rule)
+	for i := 0; i < 2; i++ { // the second call reuses the cached transport
+		result, err := engine.Execute(req)
+		if err != nil {
+			t.Fatalf("Execute() error: %v", err)
+		}
+		if result.StatusCode != 200 || string(result.Body) != "agentsecrets-test-client" {
+			t.Fatalf("got %d %q, want 200 with the client certificate's CN", result.StatusCode, result.Body)
+		}
+	}
+
+	// A pin that matches nothing in the chain blocks the call
+	rule.Pins = []string{"sha256/" + base64.StdEncoding.EncodeToString(make([]byte, 32))}
+	result, err := newEngine(rule).Execute(req)
+	if err != nil {
+		t.Fatalf("Execute() error: %v", err)
+	}
+	if result.StatusCode != 403 || !strings.Contains(string(result.Body), "tls_pin_mismatch") {
+		t.Errorf("got %d %s, want 403 tls_pin_mismatch", result.StatusCode, result.Body)
+	}
+
+	// Without the client certificate the server refuses the handshake
+	rule = TLSRule{Domains: []string{"127.0.0.1"}, CA: "SERVER_CA"}
+	if _, err := newEngine(rule).Execute(req); err == nil {
+		t.Error("expected the handshake to fail without a client certificate")
+	}
+}
+
+func TestUpstreamConfigValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		rule TLSRule
+	}{
+		{"no domains", TLSRule{CA: "CA"}},
+		{"cert without key", TLSRule{Domains: []string{"a.com"}, ClientCert: "CERT"}},
+		{"bad pin", TLSRule{Domains: []string{"a.com"}, Pins: []string{"sha1/abc"}}},
+	}
+	for _, tt := range tests {
+		if err := (&UpstreamConfig{TLS: []TLSRule{tt.rule}}).Validate(); err == nil {
+			t.Errorf("%s: expected a validation error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultUpstreamPath is the per-project upstream connection file, relative to
+// the project root.
+var DefaultUpstreamPath = filepath.Join(".agentsecrets", "upstream.yaml")
+
+// DefaultGlobalUpstreamPath returns ~/.agentsecrets/upstream.yaml, which
+// applies to every project.
+func DefaultGlobalUpstreamPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot determine home directory: %w", err)
+	}
+	return filepath.Join(home, ".agentsecrets", "upstream.yaml"), nil
+}
+
+// UpstreamConfig controls how the engine connects to upstream APIs, as
+// opposed to Policy, which controls what each agent may call.
+//
+//	internal_domains:          # may resolve to private, loopback or link-local IPs
+//	  - git.corp.example.com
+//	  - "*.svc.cluster.local"
+//	tls:                       # first rule whose domains match is used
+//	  - domains: [api.bank.example.com]
+//	    client_cert: BANK_CLIENT_CERT   # secret holding a PEM certificate (chain)
+//	    client_key: BANK_CLIENT_KEY     # secret holding the PEM private key
+//	    ca: BANK_CA_BUNDLE              # secret holding PEM CAs; replaces system roots
+//	    ca_file: /etc/ssl/corp-ca.pem   # or a file; both may be combined
+//	    pins: ["sha256/47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="]
+//	proxy: http://proxy.corp.example.com:3128   # or socks5://…, or "direct"
+//	proxy_user: CORP_PROXY_USER                 # secrets holding proxy credentials
+//	proxy_password: CORP_PROXY_PASSWORD
+//	no_proxy: ["*.corp.example.com"]
+//	timeout: 30s
+//	egress:                    # per-domain overrides; first match wins
+//	  - domains: [api.partner.com]
+//	    proxy: socks5://10.8.0.1:1080
+//	    timeout: 2m
+type UpstreamConfig struct {
+	InternalDomains []string  `yaml:"internal_domains,omitempty"`
+	TLS             []TLSRule `yaml:"tls,omitempty"`
+
+	// Proxy is the egress proxy for every upstream. Empty means the
+	// HTTP(S)_PROXY environment variables; "direct" means no proxy.
+	Proxy         string        `yaml:"proxy,omitempty"`
+	ProxyUser     string        `yaml:"proxy_user,omitempty"`
+	ProxyPassword string        `yaml:"proxy_password,omitempty"`
+	NoProxy       []string      `yaml:"no_proxy,omitempty"` // domains reached directly
+	Timeout       time.Duration `yaml:"timeout,omitempty"`  // DefaultTimeout if zero
+	Egress        []EgressRule  `yaml:"egress,omitempty"`
+}
+
+// EgressRule overrides the proxy or timeout for matching domains. An empty
+// Proxy keeps the top-level one.
+type EgressRule struct {
+	Domains       []string      `yaml:"domains"`
+	Proxy         string        `yaml:"proxy,omitempty"`
+	ProxyUser     string        `yaml:"proxy_user,omitempty"`
+	ProxyPassword string        `yaml:"proxy_password,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+}
+
+// TLSRule customises the TLS connection to matching domains. Certificates
+// and keys are referenced by secret name and resolved from the keyring.
+type TLSRule struct {
+	Domains    []string `yaml:"domains"`
+	ClientCert string   `yaml:"client_cert,omitempty"`
+	ClientKey  string   `yaml:"client_key,omitempty"`
+	CA         string   `yaml:"ca,omitempty"`
+	CAFile     string   `yaml:"ca_file,omitempty"`
+	Pins       []string `yaml:"pins,omitempty"` // "sha256/<base64 SPKI hash>"; one must match the chain
+}
+
+// LoadUpstreamConfigs reads the global and the project upstream files and
+// merges them, the project file taking precedence.
+func LoadUpstreamConfigs() (*UpstreamConfig, error) {
+	return loadUpstreamConfigs(DefaultUpstreamPath)
+}
+
+// loadUpstreamConfigs is LoadUpstreamConfigs with the project file at projectPath.
+func loadUpstreamConfigs(projectPath string) (*UpstreamConfig, error) {
+	globalPath, err := DefaultGlobalUpstreamPath()
+	if err != nil {
+		return nil, err
+	}
+	global, err := LoadUpstreamConfig(globalPath)
+	if err != nil {
+		return nil, err
+	}
+	project, err := LoadUpstreamConfig(projectPath)
+	if err != nil {
+		return nil, err
+	}
+	return mergeUpstream(project, global), nil
+}
+
+// mergeUpstream combines two configs: rule lists are concatenated with the
+// project's first, so its rules win, and project settings replace global ones.
+func mergeUpstream(project, global *UpstreamConfig) *UpstreamConfig {
+	if project == nil {
+		return global
+	}
+	if global == nil {
+		return project
+	}
+	merged := *project
+	merged.InternalDomains = append(append([]string{}, project.InternalDomains...), global.InternalDomains...)
+	merged.TLS = append(append([]TLSRule{}, project.TLS...), global.TLS...)
+	merged.Egress = append(append([]EgressRule{}, project.Egress...), global.Egress...)
+	if merged.Proxy == "" {
+		merged.Proxy, merged.ProxyUser, merged.ProxyPassword = global.Proxy, global.ProxyUser, global.ProxyPassword
+	}
+	if merged.NoProxy == nil {
+		merged.NoProxy = global.NoProxy
+	}
+	if merged.Timeout == 0 {
+		merged.Timeout = global.Timeout
+	}
+	return &merged
+}
+
+// LoadUpstreamConfig reads an upstream file. A missing file yields nil, which
+// behaves like an empty config.
+func LoadUpstreamConfig(path string) (*UpstreamConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read upstream config: %w", err)
+	}
+
+	var c UpstreamConfig
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("parse upstream config %s: %w", path, err)
+	}
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid upstream config %s: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Validate checks values that would otherwise only fail at connection time.
+func (c *UpstreamConfig) Validate() error {
+	for i, r := range c.TLS {
+		if len(r.Domains) == 0 {
+			return fmt.Errorf("tls rule %d: domains is required", i+1)
+		}
+		if (r.ClientCert == "") != (r.ClientKey == "") {
+			return fmt.Errorf("tls rule %d: client_cert and client_key must be set together", i+1)
+		}
+		for _, pin := range r.Pins {
+			if _, err := parsePin(pin); err != nil {
+				return fmt.Errorf("tls rule %d: %w", i+1, err)
+			}
+		}
+	}
+	if err := validateProxy(c.Proxy, c.ProxyUser, c.ProxyPassword); err != nil {
+		return err
+	}
+	for i, r := range c.Egress {
+		if len(r.Domains) == 0 {
+			return fmt.Errorf("egress rule %d: domains is required", i+1)
+		}
+		if err := validateProxy(r.Proxy, r.ProxyUser, r.ProxyPassword); err != nil {
+			return fmt.Errorf("egress rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// tlsRule returns the index of the first TLS rule matching host, or -1.
+func (c *UpstreamConfig) tlsRule(host string) int {
+	if c == nil {
+		return -1
+	}
+	for i, r := range c.TLS {
+		if matchAny(r.Domains, host, true) {
+			return i
+		}
+	}
+	return -1
+}
+
+// parsePin decodes a "sha256/<base64>" SPKI pin.
+func parsePin(pin string) ([]byte, error) {
+	b64, ok := strings.CutPrefix(pin, "sha256/")
+	if !ok {
+		return nil, fmt.Errorf("pin %q must start with sha256/", pin)
+	}
+	hash, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil || len(hash) != 32 {
+		return nil, fmt.Errorf("pin %q is not a base64 SHA-256 hash", pin)
+	}
+	return hash, nil
+}
+
+// IsInternal reports whether host was explicitly marked internal and may
+// therefore resolve to a private address.
+func (c *UpstreamConfig) IsInternal(host string) bool {
+	if c == nil {
+		return false
+	}
+	return matchAny(c.InternalDomains, host, true)
+}
@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// llmDomains are the providers whose responses are parsed for usage. The
+// budget config can add OpenAI-compatible ones.
+var llmDomains = []string{
+	"api.openai.com",
+	"*.openai.azure.com",
+	"api.anthropic.com",
+	"generativelanguage.googleapis.com",
+}
+
+// ModelPrice is what a model costs in USD per million tokens. Model may hold
+// "*" wildcards.
+type ModelPrice struct {
+	Model  string  `yaml:"model"`
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// defaultPrices are list prices when this table was written; budget.yaml
+// entries are matched first. More specific patterns come first.
+var defaultPrices = []ModelPrice{
+	{Model: "gpt-4o-mini*", Input: 0.15, Output: 0.60},
+	{Model: "gpt-4o*", Input: 2.50, Output: 10.00},
+	{Model: "gpt-4.1-nano*", Input: 0.10, Output: 0.40},
+	{Model: "gpt-4.1-mini*", Input: 0.40, Output: 1.60},
+	{Model: "gpt-4.1*", Input: 2.00, Output: 8.00},
+	{Model: "o3-mini*", Input: 1.10, Output: 4.40},
+	{Model: "o4-mini*", Input: 1.10, Output: 4.40},
+	{Model: "text-embedding-3-small*", Input: 0.02},
+	{Model: "text-embedding-3-large*", Input: 0.13},
+	{Model: "claude-3-5-haiku*", Input: 0.80, Output: 4.00},
+	{Model: "claude-3-haiku*", Input: 0.25, Output: 1.25},
+	{Model: "claude-*sonnet*", Input: 3.00, Output: 15.00},
+	{Model: "claude-*opus*", Input: 15.00, Output: 75.00},
+	{Model: "gemini-1.5-flash*", Input: 0.075, Output: 0.30},
+	{Model: "gemini-1.5-pro*", Input: 1.25, Output: 5.00},
+	{Model: "gemini-2.0-flash*", Input: 0.10, Output: 0.40},
+}
+
+// priceFor returns the first price matching model, the configured ones first.
+func priceFor(model string, prices []ModelPrice) (ModelPrice, bool) {
+	model = strings.ToLower(model)
+	for _, table := range [][]ModelPrice{prices, defaultPrices} {
+		for _, p := range table {
+			if globMatch(strings.ToLower(p.Model), model) {
+				return p, true
+			}
+		}
+	}
+	return ModelPrice{}, false
+}
+
+// parseUsage extracts the usage block from an LLM response: a JSON body, or
+// a server-sent event stream whose events carry usage piecemeal. It
+// understands OpenAI (chat, responses, embeddings), Anthropic and Gemini
+// formats, and returns nil when there is no usage.
+func parseUsage(body []byte, prices []ModelPrice) *Usage {
+	u := &Usage{}
+	found := false
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		found = mergeUsage(u, trimmed)
+	} else {
+		// Streams report usage in the first event (Anthropic's input tokens),
+		// the last (OpenAI with include_usage) or all of them (Gemini, as
+		// running totals), so keep the largest count seen
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+			if !ok {
+				continue
+			}
+			if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' && mergeUsage(u, data) {
+				found = true
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	if p, ok := priceFor(u.Model, prices); ok {
+		u.CostUSD = (float64(u.InputTokens)*p.Input + float64(u.OutputTokens)*p.Output) / 1e6
+	} else {
+		u.Unpriced = true
+	}
+	return u
+}
+
+// mergeUsage folds the usage in one JSON document into u and reports
+// whether it had any.
+func mergeUsage(u *Usage, data []byte) bool {
+	var doc struct {
+		Model         string          `json:"model"`
+		ModelVersion  string          `json:"modelVersion"` // Gemini
+		Usage         *usageBlock     `json:"usage"`
+		UsageMetadata *usageBlock     `json:"usageMetadata"` // Gemini
+		Message       *usageContainer `json:"message"`       // Anthropic message_start
+		Response      *usageContainer `json:"response"`      // OpenAI responses stream
+	}
+	if json.Unmarshal(data, &doc) != nil {
+		return false
+	}
+	for _, model := range []string{doc.Model, doc.ModelVersion} {
+		if model != "" {
+			u.Model = model
+		}
+	}
+	block := doc.Usage
+	if block == nil {
+		block = doc.UsageMetadata
+	}
+	for _, c := range []*usageContainer{doc.Message, doc.Response} {
+		if c == nil {
+			continue
+		}
+		if c.Model != "" {
+			u.Model = c.Model
+		}
+		if block == nil {
+			block = c.Usage
+		}
+	}
+	if block == nil {
+		return false
+	}
+	u.InputTokens = max(u.InputTokens, block.input())
+	u.OutputTokens = max(u.OutputTokens, block.output())
+	return true
+}
+
+type usageContainer struct {
+	Model string      `json:"model"`
+	Usage *usageBlock `json:"usage"`
+}
+
+// usageBlock holds every provider's spelling of the token counts.
+type usageBlock struct {
+	PromptTokens     int64 `json:"prompt_tokens"`     // OpenAI chat and embeddings
+	CompletionTokens int64 `json:"completion_tokens"` // OpenAI chat
+	InputTokens      int64 `json:"input_tokens"`      // OpenAI responses, Anthropic
+	OutputTokens     int64 `json:"output_tokens"`     // OpenAI responses, Anthropic
+	CacheCreation    int64 `json:"cache_creation_input_tokens"`
+	CacheRead        int64 `json:"cache_read_input_tokens"`
+	PromptTokenCount int64 `json:"promptTokenCount"`     // Gemini
+	CandidatesTokens int64 `json:"candidatesTokenCount"` // Gemini
+	ThoughtsTokens   int64 `json:"thoughtsTokenCount"`   // Gemini, billed as output
+}
+
+func (b *usageBlock) input() int64 {
+	return b.PromptTokens + b.InputTokens + b.CacheCreation + b.CacheRead + b.PromptTokenCount
+}
+
+func (b *usageBlock) output() int64 {
+	return b.CompletionTokens + b.OutputTokens + b.CandidatesTokens + b.ThoughtsTokens
+}
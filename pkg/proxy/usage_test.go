@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseUsage(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		model         string
+		input, output int64
+		cost          float64
+	}{
+		{
+			name:  "openai chat",
+			body:  `{"id":"chatcmpl-1","model":"gpt-4o-2024-08-06","choices":[],"usage":{"prompt_tokens":1000,"completion_tokens":500,"total_tokens":1500}}`,
+			model: "gpt-4o-2024-08-06", input: 1000, output: 500, cost: 0.0075,
+		},
+		{
+			name:  "openai responses",
+			body:  `{"id":"resp_1","model":"gpt-4o-mini","usage":{"input_tokens":2000000,"output_tokens":1000000}}`,
+			model: "gpt-4o-mini", input: 2000000, output: 1000000, cost: 0.90,
+		},
+		{
+			name:  "anthropic with cache",
+			body:  `{"type":"message","model":"claude-3-5-sonnet-20241022","usage":{"input_tokens":100,"cache_read_input_tokens":900,"output_tokens":1000}}`,
+			model: "claude-3-5-sonnet-20241022", input: 1000, output: 1000, cost: 0.018,
+		},
+		{
+			name: "anthropic stream",
+			body: "event: message_start\n" +
+				`data: {"type":"message_start","message":{"model":"claude-3-5-haiku-20241022","usage":{"input_tokens":1000,"output_tokens":1}}}` + "\n\n" +
+				"event: content_block_delta\n" +
+				`data: {"type":"content_block_delta","delta":{"text":"hi"}}` + "\n\n" +
+				"event: message_delta\n" +
+				`data: {"type":"message_delta","usage":{"output_tokens":250}}` + "\n\n",
+			model: "claude-3-5-haiku-20241022", input: 1000, output: 250, cost: 0.0018,
+		},
+		{
+			name: "openai stream with include_usage",
+			body: `data: {"model":"gpt-4o","choices":[{"delta":{"content":"hi"}}],"usage":null}` + "\n\n" +
+				`data: {"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":10,"completion_tokens":20}}` + "\n\n" +
+				"data: [DONE]\n\n",
+			model: "gpt-4o", input: 10, output: 20, cost: 0.000225,
+		},
+		{
+			name: "gemini stream",
+			body: `data: {"candidates":[],"usageMetadata":{"promptTokenCount":40,"candidatesTokenCount":5},"modelVersion":"gemini-2.0-flash"}` + "\r\n\r\n" +
+				`data: {"candidates":[],"usageMetadata":{"promptTokenCount":40,"candidatesTokenCount":60},"modelVersion":"gemini-2.0-flash"}` + "\r\n\r\n",
+			model: "gemini-2.0-flash", input: 40, output: 60, cost: 0.000028,
+		},
+	}
+	for _, tt := range tests {
+		u := parseUsage([]byte(tt.body), nil)
+		if u == nil {
+			t.Errorf("%s: no usage found", tt.name)
+			continue
+		}
+		if u.Model != tt.model || u.InputTokens != tt.input || u.OutputTokens != tt.output || u.Unpriced {
+			t.Errorf("%s: usage = %+v", tt.name, u)
+		}
+		if math.Abs(u.CostUSD-tt.cost) > 1e-9 {
+			t.Errorf("%s: cost = %v, want %v", tt.name, u.CostUSD, tt.cost)
+		}
+	}
+
+	for _, body := range []string{`{"data":[]}`, `not json`, "data: [DONE]\n\n", ""} {
+		if u := parseUsage([]byte(body), nil); u != nil {
+			t.Errorf("parseUsage(%q) = %+v, want nil", body, u)
+		}
+	}
+}
+
+func TestParseUsagePrices(t *testing.T) {
+	body := []byte(`{"model":"llama-3.1-70b","usage":{"prompt_tokens":1000000,"completion_tokens":0}}`)
+	if u := parseUsage(body, nil); !u.Unpriced || u.CostUSD != 0 {
+		t.Errorf("unknown model: %+v", u)
+	}
+	if u := parseUsage(body, []ModelPrice{{Model: "llama-3.1-*", Input: 0.59, Output: 0.79}}); u.Unpriced || u.CostUSD != 0.59 {
+		t.Errorf("configured price: %+v", u)
+	}
+
+	// Configured prices win over the built-in table
+	body = []byte(`{"model":"gpt-4o","usage":{"prompt_tokens":1000000,"completion_tokens":0}}`)
+	if u := parseUsage(body, []ModelPrice{{Model: "gpt-4o", Input: 1}}); u.CostUSD != 1 {
+		t.Errorf("override: %+v", u)
+	}
+}
@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// maxWebSocketMessage caps a data message buffered for redaction.
+const maxWebSocketMessage = 16 << 20
+
+// WebSocket opcodes (RFC 6455 section 5.2).
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+)
+
+// IsWebSocketUpgrade reports whether h asks to upgrade the connection to a WebSocket.
+func IsWebSocketUpgrade(h http.Header) bool {
+	return strings.EqualFold(h.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(h.Get("Connection")), "upgrade")
+}
+
+// WebSocketSession is an upgraded upstream connection whose handshake carried
+// the injected credentials.
+type WebSocketSession struct {
+	Header http.Header // the upstream's 101 response headers
+
+	engine   *Engine
+	call     *checkedCall
+	upstream io.ReadWriteCloser
+	opened   time.Time
+}
+
+// DialWebSocket runs the checks of Execute, then performs the WebSocket
+// handshake upstream with credentials injected. If the call is blocked or the
+// upstream does not switch protocols, it returns a CallResult to relay instead.
+func (e *Engine) DialWebSocket(req CallRequest) (*WebSocketSession, *CallResult, error) {
+	for _, inj := range req.Injections {
+		if inj.Style == "body" || inj.Style == "form" {
+			return nil, nil, fmt.Errorf("injection style %q is not supported for WebSocket handshakes", inj.Style)
+		}
+	}
+	req.Method = "GET"
+	call, blocked, err := e.check(req)
+	if call == nil {
+		return nil, blocked, err
+	}
+
+	target := *call.url
+	switch target.Scheme {
+	case "ws":
+		target.Scheme = "http"
+	case "wss":
+		target.Scheme = "https"
+	}
+	outbound, err := buildOutbound("GET", target.String(), call.req.Headers, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	outbound.Header.Set("Connection", "Upgrade")
+	outbound.Header.Set("Upgrade", "websocket")
+	// Compressed frames could not be redacted
+	outbound.Header.Del("Sec-WebSocket-Extensions")
+	for i, inj := range req.Injections {
+		if err := Inject(outbound, call.secretValues[i], inj); err != nil {
+			return nil, nil, fmt.Errorf("injection failed for %s (%s): %w", inj.SecretKey, inj.Style, err)
+		}
+	}
+	outbound.Host = outbound.URL.Host
+
+	// The timeout covers the handshake only; the upgraded connection outlives it
+	ctx, cancel := context.WithTimeout(context.Background(), e.Upstream.timeoutFor(call.domain))
+	defer cancel()
+
+	transport := http.DefaultTransport
+	if e.Client != nil && e.Client.Transport != nil {
+		transport = e.Client.Transport
+	}
+	opened := time.Now()
+	resp, err := transport.RoundTrip(outbound.WithContext(ctx))
+	if err != nil {
+		if blocked, ok := e.blockForError(call, err); ok {
+			return nil, blocked, nil
+		}
+		return nil, nil, fmt.Errorf("failed to reach upstream: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxWebSocketMessage))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read upstream response: %w", err)
+		}
+		redacted := redactValues(string(body), call.secretValues)
+		resp.Header.Set("Content-Length", fmt.Sprintf("%d", len(redacted)))
+		e.auditWebSocket(call, resp.StatusCode, time.Since(opened), 0, 0, redacted != string(body))
+		return nil, &CallResult{StatusCode: resp.StatusCode, Headers: resp.Header, Body: []byte(redacted), Redacted: redacted != string(body)}, nil
+	}
+
+	upstream, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("upstream connection cannot be upgraded")
+	}
+	e.markDomainSeen(call.domain)
+	resp.Header.Del("Sec-WebSocket-Extensions")
+	return &WebSocketSession{
+		Header:   resp.Header,
+		engine:   e,
+		call:     call,
+		upstream: upstream,
+		opened:   opened,
+	}, nil, nil
+}
+
+// Close closes the upstream connection without relaying.
+func (s *WebSocketSession) Close() error {
+	return s.upstream.Close()
+}
+
+// Relay copies frames between client and upstream until either side closes.
+// Client frames pass through untouched; upstream text and binary messages
+// have secret values replaced before they reach the client. The session is audited when
+// it ends.
+func (s *WebSocketSession) Relay(client io.ReadWriteCloser) error {
+	var sent, received atomic.Int64
+	var redacted atomic.Bool
+	var wg sync.WaitGroup
+	var clientErr, upstreamErr error
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		n, err := io.Copy(s.upstream, client)
+		sent.Add(n)
+		clientErr = err
+		s.upstream.Close()
+	}()
+	go func() {
+		defer wg.Done()
+		counted := &countingReader{r: s.upstream, n: &received}
+		upstreamErr = relayRedacted(bufio.NewReader(counted), client, s.call.secretValues, &redacted)
+		client.Close()
+	}()
+	wg.Wait()
+
+	s.engine.auditWebSocket(s.call, http.StatusSwitchingProtocols, time.Since(s.opened), sent.Load(), received.Load(), redacted.Load())
+
+	for _, err := range []error{upstreamErr, clientErr} {
+		if err != nil && !errors.Is(err, io.EOF) && !isClosedConnError(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *Engine) auditWebSocket(call *checkedCall, code int, duration time.Duration, sent, received int64, redacted bool) {
+	if e.Audit == nil {
+		return
+	}
+	// A refused handshake never opened a session
+	status, reason := "OK", "-"
+	if code != http.StatusSwitchingProtocols {
+		status, reason = "FAILED", "websocket_handshake_refused"
+	} else if redacted {
+		reason = "credential_echo"
+	}
+	_ = e.Audit.Log(AuditEvent{
+		Timestamp:     time.Now().UTC(),
+		SecretKeys:    call.secretKeys,
+		AgentID:       call.req.AgentID,
+		Method:        "WEBSOCKET",
+		TargetURL:     call.req.TargetURL,
+		Domain:        call.domain,
+		AuthStyles:    call.authStyles,
+		StatusCode:    code,
+		DurationMs:    duration.Milliseconds(),
+		Status:        status,
+		Reason:        reason,
+		Redacted:      redacted,
+		ApprovalID:    call.approvalID,
+		BytesSent:     sent,
+		BytesReceived: received,
+	})
+}
+
+// wsFrame is one WebSocket frame with its payload unmasked.
+type wsFrame struct {
+	fin     bool
+	rsv     byte
+	opcode  byte
+	payload []byte
+}
+
+func readFrame(r *bufio.Reader) (*wsFrame, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	f := &wsFrame{
+		fin:    head[0]&0x80 != 0,
+		rsv:    head[0] & 0x70,
+		opcode: head[0] & 0x0f,
+	}
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if length > maxWebSocketMessage {
+		return nil, fmt.Errorf("websocket frame of %d bytes exceeds the %d byte limit", length, maxWebSocketMessage)
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+// writeFrame writes f unmasked, as a server does.
+func writeFrame(w io.Writer, f *wsFrame) error {
+	head := make([]byte, 0, 10)
+	b0 := f.rsv | f.opcode
+	if f.fin {
+		b0 |= 0x80
+	}
+	head = append(head, b0)
+	switch n := len(f.payload); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xffff:
+		head = append(head, 126)
+		head = binary.BigEndian.AppendUint16(head, uint16(n))
+	default:
+		head = append(head, 127)
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err := w.Write(f.payload)
+	return err
+}
+
+// relayRedacted copies upstream frames to the client. Fragments of a text or
+// binary message are joined and redacted as one message, so a secret split
+// across frames is still caught. A binary message that held a secret is
+// changed in length, which the protocol inside it may not expect; leaking the
+// value would be worse. Control frames pass through as they are, except close
+// reasons, which are redacted too.
+func relayRedacted(upstream *bufio.Reader, client io.Writer, secrets []string, redacted *atomic.Bool) error {
+	var message []byte
+	var opcode byte // of the message being joined, 0 between messages
+	redact := func(b []byte) []byte {
+		out := redactValues(string(b), secrets)
+		if out != string(b) {
+			redacted.Store(true)
+		}
+		return []byte(out)
+	}
+
+	for {
+		f, err := readFrame(upstream)
+		if err != nil {
+			return err
+		}
+		switch {
+		case f.opcode == wsText || f.opcode == wsBinary || (f.opcode == wsContinuation && opcode != 0):
+			if f.opcode != wsContinuation {
+				opcode = f.opcode
+			}
+			message = append(message, f.payload...)
+			if len(message) > maxWebSocketMessage {
+				return fmt.Errorf("websocket message exceeds the %d byte limit", maxWebSocketMessage)
+			}
+			if !f.fin {
+				continue
+			}
+			f = &wsFrame{fin: true, opcode: opcode, payload: redact(message)}
+			message, opcode = nil, 0
+		case f.opcode == wsClose && len(f.payload) > 2:
+			reason := redact(f.payload[2:])
+			f.payload = append(f.payload[:2:2], reason...)
+			for len(f.payload) > 125 || !utf8.Valid(f.payload[2:]) { // control frame limit
+				f.payload = f.payload[:len(f.payload)-1]
+			}
+		}
+		if err := writeFrame(client, f); err != nil {
+			return err
+		}
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func isClosedConnError(err error) bool {
+	return err != nil && (errors.Is(err, io.ErrClosedPipe) || strings.Contains(err.Error(), "use of closed network connection"))
+}
@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func wsAccept(key string) string {
+	sum := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC11B65"))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// wsEchoUpstream accepts a WebSocket when the bearer token is right, sends a
+// text and a binary message echoing the token, each split across two frames,
+// then echoes one client message and closes.
+func wsEchoUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_live_abc" || !IsWebSocketUpgrade(r.Header) {
+			http.Error(w, "unauthorized: got "+r.Header.Get("Authorization"), 401)
+			return
+		}
+		if r.Header.Get("Sec-WebSocket-Extensions") != "" {
+			http.Error(w, "compression must not be negotiated", 400)
+			return
+		}
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n",
+			wsAccept(r.Header.Get("Sec-WebSocket-Key")))
+
+		writeFrame(rw, &wsFrame{opcode: wsText, payload: []byte(`{"session":"sk_live`)})
+		writeFrame(rw, &wsFrame{fin: true, opcode: wsContinuation, payload: []byte(`_abc"}`)})
+		writeFrame(rw, &wsFrame{opcode: wsBinary, payload: []byte("\x00sk_li")})
+		writeFrame(rw, &wsFrame{fin: true, opcode: wsContinuation, payload: []byte("ve_abc\xff")})
+		rw.Flush()
+
+		msg, err := readFrame(rw.Reader)
+		if err != nil {
+			return
+		}
+		writeFrame(rw, &wsFrame{fin: true, opcode: wsText, payload: append([]byte("echo:"), msg.payload...)})
+		writeFrame(rw, &wsFrame{fin: true, opcode: wsClose, payload: []byte{0x03, 0xe8}})
+		rw.Flush()
+	}))
+}
+
+// wsClientFrame writes a masked client frame.
+func wsClientFrame(conn net.Conn, opcode byte, payload string) error {
+	mask := [4]byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask[:]...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	_, err := conn.Write(frame)
+	return err
+}
+
+func TestServerProxiesWebSocket(t *testing.T) {
+	upstream := wsEchoUpstream(t)
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	engine.Client = upstream.Client()
+	engine.ResolveSecret = mockResolver(map[string]string{"REALTIME_KEY": "sk_live_abc"})
+	proxySrv := httptest.NewServer(NewServer(0, engine).mux)
+	defer proxySrv.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxySrv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	fmt.Fprintf(conn, "GET /proxy HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Extensions: permessage-deflate\r\n"+
+		"X-AS-Target-URL: %s/realtime\r\nX-AS-Inject-Bearer: REALTIME_KEY\r\n\r\n",
+		key, strings.Replace(upstream.URL, "http://", "ws://", 1))
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 101 {
+		t.Fatalf("handshake status = %d, want 101", resp.StatusCode)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != wsAccept(key) {
+		t.Errorf("Sec-WebSocket-Accept does not match the client key")
+	}
+
+	// The token the upstream echoed across two fragments is redacted
+	f, err := readFrame(br)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"session":"[REDACTED_BY_AGENTSECRETS]"}`; !f.fin || f.opcode != wsText || string(f.payload) != want {
+		t.Errorf("first frame = fin:%v op:%d %q, want %q", f.fin, f.opcode, f.payload, want)
+	}
+	// So is the one in the binary message
+	if f, err = readFrame(br); err != nil {
+		t.Fatal(err)
+	}
+	if want := "\x00[REDACTED_BY_AGENTSECRETS]\xff"; !f.fin || f.opcode != wsBinary || string(f.payload) != want {
+		t.Errorf("binary frame = fin:%v op:%d %q, want %q", f.fin, f.opcode, f.payload, want)
+	}
+
+	if err := wsClientFrame(conn, wsText, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = readFrame(br); err != nil || string(f.payload) != "echo:hello" {
+		t.Fatalf("echo frame = %v, %v", f, err)
+	}
+	if f, err = readFrame(br); err != nil || f.opcode != wsClose {
+		t.Fatalf("expected a close frame, got %v, %v", f, err)
+	}
+	conn.Close()
+
+	// The session is audited once both sides are done
+	var event AuditEvent
+	for i := 0; i < 100; i++ {
+		if event = lastAuditEvent(t, logPath); event.Method == "WEBSOCKET" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if event.Method != "WEBSOCKET" || event.StatusCode != 101 || !event.Redacted {
+		t.Fatalf("audit event = %+v", event)
+	}
+	if event.BytesSent == 0 || event.BytesReceived == 0 {
+		t.Errorf("expected byte counts, got sent=%d received=%d", event.BytesSent, event.BytesReceived)
+	}
+}
+
+func TestServerWebSocketRejectedUpstream(t *testing.T) {
+	upstream := wsEchoUpstream(t)
+	defer upstream.Close()
+
+	engine, logPath := redirectEngine(t)
+	engine.Client = upstream.Client()
+	engine.ResolveSecret = mockResolver(map[string]string{"REALTIME_KEY": "wrong"})
+	proxySrv := httptest.NewServer(NewServer(0, engine).mux)
+	defer proxySrv.Close()
+
+	req, _ := http.NewRequest("GET", proxySrv.URL+"/proxy", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("X-AS-Target-URL", upstream.URL+"/realtime")
+	req.Header.Set("X-AS-Inject-Bearer", "REALTIME_KEY")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 401 {
+		t.Errorf("status = %d, want the upstream's 401", resp.StatusCode)
+	}
+
+	// A refused handshake is audited as a failure, not a session
+	if event := lastAuditEvent(t, logPath); event.Method != "WEBSOCKET" || event.Status != "FAILED" ||
+		event.Reason != "websocket_handshake_refused" || event.StatusCode != 401 {
+		t.Errorf("audit event = %+v", event)
+	}
+}
@@ -0,0 +1,170 @@
+// Package proxyapi defines what the proxy's JSON API sends and receives:
+// the bodies of /v1/call and /v1/batch and the headers callers set. It has
+// no dependencies beyond the standard library, so SDKs can speak to the proxy
+// without linking the proxy, the keyring or the crypto packages.
+package proxyapi
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AgentTokenEnv is the environment variable the CLI and MCP server read an
+// agent's proxy token from. HTTP callers send it as X-AS-Agent-Token.
+const AgentTokenEnv = "AGENTSECRETS_AGENT_TOKEN"
+
+// ProjectHeader selects, by name or ID, the project a request runs in on a
+// server that serves several.
+const ProjectHeader = "X-AS-Project"
+
+// CallRequest is the JSON body of POST /v1/call. Unlike the X-AS-* headers
+// of /proxy it carries repeated headers, binary bodies and injection targets
+// of any spelling.
+type CallRequest struct {
+	URL        string              `json:"url,omitempty"`
+	Method     string              `json:"method,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+	Body       string              `json:"body,omitempty"`        // text body, sent as is
+	BodyBase64 string              `json:"body_base64,omitempty"` // binary body; excludes body
+	Injections []Injection         `json:"injections,omitempty"`
+	Captures   []Capture           `json:"captures,omitempty"`
+	AgentID    string              `json:"agent_id,omitempty"`
+	AgentToken string              `json:"agent_token,omitempty"`
+	DryRun     bool                `json:"dry_run,omitempty"`   // check and build the request, but don't send it
+	Select     string              `json:"select,omitempty"`    // JSON projection of the response body
+	MaxBytes   int                 `json:"max_bytes,omitempty"` // cap on the response body
+	Cursor     string              `json:"cursor,omitempty"`    // next slice of a truncated response; excludes the rest
+}
+
+// Injection is one credential to inject.
+type Injection struct {
+	Style     string `json:"style"`            // bearer, basic, header, query, body, form
+	Target    string `json:"target,omitempty"` // header name, query param, JSON path or form key
+	SecretKey string `json:"secret_key"`
+}
+
+// Capture stores a response value in the keyring under SecretKey, and
+// replaces it in the response with a placeholder.
+type Capture struct {
+	Path      string `json:"path"`
+	SecretKey string `json:"secret_key"`
+}
+
+// CallResponse is the envelope POST /v1/call returns for every upstream
+// response and every blocked call.
+type CallResponse struct {
+	Status     int                 `json:"status"`
+	Headers    map[string][]string `json:"headers"`
+	Body       string              `json:"body,omitempty"`
+	BodyBase64 string              `json:"body_base64,omitempty"` // set instead of body when it is not UTF-8
+	Redacted   bool                `json:"redacted"`              // a secret value was removed from the body
+	Captured   []string            `json:"captured,omitempty"`
+	Blocked    string              `json:"blocked,omitempty"` // block reason when the proxy refused the call
+	Explain    *Explanation        `json:"explain,omitempty"` // set for dry runs
+	Shaping    *Shaping            `json:"shaping,omitempty"` // set when select or max_bytes applied
+	Cached     bool                `json:"cached,omitempty"`  // answered from the response cache
+}
+
+// BatchRequest is the JSON body of POST /v1/batch.
+type BatchRequest struct {
+	Calls       []CallRequest `json:"calls"`
+	Concurrency int           `json:"concurrency,omitempty"` // the proxy's default if zero
+}
+
+// BatchResponse holds one result per call, in request order.
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// BatchResult is the envelope of one call in a batch, or why the call
+// could not be made.
+type BatchResult struct {
+	Response *CallResponse `json:"response,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Explanation is what a dry run reports: how each check decided, and the
+// request that would have been sent.
+type Explanation struct {
+	WouldSend bool             `json:"would_send"`
+	Steps     []ExplainStep    `json:"steps"`
+	Request   *OutboundPreview `json:"request,omitempty"` // nil when the call would be blocked
+}
+
+// ExplainStep is the outcome of one stage of the pipeline.
+type ExplainStep struct {
+	Stage   string `json:"stage"`   // allowlist, policy, budget, approval, secrets, inject
+	Outcome string `json:"outcome"` // pass, skip, hold or block
+	Detail  string `json:"detail"`
+}
+
+// OutboundPreview is the request a dry run built, with every injected value
+// replaced by the name of its secret, e.g. "Bearer <STRIPE_KEY>".
+type OutboundPreview struct {
+	Method  string              `json:"method"`
+	URL     string              `json:"url"`
+	Headers map[string][]string `json:"headers"`
+	Body    string              `json:"body,omitempty"`
+}
+
+// String renders the explanation for a terminal or an agent.
+func (x *Explanation) String() string {
+	var sb strings.Builder
+	if x.WouldSend {
+		sb.WriteString("DRY RUN: the request would be sent\n\n")
+	} else {
+		sb.WriteString("DRY RUN: the request would be blocked\n\n")
+	}
+	for _, s := range x.Steps {
+		fmt.Fprintf(&sb, "  %-9s %-5s %s\n", s.Stage, strings.ToUpper(s.Outcome), s.Detail)
+	}
+	if r := x.Request; r != nil {
+		fmt.Fprintf(&sb, "\n%s %s\n", r.Method, r.URL)
+		keys := make([]string, 0, len(r.Headers))
+		for k := range r.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range r.Headers[k] {
+				fmt.Fprintf(&sb, "%s: %s\n", k, v)
+			}
+		}
+		if r.Body != "" {
+			fmt.Fprintf(&sb, "\n%s\n", r.Body)
+		}
+	}
+	return sb.String()
+}
+
+// Shaping describes how a response body was cut down to fit an LLM's context.
+// Offset, Length and Total count bytes of the shaped body: the redacted body,
+// projected by Select when that succeeded.
+type Shaping struct {
+	Select      string `json:"select,omitempty"`
+	SelectError string `json:"select_error,omitempty"` // why Select was not applied; the whole body is kept
+	Offset      int    `json:"offset"`
+	Length      int    `json:"length"`
+	Total       int    `json:"total"`
+	NextCursor  string `json:"next_cursor,omitempty"` // fetches the next slice; empty on the last one
+}
+
+// Notice explains the shaping to the agent, or returns "" when there is
+// nothing to explain.
+func (s *Shaping) Notice() string {
+	var notes []string
+	if s.SelectError != "" {
+		notes = append(notes, fmt.Sprintf("[select %q was not applied: %s; showing the whole body]", s.Select, s.SelectError))
+	}
+	end := s.Offset + s.Length
+	switch {
+	case s.NextCursor != "":
+		notes = append(notes, fmt.Sprintf("[Truncated: showing bytes %d-%d of %d. Call again with cursor %q for the next slice.]", s.Offset, end, s.Total, s.NextCursor))
+	case s.Offset > 0:
+		notes = append(notes, fmt.Sprintf("[Showing bytes %d-%d of %d, the last slice.]", s.Offset, end, s.Total))
+	case s.Length < s.Total:
+		notes = append(notes, fmt.Sprintf("[Truncated: showing bytes 0-%d of %d. The rest cannot be fetched.]", end, s.Total))
+	}
+	return strings.Join(notes, "\n")
+}
@@ -87,7 +87,28 @@ func (m *EnvManager) parseValue(val string) string {
 }
 
 // Write merges the provided secrets into .env and updates .env.example.
+// Proxy-only secrets are refused: they are left out and the attempt is audited.
 func (m *EnvManager) Write(newSecrets map[string]string) error {
+	proxyOnly, err := ProxyOnlyKeys(currentProjectID())
+	if err != nil {
+		return err
+	}
+	if len(proxyOnly) > 0 {
+		var blocked []string
+		allowed := make(map[string]string, len(newSecrets))
+		for k, v := range newSecrets {
+			if proxyOnly[k] {
+				blocked = append(blocked, k)
+				continue
+			}
+			allowed[k] = v
+		}
+		if len(blocked) > 0 {
+			AuditBlockedExport(blocked, "DOTENV", m.EnvPath)
+			newSecrets = allowed
+		}
+	}
+
 	mode := config.GetStorageMode()
 	if mode != 1 {
 		if err := m.updateFile(m.EnvPath, newSecrets, false); err != nil {
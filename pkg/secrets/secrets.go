@@ -32,7 +32,14 @@ func (s *Service) Set(key, value string) error {
 }
 
 // BatchSet adds or updates multiple secrets in a single API call.
+// Existing secrets keep their sensitivity class.
 func (s *Service) BatchSet(kv map[string]string) error {
+	return s.BatchSetClass(kv, "")
+}
+
+// BatchSetClass is BatchSet with a sensitivity class (ClassProxyOnly or
+// ClassDefault) applied to every key. An empty class keeps each key's current one.
+func (s *Service) BatchSetClass(kv map[string]string, class string) error {
 	project, err := config.LoadProjectConfig()
 	if err != nil || project.ProjectID == "" {
 		return fmt.Errorf("batch set: no project configured in current directory")
@@ -43,17 +50,31 @@ func (s *Service) BatchSet(kv map[string]string) error {
 		return fmt.Errorf("batch set: %w", err)
 	}
 
+	// Without a class given, keys already in the cloud keep the metadata stored
+	// there: the keychain's copy of their class may be missing or stale.
+	var cloud map[string]SecretMetadata
+	if class == "" {
+		if cloud, err = s.cloudSecrets(); err != nil {
+			return fmt.Errorf("batch set: %w", err)
+		}
+	}
+
 	var apiSecrets []map[string]string
 	for k, v := range kv {
-		// 1. Encrypt for cloud
+		// 1. Encrypt value and metadata for cloud
 		encryptedValue, err := crypto.EncryptSecret(v, workspaceKey)
 		if err != nil {
 			return fmt.Errorf("batch set: encryption failed for %s: %w", k, err)
 		}
-		apiSecrets = append(apiSecrets, map[string]string{"key": k, "value": encryptedValue})
+		keyClass, encryptedMeta, err := metadataFor(project.ProjectID, k, class, cloud, workspaceKey)
+		if err != nil {
+			return fmt.Errorf("batch set: %w", err)
+		}
+		apiSecrets = append(apiSecrets, map[string]string{"key": k, "value": encryptedValue, "metadata": encryptedMeta})
 
 		// 2. Store in OS Keychain (for Proxy support)
 		_ = keyring.SetSecret(project.ProjectID, k, v)
+		_ = keyring.SetSecretClass(project.ProjectID, k, keyClass)
 	}
 
 	// 3. Sync to cloud
@@ -72,8 +93,8 @@ func (s *Service) BatchSet(kv map[string]string) error {
 		return s.API.DecodeError(resp)
 	}
 
-	// 4. Write to .env
-	if err := s.Env.Write(kv); err != nil {
+	// 4. Write to .env (proxy-only secrets never land there)
+	if err := s.writeExportable(project.ProjectID, kv); err != nil {
 		return fmt.Errorf("batch set: failed to update .env: %w", err)
 	}
 
@@ -87,6 +108,13 @@ func (s *Service) Get(key string) (string, error) {
 		return "", fmt.Errorf("get secret: no project configured in current directory")
 	}
 
+	if proxyOnly, err := IsProxyOnly(project.ProjectID, key); err != nil {
+		return "", fmt.Errorf("get secret: %w", err)
+	} else if proxyOnly {
+		AuditBlockedExport([]string{key}, "GET", "secrets get "+key)
+		return "", fmt.Errorf("get secret: %w", &ProxyOnlyError{Keys: []string{key}})
+	}
+
 	// Try keychain first (fast paths)
 	if val, err := keyring.GetSecret(project.ProjectID, key); err == nil {
 		return val, nil
@@ -108,7 +136,8 @@ func (s *Service) Get(key string) (string, error) {
 
 	var res struct {
 		Data struct {
-			Value string `json:"value"`
+			Value    string `json:"value"`
+			Metadata string `json:"metadata"`
 		} `json:"data"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
@@ -120,6 +149,12 @@ func (s *Service) Get(key string) (string, error) {
 		return "", err
 	}
 
+	if class := classOf(res.Data.Metadata, wsKey); class == ClassProxyOnly {
+		_ = keyring.SetSecretClass(project.ProjectID, key, class)
+		AuditBlockedExport([]string{key}, "GET", "secrets get "+key)
+		return "", fmt.Errorf("get secret: %w", &ProxyOnlyError{Keys: []string{key}})
+	}
+
 	plaintext, err := crypto.DecryptSecret(res.Data.Value, wsKey)
 	if err != nil {
 		return "", fmt.Errorf("get secret: decrypt: %w", err)
@@ -134,12 +169,37 @@ func (s *Service) Get(key string) (string, error) {
 // SecretMetadata holds the secret metadata from the API.
 type SecretMetadata struct {
 	Key       string `json:"key"`
-	Value     string `json:"value,omitempty"` // Encrypted value
+	Value     string `json:"value,omitempty"`    // Encrypted value
+	Metadata  string `json:"metadata,omitempty"` // Encrypted secretMeta
 	UpdatedAt string `json:"updated_at"`
+	Class     string `json:"-"` // Decrypted sensitivity class, "" if none
 }
 
-// List returns all secret keys for the project. If showValues is true, it decrypts them.
+// List returns all secret keys for the project. If showValues is true, it decrypts
+// them; proxy-only secrets are listed with an empty Value.
 func (s *Service) List(showValues bool) ([]SecretMetadata, error) {
+	list, err := s.list(showValues)
+	if err != nil || !showValues {
+		return list, err
+	}
+
+	var blocked []string
+	for i := range list {
+		if list[i].Class == ClassProxyOnly {
+			list[i].Value = ""
+			blocked = append(blocked, list[i].Key)
+		}
+	}
+	if len(blocked) > 0 {
+		AuditBlockedExport(blocked, "LIST", "secrets list")
+	}
+	return list, nil
+}
+
+// list fetches the project's secrets and refreshes the keychain's class cache.
+// With decrypt set, every value is decrypted, proxy-only ones included; callers
+// must only hand those to the keychain.
+func (s *Service) list(decrypt bool) ([]SecretMetadata, error) {
 	project, err := config.LoadProjectConfig()
 	if err != nil || project.ProjectID == "" {
 		return nil, fmt.Errorf("list secrets: no project configured in current directory")
@@ -166,13 +226,19 @@ func (s *Service) List(showValues bool) ([]SecretMetadata, error) {
 		return nil, fmt.Errorf("list secrets: failed to parse response: %w", err)
 	}
 
-	if showValues {
-		wsKey, err := config.GetProjectWorkspaceKey()
-		if err != nil {
+	wsKey, err := config.GetProjectWorkspaceKey()
+	if err != nil {
+		if decrypt {
 			return nil, err
 		}
+		return res.Data.Secrets, nil
+	}
 
-		for i, s := range res.Data.Secrets {
+	for i, s := range res.Data.Secrets {
+		res.Data.Secrets[i].Class = classOf(s.Metadata, wsKey)
+		_ = keyring.SetSecretClass(project.ProjectID, s.Key, res.Data.Secrets[i].Class)
+
+		if decrypt {
 			if plaintext, err := crypto.DecryptSecret(s.Value, wsKey); err == nil {
 				res.Data.Secrets[i].Value = plaintext
 			}
@@ -182,6 +248,43 @@ func (s *Service) List(showValues bool) ([]SecretMetadata, error) {
 	return res.Data.Secrets, nil
 }
 
+// cloudSecrets returns the project's secrets in the cloud by key, without
+// their values, and refreshes the keychain's class cache.
+func (s *Service) cloudSecrets() (map[string]SecretMetadata, error) {
+	list, err := s.list(false)
+	if err != nil {
+		return nil, err
+	}
+	byKey := make(map[string]SecretMetadata, len(list))
+	for _, sec := range list {
+		byKey[sec.Key] = sec
+	}
+	return byKey, nil
+}
+
+// metadataFor returns the class and encrypted metadata to upload for key. A
+// given class is used as is; otherwise a key in cloud keeps its stored
+// metadata, and a new key takes its class from the keychain.
+func metadataFor(projectID, key, class string, cloud map[string]SecretMetadata, workspaceKey []byte) (string, string, error) {
+	if existing, ok := cloud[key]; ok && class == "" {
+		return existing.Class, existing.Metadata, nil
+	}
+	if class == "" {
+		var err error
+		if class, err = keyring.GetSecretClass(projectID, key); err != nil {
+			return "", "", fmt.Errorf("class of %s: %w", key, err)
+		}
+	}
+	if class == ClassDefault {
+		class = ""
+	}
+	encrypted, err := encryptMeta(class, workspaceKey)
+	if err != nil {
+		return "", "", fmt.Errorf("encryption failed for %s metadata: %w", key, err)
+	}
+	return class, encrypted, nil
+}
+
 // Pull downloads secrets from the cloud and updates .env + Keychain.
 // If targetKeys is nil, all secrets are pulled.
 // If targetKeys is non-nil (even if empty), only those specific keys are pulled.
@@ -191,7 +294,7 @@ func (s *Service) Pull(targetKeys []string) error {
 		return nil
 	}
 
-	secrets, err := s.List(true)
+	secrets, err := s.list(true)
 	if err != nil {
 		return err
 	} 
@@ -215,7 +318,7 @@ func (s *Service) Pull(targetKeys []string) error {
 		// Even if empty, we want to ensure .env footprint is laid down
 	}
 
-	if err := s.Env.Write(secretsMap); err != nil {
+	if err := s.writeExportable(project.ProjectID, secretsMap); err != nil {
 		return fmt.Errorf("pull: failed to update local files: %w", err)
 	}
 
@@ -254,13 +357,24 @@ func (s *Service) Push() error {
 		return fmt.Errorf("push secrets: %w", err)
 	}
 
+	// Pushed values never change a class: keys already in the cloud keep the
+	// metadata stored there, whatever this machine's keychain says.
+	cloud, err := s.cloudSecrets()
+	if err != nil {
+		return fmt.Errorf("push secrets: %w", err)
+	}
+
 	var apiSecrets []map[string]string
 	for k, v := range localSecrets {
 		encrypted, err := crypto.EncryptSecret(v, workspaceKey)
 		if err != nil {
 			return fmt.Errorf("push secrets: encryption failed for key %s: %w", k, err)
 		}
-		apiSecrets = append(apiSecrets, map[string]string{"key": k, "value": encrypted})
+		_, encryptedMeta, err := metadataFor(project.ProjectID, k, "", cloud, workspaceKey)
+		if err != nil {
+			return fmt.Errorf("push secrets: %w", err)
+		}
+		apiSecrets = append(apiSecrets, map[string]string{"key": k, "value": encrypted, "metadata": encryptedMeta})
 		// Sync to keychain
 		_ = keyring.SetSecret(project.ProjectID, k, v)
 	}
@@ -346,11 +460,24 @@ func (s *Service) Diff() (*DiffResult, error) {
 		}
 	}
 
-	cloud, err := s.List(true)
+	cloud, err := s.list(true)
 	if err != nil {
 		return nil, err
 	}
 
+	// Proxy-only secrets are never in .env; their local copy is the keychain's.
+	if config.GetStorageMode() != 1 {
+		projectID := currentProjectID()
+		for _, c := range cloud {
+			if c.Class != ClassProxyOnly {
+				continue
+			}
+			if val, err := keyring.GetSecret(projectID, c.Key); err == nil {
+				local[c.Key] = val
+			}
+		}
+	}
+
 	res := &DiffResult{
 		Changed: make(map[string][2]string),
 	}
@@ -381,3 +508,23 @@ func (s *Service) Diff() (*DiffResult, error) {
 
 	return res, nil
 }
+
+// writeExportable writes secrets to .env, leaving out proxy-only ones and removing
+// any value a proxy-only secret still has there from before it was classified.
+func (s *Service) writeExportable(projectID string, kv map[string]string) error {
+	proxyOnly, err := ProxyOnlyKeys(projectID)
+	if err != nil {
+		return err
+	}
+	exportable := make(map[string]string, len(kv))
+	for k, v := range kv {
+		if proxyOnly[k] {
+			if err := s.Env.Delete(k); err != nil {
+				return err
+			}
+			continue
+		}
+		exportable[k] = v
+	}
+	return s.Env.Write(exportable)
+}
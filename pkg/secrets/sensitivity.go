@@ -0,0 +1,148 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/The-17/agentsecrets/pkg/audit"
+	"github.com/The-17/agentsecrets/pkg/config"
+	"github.com/The-17/agentsecrets/pkg/crypto"
+	"github.com/The-17/agentsecrets/pkg/keyring"
+)
+
+// Sensitivity classes. A proxy-only secret can be injected into API calls by the
+// proxy but is never handed out as a value: not to `env`, `exec`, `secrets get`,
+// .env files or List(showValues=true).
+const (
+	ClassDefault   = "default"
+	ClassProxyOnly = "proxy-only"
+)
+
+// ProxyOnlyError is returned when a proxy-only secret is asked for as a value.
+type ProxyOnlyError struct {
+	Keys []string
+}
+
+func (e *ProxyOnlyError) Error() string {
+	if len(e.Keys) == 1 {
+		return fmt.Sprintf("%s is a proxy-only secret and can only be used through the proxy", e.Keys[0])
+	}
+	return fmt.Sprintf("%s are proxy-only secrets and can only be used through the proxy", strings.Join(e.Keys, ", "))
+}
+
+// ParseClass validates a class given on the command line.
+func ParseClass(class string) (string, error) {
+	switch class {
+	case ClassDefault, ClassProxyOnly:
+		return class, nil
+	}
+	return "", fmt.Errorf("unknown secret class %q (use %s or %s)", class, ClassProxyOnly, ClassDefault)
+}
+
+// secretMeta is the per-secret metadata stored encrypted in the cloud, so a class
+// set by one workspace member applies on every member's machine.
+type secretMeta struct {
+	Class string `json:"class,omitempty"`
+}
+
+func encryptMeta(class string, workspaceKey []byte) (string, error) {
+	data, err := json.Marshal(secretMeta{Class: class})
+	if err != nil {
+		return "", err
+	}
+	return crypto.EncryptSecret(string(data), workspaceKey)
+}
+
+// decryptMeta returns the class stored in encrypted metadata. Secrets created
+// before classes existed have no metadata and are exportable. Metadata that
+// cannot be read, or names a class this version doesn't know, is an error.
+func decryptMeta(encrypted string, workspaceKey []byte) (string, error) {
+	if encrypted == "" {
+		return "", nil
+	}
+	plaintext, err := crypto.DecryptSecret(encrypted, workspaceKey)
+	if err != nil {
+		return "", fmt.Errorf("decrypt secret metadata: %w", err)
+	}
+	var meta secretMeta
+	if err := json.Unmarshal([]byte(plaintext), &meta); err != nil {
+		return "", fmt.Errorf("parse secret metadata: %w", err)
+	}
+	switch meta.Class {
+	case "", ClassDefault, ClassProxyOnly:
+		return meta.Class, nil
+	}
+	return "", fmt.Errorf("unknown secret class %q", meta.Class)
+}
+
+// classOf returns the class stored in encrypted metadata. A class that can't
+// be decided is proxy-only, so a damaged or foreign blob never makes a
+// secret exportable.
+func classOf(encrypted string, workspaceKey []byte) string {
+	class, err := decryptMeta(encrypted, workspaceKey)
+	if err != nil {
+		return ClassProxyOnly
+	}
+	return class
+}
+
+// IsProxyOnly reports whether a secret in the project is proxy-only, using the
+// class cached in the keychain by the last set or pull. If the cache can't be
+// read it returns an error, and true, so the secret is never exported.
+func IsProxyOnly(projectID, key string) (bool, error) {
+	class, err := keyring.GetSecretClass(projectID, key)
+	if err != nil {
+		return true, fmt.Errorf("cannot tell whether %s is proxy-only: %w", key, err)
+	}
+	return class == ClassProxyOnly, nil
+}
+
+// ProxyOnlyKeys returns the proxy-only keys of a project. If the class cache
+// can't be read, nothing may be exported and it returns an error.
+func ProxyOnlyKeys(projectID string) (map[string]bool, error) {
+	classes, err := keyring.GetSecretClasses(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("cannot tell which secrets are proxy-only: %w", err)
+	}
+	keys := make(map[string]bool)
+	for k, class := range classes {
+		if class == ClassProxyOnly {
+			keys[k] = true
+		}
+	}
+	return keys, nil
+}
+
+// AuditBlockedExport records an attempt to read proxy-only secrets as values.
+// via names the export path ("ENV", "EXEC", "GET", "DOTENV", "LIST").
+func AuditBlockedExport(keys []string, via, target string) {
+	logger, err := audit.NewLogger("")
+	if err != nil {
+		return // non-critical
+	}
+	defer logger.Close()
+
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	_ = logger.Log(audit.Event{
+		Timestamp:  time.Now().UTC(),
+		SecretKeys: sorted,
+		Method:     via,
+		TargetURL:  target,
+		AuthStyles: []string{"export"},
+		Status:     "BLOCKED",
+		Reason:     "proxy_only_secret",
+	})
+}
+
+// currentProjectID returns the project in the current directory, or "".
+func currentProjectID() string {
+	project, err := config.LoadProjectConfig()
+	if err != nil || project == nil {
+		return ""
+	}
+	return project.ProjectID
+}
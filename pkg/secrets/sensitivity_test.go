@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"testing"
+
+	"github.com/The-17/agentsecrets/pkg/crypto"
+)
+
+func TestSecretMetaRoundtrip(t *testing.T) {
+	wsKey := make([]byte, crypto.KeySize)
+	for i := range wsKey {
+		wsKey[i] = byte(i)
+	}
+
+	encrypted, err := encryptMeta(ClassProxyOnly, wsKey)
+	if err != nil {
+		t.Fatalf("encryptMeta failed: %v", err)
+	}
+	if got, err := decryptMeta(encrypted, wsKey); err != nil || got != ClassProxyOnly {
+		t.Errorf("decryptMeta = %q, %v, want %q", got, err, ClassProxyOnly)
+	}
+
+	// Secrets without metadata are exportable
+	if got, err := decryptMeta("", wsKey); err != nil || got != "" {
+		t.Errorf("decryptMeta(\"\") = %q, %v, want empty", got, err)
+	}
+	if got := classOf("", wsKey); got != "" {
+		t.Errorf("classOf(\"\") = %q, want empty", got)
+	}
+}
+
+func TestUndecidableClassIsProxyOnly(t *testing.T) {
+	wsKey := make([]byte, crypto.KeySize)
+	encrypted, _ := encryptMeta(ClassProxyOnly, wsKey)
+	foreign, _ := crypto.EncryptSecret(`{"class":"hardware-only"}`, wsKey)
+	notJSON, _ := crypto.EncryptSecret("not json", wsKey)
+
+	otherKey := make([]byte, crypto.KeySize)
+	otherKey[0] = 1
+	for name, blob := range map[string]string{
+		"wrong key":     encrypted,
+		"corrupt":       "bm90IGEgYmxvYg==",
+		"unknown class": foreign,
+		"not JSON":      notJSON,
+	} {
+		key := wsKey
+		if name == "wrong key" {
+			key = otherKey
+		}
+		if _, err := decryptMeta(blob, key); err == nil {
+			t.Errorf("%s: decryptMeta returned no error", name)
+		}
+		if got := classOf(blob, key); got != ClassProxyOnly {
+			t.Errorf("%s: classOf = %q, want %q", name, got, ClassProxyOnly)
+		}
+	}
+}
+
+func TestMetadataForKeepsCloudClass(t *testing.T) {
+	wsKey := make([]byte, crypto.KeySize)
+	stored, _ := encryptMeta(ClassProxyOnly, wsKey)
+	cloud := map[string]SecretMetadata{"STRIPE_KEY": {Key: "STRIPE_KEY", Metadata: stored, Class: ClassProxyOnly}}
+
+	// A push never re-classifies: the cloud's metadata is sent back unchanged
+	class, meta, err := metadataFor("project", "STRIPE_KEY", "", cloud, wsKey)
+	if err != nil || class != ClassProxyOnly || meta != stored {
+		t.Errorf("metadataFor = %q, %q, %v, want the stored metadata", class, meta, err)
+	}
+
+	// An explicit class replaces it
+	class, meta, err = metadataFor("project", "STRIPE_KEY", ClassDefault, cloud, wsKey)
+	if err != nil || class != "" || meta == stored {
+		t.Errorf("metadataFor(default) = %q, %v", class, err)
+	}
+	if got, _ := decryptMeta(meta, wsKey); got != "" {
+		t.Errorf("class after reclassifying = %q, want empty", got)
+	}
+}
+
+func TestParseClass(t *testing.T) {
+	for _, class := range []string{ClassProxyOnly, ClassDefault} {
+		if got, err := ParseClass(class); err != nil || got != class {
+			t.Errorf("ParseClass(%q) = %q, %v", class, got, err)
+		}
+	}
+	if _, err := ParseClass("secret"); err == nil {
+		t.Error("ParseClass should reject unknown classes")
+	}
+}
+
+func TestProxyOnlyErrorMessage(t *testing.T) {
+	err := &ProxyOnlyError{Keys: []string{"STRIPE_KEY"}}
+	want := "STRIPE_KEY is a proxy-only secret and can only be used through the proxy"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}